@@ -2,66 +2,492 @@ package cli
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
 
-	"github.com/spf13/cobra"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"github.com/yourorg/pdf2letterexpress/internal/processor"
+	"github.com/yourorg/pdf2letterexpress/internal/report"
 	"github.com/yourorg/pdf2letterexpress/internal/utils"
 )
 
 type Config struct {
-	InputFile  string
-	OutputFile string
-	Verbose    bool
-	LogLevel   string
+	InputFile     string
+	OutputFile    string
+	Verbose       bool
+	LogLevel      string
+	Report        string
+	ReportFile    string
+	DryRun        bool
+	DebugOverlay  bool
+	Archive       string
+	Merge         bool
+	NewSheet      bool
+	Duplex        bool
+	RemoveBlank   bool
+	BlankRaster   bool
+	SectionStart  []int
+	Grammage      float64
+	Envelope      string
+	PriceTable    string
+	Grayscale     bool
+	ColorMode     string
+	DPI           int
+	JPEGQuality   int
+	MaxSize       string
+	FixFonts      string
+	Transparency  bool
+	Sanitize      bool
+	AutoCrop      bool
+	OfficeTimeout time.Duration
+	FontDir       string
+	Password      string
+	PasswordFile  string
+	AuditLog      string
+
+	// Output overrides the generated output file name of a single or
+	// combined letter, "-" for stdout
+	Output string
+
+	// options are the flags given on the command line, for the audit log
+	options map[string]string
+
+	appName    string
+	appVersion string
 }
 
 func NewRootCommand(appName, appVersion, appDesc string) *cobra.Command {
-	config := &Config{}
+	config := &Config{appName: appName, appVersion: appVersion}
 
 	rootCmd := &cobra.Command{
 		Use:     fmt.Sprintf("%s <PDF-file>", appName),
 		Short:   appDesc,
 		Long:    fmt.Sprintf("%s\n\n%s", appDesc, "Automatically scales PDF content to create 5mm margins on all sides for LetterExpress compatibility."),
 		Version: appVersion,
-		Args:    cobra.ExactArgs(1),
+		Args:    cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runConversion(config, args[0])
+			config.options = changedFlags(cmd)
+			return runBatch(config, args)
 		},
 		SilenceUsage: true,
 	}
 
+	rootCmd.AddCommand(newPreviewCommand(config))
+	rootCmd.AddCommand(newMergeCommand(config))
+	rootCmd.AddCommand(newSplitCommand(config))
+	rootCmd.AddCommand(newCombineCommand(config))
+	rootCmd.AddCommand(newCheckCommand(config))
+	rootCmd.AddCommand(newSanitizeCommand(config))
+	rootCmd.AddCommand(newComposeCommand(config))
+	rootCmd.AddCommand(newDoctorCommand(config))
+	rootCmd.AddCommand(newVerifyAuditCommand(config))
+
 	rootCmd.PersistentFlags().BoolVarP(&config.Verbose, "verbose", "v", false, "Enable verbose logging")
 	rootCmd.PersistentFlags().StringVar(&config.LogLevel, "log-level", "info", "Set log level (debug, info, warn, error)")
+	rootCmd.PersistentFlags().StringVar(&config.Password, "password", "", "Password of encrypted input PDFs (visible to other users, prefer --password-file)")
+	rootCmd.PersistentFlags().StringVar(&config.PasswordFile, "password-file", "", "File containing the password of encrypted input PDFs")
+	addConversionFlags(rootCmd, config)
+	rootCmd.Flags().StringVarP(&config.Output, "output", "o", "", "Output file, - for stdout (default: input with \" - converted\")")
+	rootCmd.Flags().BoolVar(&config.Merge, "merge", false, "Combine all input files into a single letter")
+	rootCmd.Flags().BoolVar(&config.NewSheet, "new-sheet", false, "With --merge, start every file on a new sheet in duplex")
 
 	return rootCmd
 }
 
-func runConversion(config *Config, inputFile string) error {
+// addConversionFlags adds the flags shared by all commands that convert into a letter
+func addConversionFlags(cmd *cobra.Command, config *Config) {
+	cmd.Flags().StringVar(&config.Report, "report", report.FormatText, "Result report format (text, json)")
+	cmd.Flags().BoolVar(&config.DryRun, "dry-run", false, "Plan the conversion and print it without writing any files")
+	cmd.Flags().BoolVar(&config.DebugOverlay, "debug-overlay", false, "Also write an annotated copy with safe-area guides (never send it)")
+	cmd.Flags().StringVar(&config.Archive, "archive", "", "Also write a PDF/A archival copy (pdfa-2b, pdfa-3b)")
+	cmd.Flags().StringVar(&config.ReportFile, "report-file", "", "Write the result report to this file instead of stdout")
+	cmd.Flags().BoolVar(&config.AutoCrop, "auto-crop", false, "Remove dark scanner borders around JPEG, PNG and TIFF inputs")
+	cmd.Flags().DurationVar(&config.OfficeTimeout, "office-timeout", processor.DefaultOfficeTimeout, "Time LibreOffice gets to convert a DOCX, ODT, RTF or TXT input")
+	addAuditFlag(cmd, config)
+	addProcessingFlags(cmd, config)
+}
+
+// addAuditFlag adds the audit log flag to every command that writes letters
+func addAuditFlag(cmd *cobra.Command, config *Config) {
+	cmd.Flags().StringVar(&config.AuditLog, "audit-log", "", "Append an entry for every conversion to this hash-chained JSONL audit log")
+}
+
+// addProcessingFlags adds the flags that control how a PDF is turned into a letter
+func addProcessingFlags(cmd *cobra.Command, config *Config) {
+	cmd.Flags().BoolVar(&config.Duplex, "duplex", false, "Pad to an even page count and start sections on a front side")
+	cmd.Flags().IntSliceVar(&config.SectionStart, "section-start", nil, "With --duplex, pages that must start on a front side (e.g. 3,5)")
+	cmd.Flags().BoolVar(&config.RemoveBlank, "remove-blank", false, "Remove blank pages")
+	cmd.Flags().BoolVar(&config.BlankRaster, "blank-raster", false, "Also detect near-white pages such as empty scans by rendering them")
+	cmd.Flags().BoolVar(&config.Grayscale, "force-grayscale", false, "Convert the output so that it prints black-and-white (needs Ghostscript)")
+	cmd.Flags().StringVar(&config.ColorMode, "color-mode", processor.ColorModeColor, "Raster engine color mode (color, grayscale, bitonal, auto)")
+	cmd.Flags().IntVar(&config.DPI, "dpi", processor.DefaultRasterDPI, "Raster engine resolution in dpi")
+	cmd.Flags().IntVar(&config.JPEGQuality, "jpeg-quality", processor.DefaultJPEGQuality, "JPEG quality of color and grayscale pages (1-100)")
+	cmd.Flags().StringVar(&config.MaxSize, "max-size", "", "Size limit of the output, e.g. 10MB; quality is lowered until it fits")
+	cmd.Flags().StringVar(&config.FixFonts, "fix-fonts", "", "Fix fonts that are not embedded or Type 3 (embed, rasterize)")
+	cmd.Flags().BoolVar(&config.Transparency, "flatten-transparency", false, "Rasterize pages with transparency when the vector engine is used (needs ImageMagick)")
+	cmd.Flags().BoolVar(&config.Sanitize, "sanitize", false, "Remove JavaScript, actions, embedded files, links and metadata from the output")
+	cmd.Flags().StringVar(&config.FontDir, "font-dir", processor.DefaultFontDir, "Directory with TrueType fonts to embed as substitutes")
+	addPostageFlags(cmd, config)
+}
+
+// addPostageFlags adds the flags that describe paper and envelope for the postage estimate
+func addPostageFlags(cmd *cobra.Command, config *Config) {
+	cmd.Flags().Float64Var(&config.Grammage, "grammage", 80, "Paper grammage in g/m² for the postage estimate")
+	cmd.Flags().StringVar(&config.Envelope, "envelope", processor.EnvelopeDL, "Envelope for the postage estimate (DL, C4)")
+	cmd.Flags().StringVar(&config.PriceTable, "price-table", "", "JSON file with postage prices in EUR per class")
+}
+
+// PasswordEnv is the environment variable read for the password of
+// encrypted inputs if neither --password nor --password-file is given
+const PasswordEnv = "PDF2LX_PASSWORD"
+
+// AuditLogEnv is the environment variable read for the audit log if
+// --audit-log is not given
+const AuditLogEnv = "PDF2LX_AUDIT_LOG"
+
+// changedFlags returns the flags given on the command line except the password
+func changedFlags(cmd *cobra.Command) map[string]string {
+	flags := make(map[string]string)
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if f.Name != "password" {
+			flags[f.Name] = f.Value.String()
+		}
+	})
+	return flags
+}
+
+// audit appends the entry of a conversion to the audit log, if there is one
+func (config *Config) audit(r *report.Report) error {
+	auditLog := config.AuditLog
+	if auditLog == "" {
+		auditLog = os.Getenv(AuditLogEnv)
+	}
+	if auditLog == "" || r.DryRun {
+		return nil
+	}
+	if err := report.AppendAudit(auditLog, report.NewAuditEntry(r, config.options)); err != nil {
+		return fmt.Errorf("audit log: %w", err)
+	}
+	return nil
+}
+
+// auditLetters appends an entry for every letter a subcommand wrote to the
+// audit log, and one for err if the command failed. It returns the first
+// audit error.
+func (config *Config) auditLetters(inputFile string, letters []*processor.Result, err error) error {
+	var auditErr error
+	for _, result := range letters {
+		if e := config.audit(report.New(config.appName, config.appVersion, result, nil)); e != nil && auditErr == nil {
+			auditErr = e
+		}
+	}
+	if err != nil {
+		now := time.Now()
+		failed := &processor.Result{InputFile: inputFile, StartedAt: now, FinishedAt: now}
+		if e := config.audit(report.New(config.appName, config.appVersion, failed, err)); e != nil && auditErr == nil {
+			auditErr = e
+		}
+	}
+	return auditErr
+}
+
+// newReport returns the report of a conversion after appending it to the
+// audit log. If the entry cannot be written, the conversion fails with that
+// error, unless it failed already.
+func (config *Config) newReport(pipe *pipe, result *processor.Result, err error) (*report.Report, error) {
+	r := report.New(config.appName, config.appVersion, result, err)
+	pipe.rename(r)
+
+	auditErr := config.audit(r)
+	switch {
+	case auditErr == nil:
+		return r, err
+	case err != nil:
+		logrus.WithError(auditErr).WithField("input", result.InputFile).Error("Audit log entry failed")
+		return r, err
+	}
+
+	r = report.New(config.appName, config.appVersion, result, auditErr)
+	pipe.rename(r)
+	return r, auditErr
+}
+
+// password returns the password for encrypted inputs from --password,
+// --password-file or PasswordEnv, in that order
+func (config *Config) password() (string, error) {
+	if config.Password != "" {
+		return config.Password, nil
+	}
+	if config.PasswordFile != "" {
+		data, err := os.ReadFile(config.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("cannot read password file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return os.Getenv(PasswordEnv), nil
+}
+
+// processorOptions returns the processing options selected on the command line
+func (config *Config) processorOptions() (processor.Options, error) {
+	prices, err := processor.LoadPriceTable(config.PriceTable)
+	if err != nil {
+		return processor.Options{}, err
+	}
+
+	raster := processor.RasterOptions{ColorMode: config.ColorMode, DPI: config.DPI, JPEGQuality: config.JPEGQuality}
+	if err := raster.Validate(); err != nil {
+		return processor.Options{}, err
+	}
+
+	switch config.FixFonts {
+	case "", processor.FixFontsEmbed, processor.FixFontsRasterize:
+	default:
+		return processor.Options{}, fmt.Errorf("unknown --fix-fonts mode %q, expected %s or %s",
+			config.FixFonts, processor.FixFontsEmbed, processor.FixFontsRasterize)
+	}
+
+	if config.Archive != "" {
+		if err := processor.ValidateArchiveLevel(config.Archive); err != nil {
+			return processor.Options{}, err
+		}
+	}
+
+	password, err := config.password()
+	if err != nil {
+		return processor.Options{}, err
+	}
+
+	var maxSize int64
+	if config.MaxSize != "" {
+		if maxSize, err = utils.ParseSize(config.MaxSize); err != nil {
+			return processor.Options{}, err
+		}
+	}
+
+	return processor.Options{
+		Duplex:               config.Duplex,
+		SectionStarts:        config.SectionStart,
+		RemoveBlankPages:     config.RemoveBlank,
+		RasterBlankDetection: config.BlankRaster,
+		ForceGrayscale:       config.Grayscale,
+		Raster:               raster,
+		MaxSize:              maxSize,
+		FixFonts:             config.FixFonts,
+		FontDir:              config.FontDir,
+		FlattenTransparency:  config.Transparency,
+		Sanitize:             config.Sanitize,
+		AutoCrop:             config.AutoCrop,
+		OfficeTimeout:        config.OfficeTimeout,
+		ArchiveLevel:         config.Archive,
+		Password:             password,
+		Postage: processor.PostageOptions{
+			Grammage: config.Grammage,
+			Envelope: config.Envelope,
+			Prices:   prices,
+		},
+	}, nil
+}
+
+func runBatch(config *Config, inputFiles []string) error {
 	setupLogging(config)
 
+	if config.Output != "" && len(inputFiles) > 1 && !config.Merge {
+		return fmt.Errorf("-o needs a single input file or --merge")
+	}
+
+	pipe, inputFiles, err := newPipe(config, inputFiles, os.Stdin)
+	defer pipe.close()
+	if err != nil {
+		return err
+	}
+
+	// Status lines go to stderr, stdout only carries JSON reports or the letter
+	out := io.Writer(os.Stderr)
+	if config.Report == report.FormatJSON && !pipe.toStdout() {
+		out = os.Stdout
+	}
+	if config.ReportFile != "" {
+		f, err := os.Create(config.ReportFile)
+		if err != nil {
+			return fmt.Errorf("cannot create report file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	writer, err := report.NewWriter(out, config.Report)
+	if err != nil {
+		return err
+	}
+
+	if config.Merge {
+		result, err := runCombine(config, inputFiles)
+		if err == nil {
+			err = pipe.flush(result, os.Stdout)
+		}
+		r, err := config.newReport(pipe, result, err)
+		if err := writer.Write(r); err != nil {
+			return fmt.Errorf("cannot write report: %w", err)
+		}
+		return err
+	}
+
+	var firstErr error
+	failed := 0
+	for _, inputFile := range inputFiles {
+		result, err := runConversion(config, inputFile)
+		if err == nil {
+			err = pipe.flush(result, os.Stdout)
+		}
+
+		// A letter missing from the audit log counts as failed, the other
+		// files are still converted
+		r, err := config.newReport(pipe, result, err)
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			if len(inputFiles) > 1 {
+				logrus.WithError(err).WithField("input", inputFile).Error("PDF conversion failed")
+			}
+		}
+
+		if err := writer.Write(r); err != nil {
+			return fmt.Errorf("cannot write report: %w", err)
+		}
+	}
+
+	if len(inputFiles) > 1 && failed > 0 {
+		return fmt.Errorf("%d of %d files failed, first error: %w", failed, len(inputFiles), firstErr)
+	}
+	return firstErr
+}
+
+func runConversion(config *Config, inputFile string) (*processor.Result, error) {
 	logrus.WithField("input", inputFile).Info("Starting PDF conversion")
 
+	result := &processor.Result{InputFile: inputFile, StartedAt: time.Now()}
+	result.FinishedAt = result.StartedAt
+
 	if err := utils.ValidateInputFile(inputFile); err != nil {
-		return fmt.Errorf("input validation failed: %w", err)
+		return result, fmt.Errorf("input validation failed: %w", err)
 	}
 
-	outputFile := utils.GenerateOutputFilename(inputFile)
+	outputFile := config.Output
+	if outputFile == "" {
+		outputFile = utils.GenerateOutputFilename(inputFile)
+	}
 	config.InputFile = inputFile
 	config.OutputFile = outputFile
 
-	logrus.WithField("output", outputFile).Info("Output file will be created")
+	if !config.DryRun {
+		if err := utils.ValidateOutputPath(outputFile); err != nil {
+			return result, err
+		}
+		logrus.WithField("output", outputFile).Info("Output file will be created")
+	}
 
-	processor := processor.NewPDFProcessor()
-	if err := processor.ProcessPDF(inputFile, outputFile); err != nil {
-		return fmt.Errorf("PDF processing failed: %w", err)
+	opts, err := config.processorOptions()
+	if err != nil {
+		return result, err
 	}
 
-	fmt.Printf("✅ Successfully converted PDF\n")
-	fmt.Printf("📁 Input:  %s\n", inputFile)
-	fmt.Printf("📁 Output: %s\n", outputFile)
+	processor := processor.NewPDFProcessorWithOptions(opts)
+	if config.DryRun {
+		result, err := processor.Plan(inputFile, outputFile)
+		if err != nil {
+			return result, fmt.Errorf("PDF planning failed: %w", err)
+		}
+		return result, nil
+	}
+
+	result, err = processor.Process(inputFile, outputFile)
+	if err != nil {
+		return result, fmt.Errorf("PDF processing failed: %w", err)
+	}
 
+	if err := writeArchive(config, processor, result); err != nil {
+		return result, err
+	}
+	return result, writeDebugOverlay(config, processor, result)
+}
+
+// runCombine combines all input files into a single letter
+func runCombine(config *Config, inputFiles []string) (*processor.Result, error) {
+	logrus.WithField("inputs", len(inputFiles)).Info("Starting PDF combination")
+
+	result := &processor.Result{InputFile: inputFiles[0], StartedAt: time.Now()}
+	result.FinishedAt = result.StartedAt
+
+	for _, inputFile := range inputFiles {
+		if err := utils.ValidateInputFile(inputFile); err != nil {
+			return result, fmt.Errorf("input validation failed: %w", err)
+		}
+	}
+
+	outputFile := config.Output
+	if outputFile == "" {
+		outputFile = utils.GenerateOutputFilename(inputFiles[0])
+	}
+
+	if !config.DryRun {
+		if err := utils.ValidateOutputPath(outputFile); err != nil {
+			return result, err
+		}
+		logrus.WithField("output", outputFile).Info("Output file will be created")
+	}
+
+	procOpts, err := config.processorOptions()
+	if err != nil {
+		return result, err
+	}
+
+	opts := processor.CombineOptions{SheetAligned: config.NewSheet, DryRun: config.DryRun}
+	processor := processor.NewPDFProcessorWithOptions(procOpts)
+	result, err = processor.Combine(inputFiles, outputFile, opts)
+	if err != nil {
+		return result, fmt.Errorf("PDF combination failed: %w", err)
+	}
+
+	if err := writeArchive(config, processor, result); err != nil {
+		return result, err
+	}
+	return result, writeDebugOverlay(config, processor, result)
+}
+
+// writeArchive writes the PDF/A archival copy of a converted letter if requested
+func writeArchive(config *Config, p *processor.PDFProcessor, result *processor.Result) error {
+	if config.Archive == "" || result.DryRun {
+		return nil
+	}
+
+	archiveFile := utils.GenerateArchiveFilename(result.OutputFile)
+	if err := p.WriteArchive(result.OutputFile, archiveFile, config.Archive); err != nil {
+		return fmt.Errorf("archival copy failed: %w", err)
+	}
+	result.ArchiveFile = archiveFile
+	result.ArchiveLevel = config.Archive
+	return nil
+}
+
+// writeDebugOverlay writes the debug copy of a converted letter if requested
+func writeDebugOverlay(config *Config, p *processor.PDFProcessor, result *processor.Result) error {
+	if !config.DebugOverlay || result.DryRun {
+		return nil
+	}
+
+	overlayFile := utils.GenerateDebugOverlayFilename(result.OutputFile)
+	if err := p.WriteDebugOverlay(result.OutputFile, overlayFile, result.Pages, result.SourcePages()); err != nil {
+		return fmt.Errorf("debug overlay failed: %w", err)
+	}
+	result.DebugOverlayFile = overlayFile
 	return nil
 }
 
@@ -85,4 +511,4 @@ func setupLogging(config *Config) {
 		TimestampFormat: "15:04:05",
 		FullTimestamp:   true,
 	})
-}
\ No newline at end of file
+}
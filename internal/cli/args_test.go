@@ -1,7 +1,18 @@
 package cli
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/yourorg/pdf2letterexpress/internal/processor"
+	"github.com/yourorg/pdf2letterexpress/internal/report"
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
 )
 
 func TestNewRootCommand(t *testing.T) {
@@ -31,4 +42,256 @@ func TestSetupLogging(t *testing.T) {
 	}
 
 	setupLogging(config)
-}
\ No newline at end of file
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"no error", nil, ExitOK},
+		{"input not found", fmt.Errorf("validation: %w", utils.ErrInputNotFound), ExitInputNotFound},
+		{"invalid pdf", fmt.Errorf("validation: %w", processor.ErrInvalidPDF), ExitInvalidPDF},
+		{"unreadable image", fmt.Errorf("%w: not a JPEG, PNG or TIFF image", processor.ErrUnreadableInput), ExitUnreadableInput},
+		{"tool missing", fmt.Errorf("engine: %w", processor.ErrToolMissing), ExitToolMissing},
+		{"output not writable", fmt.Errorf("%w: %w", processor.ErrEngineFailed, processor.ErrOutputNotWritable), ExitOutputNotWritable},
+		{"password required", fmt.Errorf("read: %w", processor.ErrPasswordRequired), ExitEncrypted},
+		{"wrong password", fmt.Errorf("read: %w", processor.ErrWrongPassword), ExitEncrypted},
+		{"too large", fmt.Errorf("%w: 12.0 MB", processor.ErrTooLarge), ExitTooLarge},
+		{"audit log damaged", fmt.Errorf("%w: audit.jsonl: 1 errors", processor.ErrAuditDamaged), ExitAuditDamaged},
+		{"unknown", errors.New("boom"), ExitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPipe(t *testing.T) {
+	config := &Config{Output: "-"}
+	pipe, inputs, err := newPipe(config, []string{"-"}, strings.NewReader("\x89PNG\r\n\x1a\n"))
+	defer pipe.close()
+	if err != nil {
+		t.Fatalf("newPipe failed: %v", err)
+	}
+	if filepath.Ext(inputs[0]) != ".png" || !utils.FileExists(inputs[0]) {
+		t.Errorf("stdin stored as %q", inputs[0])
+	}
+	if !pipe.toStdout() || config.Output == "-" {
+		t.Errorf("Expected the output to be redirected, got %q", config.Output)
+	}
+
+	r := &report.Report{Input: report.File{Path: inputs[0]}, Output: &report.File{Path: config.Output}}
+	pipe.rename(r)
+	if r.Input.Path != "-" || r.Output.Path != "-" {
+		t.Errorf("report paths = %q, %q, want -", r.Input.Path, r.Output.Path)
+	}
+
+	if _, _, err := newPipe(&Config{}, []string{"-", "-"}, strings.NewReader("")); err == nil {
+		t.Error("Expected an error for stdin given twice")
+	}
+
+	// Without -o the letter of stdin goes to stdout instead of the workspace
+	config = &Config{}
+	pipe, _, err = newPipe(config, []string{"-"}, strings.NewReader("%PDF-1.4"))
+	defer pipe.close()
+	if err != nil || !pipe.toStdout() || config.Output != pipe.stdoutFile {
+		t.Errorf("stdin without -o: err %v, output %q", err, config.Output)
+	}
+	config = &Config{Merge: true}
+	pipe, _, err = newPipe(config, []string{"a.pdf", "-"}, strings.NewReader("%PDF-1.4"))
+	defer pipe.close()
+	if err != nil || pipe.toStdout() || config.Output != "" {
+		t.Errorf("stdin merged into a.pdf: err %v, output %q", err, config.Output)
+	}
+	pipe, _, err = newPipe(&Config{}, []string{"a.pdf", "-"}, strings.NewReader("%PDF-1.4"))
+	defer pipe.close()
+	if err == nil {
+		t.Error("Expected an error for stdin in a batch without --merge")
+	}
+	if _, _, err := newPipe(&Config{Output: "-", Archive: "pdfa-2b"}, []string{"a.pdf"}, nil); err == nil {
+		t.Error("Expected an error for --archive with output to stdout")
+	}
+}
+
+func TestRunBatch_AuditFailure(t *testing.T) {
+	tempDir := t.TempDir()
+	page := "%PDF-1.4\n1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n" +
+		"2 0 obj << /Type /Pages /Kids [3 0 R] /Count 1 >> endobj\n" +
+		"3 0 obj << /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] >> endobj\n" +
+		"trailer << /Size 4 /Root 1 0 R >>\n%%EOF\n"
+	var inputs []string
+	for _, name := range []string{"first.pdf", "second.pdf"} {
+		inputFile := filepath.Join(tempDir, name)
+		if err := os.WriteFile(inputFile, []byte(page), 0644); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, inputFile)
+	}
+
+	// A directory cannot be opened as audit log
+	reportFile := filepath.Join(tempDir, "report.jsonl")
+	config := &Config{Report: report.FormatJSON, ReportFile: reportFile, AuditLog: tempDir}
+	err := runBatch(config, inputs)
+	if err == nil || !strings.Contains(err.Error(), "2 of 2 files failed") {
+		t.Fatalf("runBatch returned %v, want both files failed", err)
+	}
+
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("report has %d lines, want one per input", len(lines))
+	}
+	for i, line := range lines {
+		var r report.Report
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Error == nil || !strings.Contains(r.Error.Message, "audit log") {
+			t.Errorf("report of input %d has error %+v, want the audit log failure", i+1, r.Error)
+		}
+		if !utils.FileExists(utils.GenerateOutputFilename(inputs[i])) {
+			t.Errorf("input %d was not converted", i+1)
+		}
+	}
+
+	// A combined letter is only reported as converted once it is logged
+	config = &Config{Merge: true, Report: report.FormatJSON, ReportFile: reportFile, AuditLog: tempDir}
+	if err := runBatch(config, inputs); err == nil || !strings.Contains(err.Error(), "audit log") {
+		t.Fatalf("runBatch returned %v, want the audit log failure", err)
+	}
+	var r report.Report
+	data, err = os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Success || r.Error == nil || !strings.Contains(r.Error.Message, "audit log") {
+		t.Errorf("combined report has success %v, error %+v, want the audit log failure", r.Success, r.Error)
+	}
+}
+
+func TestMergeCommand_ProcessorOptions(t *testing.T) {
+	tempDir := t.TempDir()
+	templateFile := filepath.Join(tempDir, "template.pdf")
+	dataFile := filepath.Join(tempDir, "data.csv")
+	page := "%PDF-1.4\n1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n" +
+		"2 0 obj << /Type /Pages /Kids [3 0 R] /Count 1 >> endobj\n" +
+		"3 0 obj << /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] >> endobj\n" +
+		"trailer << /Size 4 /Root 1 0 R >>\n%%EOF\n"
+	if err := os.WriteFile(templateFile, []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dataFile, []byte("name\nAnna\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The conversion flags reach the processor
+	cmd := NewRootCommand("TestApp", "1.0.0", "Test")
+	cmd.SetArgs([]string{"merge", templateFile, dataFile, "--fix-fonts", "bogus"})
+	cmd.SetErr(io.Discard)
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "unknown --fix-fonts mode") {
+		t.Errorf("Execute returned %v, want the invalid --fix-fonts mode", err)
+	}
+	if utils.FileExists(filepath.Join(tempDir, "template - 001.pdf")) {
+		t.Error("Expected no letter to be written")
+	}
+}
+
+func TestSubcommands_AuditLog(t *testing.T) {
+	tempDir := t.TempDir()
+	auditLog := filepath.Join(tempDir, "audit.jsonl")
+	inputFile := filepath.Join(tempDir, "input.pdf")
+	pages := "%PDF-1.4\n1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n" +
+		"2 0 obj << /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >> endobj\n" +
+		"3 0 obj << /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] >> endobj\n" +
+		"4 0 obj << /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] >> endobj\n" +
+		"trailer << /Size 5 /Root 1 0 R >>\n%%EOF\n"
+	if err := os.WriteFile(inputFile, []byte(pages), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dataFile := filepath.Join(tempDir, "data.csv")
+	if err := os.WriteFile(dataFile, []byte("name\nAnna\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bodyFile := filepath.Join(tempDir, "letter.md")
+	if err := os.WriteFile(bodyFile, []byte("Dear Anna,\n\nthank you.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := []struct {
+		args    []string
+		outputs []string
+	}{
+		{
+			[]string{"split", inputFile, "--pages", "1", "-o", filepath.Join(tempDir, "split")},
+			[]string{filepath.Join(tempDir, "split", "input - 001.pdf"), filepath.Join(tempDir, "split", "input - 002.pdf")},
+		},
+		{
+			[]string{"merge", inputFile, dataFile, "-o", filepath.Join(tempDir, "merge")},
+			[]string{filepath.Join(tempDir, "merge", "input - 001.pdf")},
+		},
+		{
+			[]string{"merge", inputFile, dataFile, "--combined", filepath.Join(tempDir, "combined.pdf")},
+			[]string{filepath.Join(tempDir, "combined.pdf")},
+		},
+		{
+			[]string{"compose", bodyFile, "--recipient", "Anna Muster", "--recipient", "Hauptstr. 1", "--recipient", "12345 Berlin"},
+			[]string{filepath.Join(tempDir, "letter.pdf")},
+		},
+		{
+			[]string{"sanitize", inputFile},
+			[]string{utils.GenerateSanitizedFilename(inputFile)},
+		},
+	}
+
+	var want []string
+	for _, command := range commands {
+		cmd := NewRootCommand("TestApp", "1.0.0", "Test")
+		cmd.SetArgs(append(command.args, "--audit-log", auditLog))
+		cmd.SetErr(io.Discard)
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("%s returned %v", command.args[0], err)
+		}
+		want = append(want, command.outputs...)
+	}
+
+	verification, err := report.VerifyAudit(auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verification.Intact {
+		t.Fatalf("audit log is damaged: %v", verification.Errors)
+	}
+
+	data, err := os.ReadFile(auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("audit log has %d entries, want one per letter (%d)", len(lines), len(want))
+	}
+	for i, line := range lines {
+		var entry report.AuditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Outcome != "ok" || entry.Output == nil || entry.Output.Path != want[i] {
+			t.Errorf("entry %d has outcome %q and output %+v, want %s", i+1, entry.Outcome, entry.Output, want[i])
+		}
+		if entry.Options["audit-log"] != auditLog {
+			t.Errorf("entry %d has options %v, want the command line flags", i+1, entry.Options)
+		}
+	}
+}
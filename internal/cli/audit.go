@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yourorg/pdf2letterexpress/internal/processor"
+	"github.com/yourorg/pdf2letterexpress/internal/report"
+)
+
+func newVerifyAuditCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify-audit <audit-log>",
+		Short: "Check the hash chain of an audit log",
+		Long: "Checks that every entry of an audit log written with --audit-log matches its hash and " +
+			"carries the hash of the entry before it, so that changed, removed, inserted or reordered " +
+			"entries are found. Prints the hash of the last entry, which can be kept elsewhere to " +
+			"detect entries removed from the end.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVerifyAudit(config, args[0])
+		},
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().StringVar(&config.Report, "report", report.FormatText, "Output format (text, json)")
+
+	return cmd
+}
+
+func runVerifyAudit(config *Config, auditLog string) error {
+	setupLogging(config)
+
+	if config.Report != report.FormatText && config.Report != report.FormatJSON {
+		return fmt.Errorf("unsupported report format: %s", config.Report)
+	}
+
+	result, err := report.VerifyAudit(auditLog)
+	if err != nil {
+		return err
+	}
+
+	if config.Report == report.FormatJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+			return fmt.Errorf("cannot write report: %w", err)
+		}
+	} else {
+		if result.Intact {
+			fmt.Fprintf(os.Stderr, "✅ %s: %d entries, chain intact\n", result.File, result.Entries)
+		} else {
+			fmt.Fprintf(os.Stderr, "❌ %s: chain broken (%d entries)\n", result.File, result.Entries)
+		}
+		for _, e := range result.Errors {
+			fmt.Fprintf(os.Stderr, "   ❗ %s\n", e)
+		}
+		if result.Last != "" {
+			fmt.Fprintf(os.Stderr, "   🔗 Last hash: %s\n", result.Last)
+		}
+	}
+
+	if !result.Intact {
+		return fmt.Errorf("%w: %s: %d errors", processor.ErrAuditDamaged, auditLog, len(result.Errors))
+	}
+	return nil
+}
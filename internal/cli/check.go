@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yourorg/pdf2letterexpress/internal/processor"
+	"github.com/yourorg/pdf2letterexpress/internal/report"
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
+)
+
+func newCheckCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check <PDF-file>...",
+		Short: "Check whether PDFs can be sent as they are and estimate postage",
+		Long: "Inspects PDF files, usually converted letters, without changing them. Reports pages " +
+			"that are not A4 and estimates sheets, weight and postage class of each letter.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCheck(config, args)
+		},
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().StringVar(&config.Report, "report", report.FormatText, "Output format (text, json)")
+	cmd.Flags().BoolVar(&config.Duplex, "duplex", false, "Estimate postage for duplex printing")
+	addPostageFlags(cmd, config)
+
+	return cmd
+}
+
+func runCheck(config *Config, inputFiles []string) error {
+	setupLogging(config)
+
+	if config.Report != report.FormatText && config.Report != report.FormatJSON {
+		return fmt.Errorf("unsupported report format: %s", config.Report)
+	}
+
+	opts, err := config.processorOptions()
+	if err != nil {
+		return err
+	}
+	p := processor.NewPDFProcessorWithOptions(opts)
+
+	var firstErr error
+	for _, inputFile := range inputFiles {
+		result, err := checkFile(p, inputFile)
+		if err == nil && !result.Compliant {
+			err = fmt.Errorf("%w: %s: %d findings", processor.ErrNonCompliant, inputFile, len(result.Findings))
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if result == nil {
+			continue
+		}
+
+		if config.Report == report.FormatJSON {
+			if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+				return fmt.Errorf("cannot write report: %w", err)
+			}
+			continue
+		}
+		printCheckResult(result)
+	}
+
+	return firstErr
+}
+
+func checkFile(p *processor.PDFProcessor, inputFile string) (*processor.CheckResult, error) {
+	if err := utils.ValidatePDFFile(inputFile); err != nil {
+		return nil, fmt.Errorf("input validation failed: %w", err)
+	}
+	return p.Check(inputFile)
+}
+
+func printCheckResult(result *processor.CheckResult) {
+	if result.Compliant {
+		fmt.Fprintf(os.Stderr, "✅ %s: ready to send (%d pages)\n", result.File, result.PageCount)
+	} else {
+		fmt.Fprintf(os.Stderr, "❌ %s: not compliant (%d pages)\n", result.File, result.PageCount)
+	}
+	for _, finding := range result.Findings {
+		fmt.Fprintf(os.Stderr, "   ❗ %s\n", finding)
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "   ⚠️  %s\n", warning)
+	}
+	fmt.Fprintf(os.Stderr, "   🎨 %s\n", report.FormatColorPages(result.ColorPages))
+	if result.Postage != nil {
+		fmt.Fprintf(os.Stderr, "   ✉️  %s\n", report.FormatPostage(result.Postage))
+	}
+}
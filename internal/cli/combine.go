@@ -0,0 +1,27 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func newCombineCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "combine <PDF-file>...",
+		Short: "Combine a cover letter and attachments into one letter",
+		Long: "Concatenates the PDF files in the given order, normalises every page to A4 with margins " +
+			"and writes a single letter. Same as passing the files with --merge.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.Merge = true
+			config.options = changedFlags(cmd)
+			return runBatch(config, args)
+		},
+		SilenceUsage: true,
+	}
+
+	addConversionFlags(cmd, config)
+	cmd.Flags().BoolVar(&config.NewSheet, "new-sheet", false, "Start every file on a new sheet in duplex by inserting blank pages")
+	cmd.Flags().StringVarP(&config.Output, "output", "o", "", "Output file, - for stdout (default: first input with \" - converted\")")
+
+	return cmd
+}
@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/yourorg/pdf2letterexpress/internal/processor"
+	"github.com/yourorg/pdf2letterexpress/internal/report"
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
+)
+
+type composeConfig struct {
+	Sender     []string
+	Recipient  []string
+	Subject    string
+	Date       string
+	OutputFile string
+}
+
+func newComposeCommand(config *Config) *cobra.Command {
+	composeCfg := &composeConfig{}
+
+	cmd := &cobra.Command{
+		Use:   "compose <letter.md|letter.txt>",
+		Short: "Generate a DIN 5008 letter from Markdown or plain text",
+		Long: "Lays out the body of a Markdown or text file as a DIN 5008 Form B letter with the " +
+			"recipient in the envelope window, embedded fonts and page breaks, and checks the result. " +
+			"Sender, recipient, subject and date are taken from the flags or from YAML front matter.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.options = changedFlags(cmd)
+			return runCompose(config, composeCfg, args[0])
+		},
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().StringArrayVar(&composeCfg.Sender, "sender", nil, "Sender address line (repeatable)")
+	cmd.Flags().StringArrayVar(&composeCfg.Recipient, "recipient", nil, "Recipient address line (repeatable)")
+	cmd.Flags().StringVar(&composeCfg.Subject, "subject", "", "Subject line")
+	cmd.Flags().StringVar(&composeCfg.Date, "date", "", "Date printed in the letter (default: today)")
+	cmd.Flags().StringVarP(&composeCfg.OutputFile, "output", "o", "", "Output PDF (default: \"<name>.pdf\" next to the input)")
+	cmd.Flags().StringVar(&config.Report, "report", report.FormatText, "Output format (text, json)")
+	addAuditFlag(cmd, config)
+
+	return cmd
+}
+
+func runCompose(config *Config, composeCfg *composeConfig, bodyFile string) error {
+	setupLogging(config)
+
+	if config.Report != report.FormatText && config.Report != report.FormatJSON {
+		return fmt.Errorf("unsupported report format: %s", config.Report)
+	}
+
+	// Flags take precedence over the front matter
+	letter := processor.Letter{
+		Sender:    composeCfg.Sender,
+		Recipient: composeCfg.Recipient,
+		Subject:   composeCfg.Subject,
+		Date:      composeCfg.Date,
+	}
+	if err := processor.LoadLetter(bodyFile, &letter); err != nil {
+		return err
+	}
+
+	outputFile := composeCfg.OutputFile
+	if outputFile == "" {
+		outputFile = utils.GenerateComposedFilename(bodyFile)
+	}
+	if err := utils.ValidateOutputPath(outputFile); err != nil {
+		return err
+	}
+
+	opts, err := config.processorOptions()
+	if err != nil {
+		return err
+	}
+	startedAt := time.Now()
+	result, err := processor.NewPDFProcessorWithOptions(opts).Compose(letter, outputFile)
+	if err != nil {
+		if auditErr := config.auditLetters(bodyFile, nil, err); auditErr != nil {
+			logrus.WithError(auditErr).WithField("input", bodyFile).Error("Audit log entry failed")
+		}
+		return fmt.Errorf("composing failed: %w", err)
+	}
+
+	if config.Report == report.FormatJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+			return fmt.Errorf("cannot write report: %w", err)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "✅ Composed %s (%d pages)\n", result.OutputFile, result.PageCount)
+		for _, warning := range result.Warnings {
+			fmt.Fprintf(os.Stderr, "⚠️  %s\n", warning)
+		}
+		printCheckResult(result.Check)
+	}
+
+	if !result.Check.Compliant {
+		err = fmt.Errorf("%w: %s: %d findings", processor.ErrNonCompliant, outputFile, len(result.Check.Findings))
+	}
+
+	composed := &processor.Result{
+		InputFile:  bodyFile,
+		OutputFile: result.OutputFile,
+		PageCount:  result.PageCount,
+		Warnings:   result.Warnings,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+	}
+	if auditErr := config.audit(report.New(config.appName, config.appVersion, composed, err)); auditErr != nil {
+		if err != nil {
+			logrus.WithError(auditErr).WithField("input", bodyFile).Error("Audit log entry failed")
+			return err
+		}
+		return auditErr
+	}
+	return err
+}
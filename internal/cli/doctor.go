@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yourorg/pdf2letterexpress/internal/processor"
+	"github.com/yourorg/pdf2letterexpress/internal/report"
+)
+
+func newDoctorCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Show which external tools are installed",
+		Long: "Looks up the external programs the conversion can use, ImageMagick, Ghostscript, " +
+			"pdftotext and LibreOffice, and prints their path and version and what is not " +
+			"available without them.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDoctor(config)
+		},
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().StringVar(&config.Report, "report", report.FormatText, "Output format (text, json)")
+
+	return cmd
+}
+
+func runDoctor(config *Config) error {
+	setupLogging(config)
+
+	if config.Report != report.FormatText && config.Report != report.FormatJSON {
+		return fmt.Errorf("unsupported report format: %s", config.Report)
+	}
+
+	tools := processor.Tools()
+	if config.Report == report.FormatJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(tools); err != nil {
+			return fmt.Errorf("cannot write report: %w", err)
+		}
+		return nil
+	}
+
+	for _, tool := range tools {
+		if !tool.Found() {
+			fmt.Fprintf(os.Stderr, "❌ %s (%s) not found, needed for %s\n", tool.Name, tool.Command, tool.Purpose)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "✅ %s: %s\n", tool.Name, tool.Path)
+		if tool.Version != "" {
+			fmt.Fprintf(os.Stderr, "   %s\n", tool.Version)
+		}
+	}
+	return nil
+}
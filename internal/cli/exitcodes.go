@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"github.com/yourorg/pdf2letterexpress/internal/processor"
+)
+
+// Exit codes of the CLI. They are part of the documented interface and
+// must not be renumbered.
+const (
+	ExitOK                = 0
+	ExitFailure           = 1
+	ExitInputNotFound     = 3
+	ExitInvalidPDF        = 4
+	ExitEncrypted         = 5
+	ExitToolMissing       = 6
+	ExitEngineFailed      = 7
+	ExitOutputNotWritable = 8
+	ExitNonCompliant      = 9
+	ExitTooLarge          = 10
+	ExitAuditDamaged      = 11
+	ExitUnreadableInput   = 12
+)
+
+var exitCodes = map[string]int{
+	processor.CodeOK:                ExitOK,
+	processor.CodeInputNotFound:     ExitInputNotFound,
+	processor.CodeInvalidPDF:        ExitInvalidPDF,
+	processor.CodeEncrypted:         ExitEncrypted,
+	processor.CodeToolMissing:       ExitToolMissing,
+	processor.CodeEngineFailed:      ExitEngineFailed,
+	processor.CodeOutputNotWritable: ExitOutputNotWritable,
+	processor.CodeNonCompliant:      ExitNonCompliant,
+	processor.CodeTooLarge:          ExitTooLarge,
+	processor.CodeAuditDamaged:      ExitAuditDamaged,
+	processor.CodeUnreadableInput:   ExitUnreadableInput,
+}
+
+// ExitCode maps an error returned by the root command to the process exit code
+func ExitCode(err error) int {
+	if code, ok := exitCodes[processor.ErrorCode(err)]; ok {
+		return code
+	}
+	return ExitFailure
+}
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yourorg/pdf2letterexpress/internal/processor"
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
+)
+
+type mergeConfig struct {
+	LayoutFile   string
+	OutputDir    string
+	NameTemplate string
+	CombinedFile string
+}
+
+func newMergeCommand(config *Config) *cobra.Command {
+	mergeCfg := &mergeConfig{}
+
+	cmd := &cobra.Command{
+		Use:   "merge <template-PDF> <data.csv|data.json>",
+		Short: "Generate individual letters from a template PDF and a data file",
+		Long: "Stamps the address block and placeholders of the layout onto page 1 of the template " +
+			"for every row of the data file and converts each result into a LetterXpress compatible letter. " +
+			"Positions in the layout are given in mm from the top left corner of the final A4 page.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.options = changedFlags(cmd)
+			return runMerge(config, mergeCfg, args[0], args[1])
+		},
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().StringVar(&mergeCfg.LayoutFile, "layout", "", "JSON field layout (default: address block in the DIN 5008 window)")
+	cmd.Flags().StringVarP(&mergeCfg.OutputDir, "output-dir", "o", "", "Directory for the generated letters (default: next to the template)")
+	cmd.Flags().StringVar(&mergeCfg.NameTemplate, "name", "", "File name template with {{placeholders}} (default: \"<template> - {{row}}\")")
+	cmd.Flags().StringVar(&mergeCfg.CombinedFile, "combined", "", "Write all letters into this single PDF instead")
+	addAuditFlag(cmd, config)
+	addProcessingFlags(cmd, config)
+
+	return cmd
+}
+
+func runMerge(config *Config, mergeCfg *mergeConfig, templateFile, dataFile string) error {
+	setupLogging(config)
+
+	if err := utils.ValidatePDFFile(templateFile); err != nil {
+		return fmt.Errorf("input validation failed: %w", err)
+	}
+
+	layout, err := processor.LoadMergeLayout(mergeCfg.LayoutFile)
+	if err != nil {
+		return err
+	}
+
+	rows, err := processor.LoadMergeData(dataFile)
+	if err != nil {
+		return err
+	}
+
+	outputDir := mergeCfg.OutputDir
+	if outputDir == "" {
+		outputDir = filepath.Dir(templateFile)
+	}
+	if err := utils.EnsureDirectoryExists(outputDir); err != nil {
+		return fmt.Errorf("%w: %w", utils.ErrOutputNotWritable, err)
+	}
+
+	opts := processor.MergeOptions{
+		Layout:       layout,
+		OutputDir:    outputDir,
+		NameTemplate: mergeCfg.NameTemplate,
+		CombinedFile: mergeCfg.CombinedFile,
+	}
+
+	procOpts, err := config.processorOptions()
+	if err != nil {
+		return err
+	}
+
+	result, err := processor.NewPDFProcessorWithOptions(procOpts).MailMerge(templateFile, rows, opts)
+
+	// Letters written before a failure are audited as well, unless they
+	// were meant for a combined file that was never written
+	var letters []*processor.Result
+	if result != nil && (err == nil || result.CombinedFile == "") {
+		for _, letter := range result.Letters {
+			letters = append(letters, letter.Result)
+		}
+	}
+	auditErr := config.auditLetters(templateFile, letters, err)
+	if err != nil {
+		return fmt.Errorf("mail merge failed: %w", err)
+	}
+	if auditErr != nil {
+		return auditErr
+	}
+
+	fmt.Fprintf(os.Stderr, "✅ Created %d letters\n", len(result.Letters))
+	if result.CombinedFile != "" {
+		fmt.Fprintf(os.Stderr, "📁 Output:   %s\n", result.CombinedFile)
+	} else {
+		fmt.Fprintf(os.Stderr, "📁 Output:   %s\n", outputDir)
+	}
+	fmt.Fprintf(os.Stderr, "📋 Manifest: %s\n", result.ManifestFile)
+	for _, letter := range result.Letters {
+		for _, warning := range letter.Warnings {
+			fmt.Fprintf(os.Stderr, "⚠️  Row %d: %s\n", letter.Row, warning)
+		}
+	}
+
+	return nil
+}
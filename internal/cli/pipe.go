@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/yourorg/pdf2letterexpress/internal/processor"
+	"github.com/yourorg/pdf2letterexpress/internal/report"
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
+)
+
+// stdio is the file name that stands for stdin as input and stdout as output
+const stdio = "-"
+
+// pipe connects a conversion to stdin and stdout through files in a
+// temporary workspace
+type pipe struct {
+	dir string
+	// stdinFile holds the data read from stdin
+	stdinFile string
+	// stdoutFile is converted into instead of stdout
+	stdoutFile string
+}
+
+// newPipe reads stdin into the workspace if an input is "-" and redirects
+// the output into it if it is "-". Without -o a letter converted from stdin
+// goes to stdout, as a name derived from stdin would point into the
+// workspace. It returns the inputs with stdin replaced by its file.
+func newPipe(config *Config, inputFiles []string, stdin io.Reader) (*pipe, []string, error) {
+	p := &pipe{}
+	inputs := make([]string, len(inputFiles))
+	copy(inputs, inputFiles)
+
+	stdinIndex := -1
+	for i, inputFile := range inputs {
+		if inputFile != stdio {
+			continue
+		}
+		if stdinIndex >= 0 {
+			return p, inputs, fmt.Errorf("stdin can only be given once as input")
+		}
+		stdinIndex = i
+	}
+	if stdinIndex >= 0 && config.Output == "" {
+		switch {
+		case len(inputs) == 1 || config.Merge && stdinIndex == 0:
+			config.Output = stdio
+		case !config.Merge:
+			return p, inputs, fmt.Errorf("stdin can only be converted with other files using --merge")
+		}
+	}
+	toStdout := config.Output == stdio
+	if stdinIndex < 0 && !toStdout {
+		return p, inputs, nil
+	}
+	if toStdout && (config.Archive != "" || config.DebugOverlay) {
+		return p, inputs, fmt.Errorf("--archive and --debug-overlay need an output file, not stdout")
+	}
+
+	dir, err := os.MkdirTemp("", "pdf2letterexpress-pipe-")
+	if err != nil {
+		return p, inputs, fmt.Errorf("cannot create temporary directory: %w", err)
+	}
+	p.dir = dir
+
+	if stdinIndex >= 0 {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return p, inputs, fmt.Errorf("cannot read stdin: %w", err)
+		}
+		p.stdinFile = filepath.Join(dir, "stdin"+utils.DetectInputExtension(data))
+		if err := os.WriteFile(p.stdinFile, data, 0600); err != nil {
+			return p, inputs, fmt.Errorf("cannot store stdin: %w", err)
+		}
+		inputs[stdinIndex] = p.stdinFile
+		logrus.WithField("bytes", len(data)).Debug("Read input from stdin")
+	}
+
+	if toStdout {
+		p.stdoutFile = filepath.Join(dir, "stdout.pdf")
+		config.Output = p.stdoutFile
+	}
+	return p, inputs, nil
+}
+
+// toStdout reports whether the output goes to stdout
+func (p *pipe) toStdout() bool {
+	return p.stdoutFile != ""
+}
+
+// flush copies a converted letter to stdout
+func (p *pipe) flush(result *processor.Result, stdout io.Writer) error {
+	if !p.toStdout() || result.DryRun {
+		return nil
+	}
+	f, err := os.Open(p.stdoutFile)
+	if err != nil {
+		return fmt.Errorf("%w: cannot read output: %w", utils.ErrOutputNotWritable, err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(stdout, f); err != nil {
+		return fmt.Errorf("%w: cannot write to stdout: %w", utils.ErrOutputNotWritable, err)
+	}
+	return nil
+}
+
+// rename reports the workspace files as "-"
+func (p *pipe) rename(r *report.Report) {
+	rename := func(path *string) {
+		if *path != "" && (*path == p.stdinFile || *path == p.stdoutFile) {
+			*path = stdio
+		}
+	}
+	rename(&r.Input.Path)
+	if r.Output != nil {
+		rename(&r.Output.Path)
+	}
+	for i := range r.Sections {
+		rename(&r.Sections[i].Path)
+	}
+}
+
+// close removes the workspace
+func (p *pipe) close() {
+	if p.dir != "" {
+		os.RemoveAll(p.dir)
+	}
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/yourorg/pdf2letterexpress/internal/processor"
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
+)
+
+type previewConfig struct {
+	ConvertedFile string
+	OutputDir     string
+	DPI           int
+	ContactSheet  bool
+	HTML          bool
+	Duplex        bool
+	SectionStart  []int
+	RemoveBlank   bool
+}
+
+func newPreviewCommand(config *Config) *cobra.Command {
+	previewCfg := &previewConfig{}
+
+	cmd := &cobra.Command{
+		Use:   "preview <PDF-file>",
+		Short: "Render the original and converted pages side by side",
+		Long: "Renders low-resolution PNGs of the original and converted pages side by side, " +
+			"with the 5mm safe area and the DIN 5008 address window outlined. " +
+			"Without --converted the file is converted into a temporary file first. " +
+			"--duplex, --section-start and --remove-blank convert it as the main command does, " +
+			"or describe how the --converted file was made, so each converted page is shown next to its original.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPreview(config, previewCfg, args[0])
+		},
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().StringVar(&previewCfg.ConvertedFile, "converted", "", "Already converted PDF to compare against")
+	cmd.Flags().StringVarP(&previewCfg.OutputDir, "output-dir", "o", "", "Directory for the preview images (default: \"<name> - preview\" next to the input)")
+	cmd.Flags().IntVar(&previewCfg.DPI, "dpi", 50, "Resolution of the rendered pages")
+	cmd.Flags().BoolVar(&previewCfg.ContactSheet, "contact-sheet", false, "Also combine all pages into a single contact sheet image")
+	cmd.Flags().BoolVar(&previewCfg.HTML, "html", false, "Also write an index.html showing all pages")
+	cmd.Flags().BoolVar(&previewCfg.Duplex, "duplex", false, "The letter was padded for duplex printing")
+	cmd.Flags().IntSliceVar(&previewCfg.SectionStart, "section-start", nil, "With --duplex, pages that start on a front side (e.g. 3,5)")
+	cmd.Flags().BoolVar(&previewCfg.RemoveBlank, "remove-blank", false, "Blank pages were removed from the letter")
+
+	return cmd
+}
+
+func runPreview(config *Config, previewCfg *previewConfig, inputFile string) error {
+	setupLogging(config)
+
+	if err := utils.ValidateInputFile(inputFile); err != nil {
+		return fmt.Errorf("input validation failed: %w", err)
+	}
+
+	outputDir := previewCfg.OutputDir
+	if outputDir == "" {
+		base := filepath.Base(inputFile)
+		outputDir = filepath.Join(filepath.Dir(inputFile), strings.TrimSuffix(base, filepath.Ext(base))+" - preview")
+	}
+
+	pdfProcessor := processor.NewPDFProcessorWithOptions(processor.Options{
+		Duplex:           previewCfg.Duplex,
+		SectionStarts:    previewCfg.SectionStart,
+		RemoveBlankPages: previewCfg.RemoveBlank,
+	})
+
+	// The converted pages are paired with the original pages they were made
+	// from, which differ once blank pages were removed or inserted
+	var converted *processor.Result
+	convertedFile := previewCfg.ConvertedFile
+	if convertedFile == "" {
+		tempDir, err := os.MkdirTemp("", "pdf2letterexpress-preview-")
+		if err != nil {
+			return fmt.Errorf("cannot create temporary directory: %w", err)
+		}
+		defer os.RemoveAll(tempDir)
+
+		convertedFile = filepath.Join(tempDir, "converted.pdf")
+		if converted, err = pdfProcessor.Process(inputFile, convertedFile); err != nil {
+			return fmt.Errorf("PDF processing failed: %w", err)
+		}
+	} else if previewCfg.Duplex || previewCfg.RemoveBlank {
+		var err error
+		if converted, err = pdfProcessor.Plan(inputFile, convertedFile); err != nil {
+			return fmt.Errorf("cannot plan the page order: %w", err)
+		}
+	}
+
+	opts := processor.DefaultPreviewOptions(outputDir)
+	opts.DPI = previewCfg.DPI
+	opts.ContactSheet = previewCfg.ContactSheet
+	opts.HTML = previewCfg.HTML
+	if converted != nil && converted.PageCount > 0 {
+		opts.SourcePages = converted.SourcePages()
+	}
+
+	result, err := pdfProcessor.Preview(inputFile, convertedFile, opts)
+	if err != nil {
+		return fmt.Errorf("preview failed: %w", err)
+	}
+
+	logrus.WithField("pages", len(result.Pages)).Debug("Preview pages written")
+
+	fmt.Fprintf(os.Stderr, "✅ Preview rendered\n")
+	fmt.Fprintf(os.Stderr, "📁 Pages:  %s\n", outputDir)
+	if result.ContactSheet != "" {
+		fmt.Fprintf(os.Stderr, "🖼️  Sheet:  %s\n", result.ContactSheet)
+	}
+	if result.HTML != "" {
+		fmt.Fprintf(os.Stderr, "🌐 HTML:   %s\n", result.HTML)
+	}
+
+	return nil
+}
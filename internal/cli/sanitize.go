@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/yourorg/pdf2letterexpress/internal/processor"
+	"github.com/yourorg/pdf2letterexpress/internal/report"
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
+)
+
+func newSanitizeCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sanitize <PDF-file>...",
+		Short: "Remove active and hidden content from PDFs",
+		Long: "Writes a copy of each PDF without JavaScript, open and other actions, embedded files, " +
+			"link annotations, document information, XMP metadata and unused objects, named " +
+			"\"<name> - sanitized.pdf\". Pages are not converted.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.options = changedFlags(cmd)
+			return runSanitize(config, args)
+		},
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().StringVar(&config.Report, "report", report.FormatText, "Output format (text, json)")
+	addAuditFlag(cmd, config)
+
+	return cmd
+}
+
+func runSanitize(config *Config, inputFiles []string) error {
+	setupLogging(config)
+
+	if config.Report != report.FormatText && config.Report != report.FormatJSON {
+		return fmt.Errorf("unsupported report format: %s", config.Report)
+	}
+
+	password, err := config.password()
+	if err != nil {
+		return err
+	}
+	p := processor.NewPDFProcessorWithOptions(processor.Options{Password: password})
+
+	var firstErr error
+	for _, inputFile := range inputFiles {
+		startedAt := time.Now()
+		result, err := sanitizeFile(p, inputFile)
+		if auditErr := config.auditSanitized(inputFile, result, startedAt, err); auditErr != nil {
+			if err != nil {
+				logrus.WithError(auditErr).WithField("input", inputFile).Error("Audit log entry failed")
+			} else {
+				err = auditErr
+			}
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if config.Report == report.FormatJSON {
+			if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+				return fmt.Errorf("cannot write report: %w", err)
+			}
+			continue
+		}
+		printSanitizeResult(result)
+	}
+
+	return firstErr
+}
+
+// auditSanitized appends the entry of a sanitized copy, or of the error that
+// prevented it, to the audit log
+func (config *Config) auditSanitized(inputFile string, result *processor.SanitizeResult, startedAt time.Time, err error) error {
+	if err != nil {
+		return config.auditLetters(inputFile, nil, err)
+	}
+	sanitized := &processor.Result{
+		InputFile:  inputFile,
+		OutputFile: result.OutputFile,
+		Sanitized:  result.Removed,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+	}
+	return config.auditLetters(inputFile, []*processor.Result{sanitized}, nil)
+}
+
+func sanitizeFile(p *processor.PDFProcessor, inputFile string) (*processor.SanitizeResult, error) {
+	if err := utils.ValidateInputFile(inputFile); err != nil {
+		return nil, fmt.Errorf("input validation failed: %w", err)
+	}
+	outputFile := utils.GenerateSanitizedFilename(inputFile)
+	if err := utils.ValidateOutputPath(outputFile); err != nil {
+		return nil, err
+	}
+	result, err := p.Sanitize(inputFile, outputFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: sanitizing failed: %w", inputFile, err)
+	}
+	return result, nil
+}
+
+func printSanitizeResult(result *processor.SanitizeResult) {
+	fmt.Fprintf(os.Stderr, "✅ %s: sanitized to %s\n", result.File, result.OutputFile)
+	if len(result.Removed) == 0 {
+		fmt.Fprintf(os.Stderr, "   🧹 Nothing to remove\n")
+	}
+	for _, removed := range result.Removed {
+		fmt.Fprintf(os.Stderr, "   🧹 Removed %s\n", removed)
+	}
+}
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yourorg/pdf2letterexpress/internal/processor"
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
+)
+
+type splitConfig struct {
+	Pages        int
+	Bookmarks    bool
+	Pattern      string
+	Blank        bool
+	OutputDir    string
+	NameTemplate string
+}
+
+func newSplitCommand(config *Config) *cobra.Command {
+	splitCfg := &splitConfig{}
+
+	cmd := &cobra.Command{
+		Use:   "split <PDF-file>",
+		Short: "Split a combined PDF into individual letters",
+		Long: "Cuts a PDF that contains several letters into separate files and converts each of them. " +
+			"Exactly one of --pages, --bookmarks, --pattern or --blank selects where a new letter starts.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config.options = changedFlags(cmd)
+			return runSplit(config, splitCfg, args[0])
+		},
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().IntVar(&splitCfg.Pages, "pages", 0, "Start a new letter every N pages")
+	cmd.Flags().BoolVar(&splitCfg.Bookmarks, "bookmarks", false, "Start a new letter at every top level bookmark")
+	cmd.Flags().StringVar(&splitCfg.Pattern, "pattern", "", "Start a new letter at every page whose text matches this regular expression")
+	cmd.Flags().BoolVar(&splitCfg.Blank, "blank", false, "Use blank pages as separators between letters")
+	cmd.Flags().StringVarP(&splitCfg.OutputDir, "output-dir", "o", "", "Directory for the letters (default: next to the input)")
+	cmd.Flags().StringVar(&splitCfg.NameTemplate, "name", "", "File name template with {{placeholders}} (default: \"{{name}} - {{part}}\")")
+	addAuditFlag(cmd, config)
+	cmd.MarkFlagsMutuallyExclusive("pages", "bookmarks", "pattern", "blank")
+	cmd.MarkFlagsOneRequired("pages", "bookmarks", "pattern", "blank")
+
+	return cmd
+}
+
+func runSplit(config *Config, splitCfg *splitConfig, inputFile string) error {
+	setupLogging(config)
+
+	if err := utils.ValidateInputFile(inputFile); err != nil {
+		return fmt.Errorf("input validation failed: %w", err)
+	}
+
+	opts := processor.SplitOptions{
+		Pages:        splitCfg.Pages,
+		OutputDir:    splitCfg.OutputDir,
+		NameTemplate: splitCfg.NameTemplate,
+	}
+
+	switch {
+	case splitCfg.Pages != 0:
+		opts.Mode = processor.SplitByPages
+	case splitCfg.Bookmarks:
+		opts.Mode = processor.SplitByBookmarks
+	case splitCfg.Pattern != "":
+		pattern, err := regexp.Compile(splitCfg.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		opts.Mode = processor.SplitByText
+		opts.Pattern = pattern
+	default:
+		opts.Mode = processor.SplitByBlank
+	}
+
+	if opts.OutputDir == "" {
+		opts.OutputDir = filepath.Dir(inputFile)
+	}
+	if err := utils.EnsureDirectoryExists(opts.OutputDir); err != nil {
+		return fmt.Errorf("%w: %w", utils.ErrOutputNotWritable, err)
+	}
+
+	password, err := config.password()
+	if err != nil {
+		return err
+	}
+
+	result, err := processor.NewPDFProcessorWithOptions(processor.Options{Password: password}).Split(inputFile, opts)
+
+	// Letters written before a failure are audited as well
+	var letters []*processor.Result
+	if result != nil {
+		for _, part := range result.Parts {
+			letters = append(letters, part.Result)
+		}
+	}
+	auditErr := config.auditLetters(inputFile, letters, err)
+	if err != nil {
+		return fmt.Errorf("split failed: %w", err)
+	}
+	if auditErr != nil {
+		return auditErr
+	}
+
+	fmt.Fprintf(os.Stderr, "✅ Split into %d letters\n", len(result.Parts))
+	for _, part := range result.Parts {
+		fmt.Fprintf(os.Stderr, "📁 Pages %d-%d: %s\n", part.FirstPage, part.LastPage, part.File)
+	}
+
+	return nil
+}
@@ -0,0 +1,327 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Annotation flags of ISO 32000-1, table 165
+const (
+	annotFlagHidden = 1 << 1
+	annotFlagPrint  = 1 << 2
+)
+
+// flattenInput merges the printable annotations and form fields of
+// inputFile into its page content and drops the others. If there is
+// anything to flatten it writes a flattened copy to dir and returns it with
+// a function that removes it.
+func (p *PDFProcessor) flattenInput(inputFile, dir string, result *Result) (string, func(), error) {
+	noop := func() {}
+
+	ctx, err := p.readContextFile(inputFile)
+	if err != nil {
+		return inputFile, noop, err
+	}
+	changed, err := p.flattenAnnotations(ctx, result)
+	if err != nil || !changed {
+		return inputFile, noop, err
+	}
+
+	f, err := os.CreateTemp(dir, ".pdf2letterexpress-flattened-*.pdf")
+	if err != nil {
+		return inputFile, noop, fmt.Errorf("%w: cannot create flattened copy: %w", ErrOutputNotWritable, err)
+	}
+	f.Close()
+	flattenedFile := f.Name()
+	cleanup := func() { os.Remove(flattenedFile) }
+
+	if err := writeContextInPlace(ctx, flattenedFile); err != nil {
+		cleanup()
+		return inputFile, noop, err
+	}
+	return flattenedFile, cleanup, nil
+}
+
+// flattenAnnotations draws the normal appearance of every annotation of ctx
+// that prints onto its page and removes all annotations and the interactive
+// form. XFA forms without AcroForm fields are refused. It records the counts
+// on result and reports whether ctx was changed.
+func (p *PDFProcessor) flattenAnnotations(ctx *model.Context, result *Result) (bool, error) {
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return false, fmt.Errorf("failed to get catalog: %w", err)
+	}
+	form, _ := ctx.DereferenceDict(catalog["AcroForm"])
+	if err := checkXFA(ctx, catalog, form, result); err != nil {
+		return false, err
+	}
+	if form != nil {
+		if needs := form.BooleanEntry("NeedAppearances"); needs != nil && *needs {
+			result.warn("the form asks viewers to regenerate its field appearances, fields are printed as stored")
+		}
+	}
+
+	oc, _, err := readOptionalContent(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		flattened, dropped, err := p.flattenPageAnnotations(ctx, pageNr, oc, result)
+		if err != nil {
+			return false, fmt.Errorf("failed to flatten annotations of page %d: %w", pageNr, err)
+		}
+		result.FlattenedAnnotations += flattened
+		result.DroppedAnnotations += dropped
+	}
+
+	if result.FlattenedAnnotations+result.DroppedAnnotations == 0 {
+		return false, nil
+	}
+	catalog.Delete("AcroForm")
+	catalog.Delete("NeedsRendering")
+
+	logrus.WithFields(logrus.Fields{
+		"flattened": result.FlattenedAnnotations,
+		"dropped":   result.DroppedAnnotations,
+	}).Info("Flattened annotations and form fields")
+	return true, nil
+}
+
+// checkXFA refuses forms that only exist as XFA. Forms that also have
+// AcroForm fields are printed from those.
+func checkXFA(ctx *model.Context, catalog, form types.Dict, result *Result) error {
+	if form == nil {
+		return nil
+	}
+	if _, found := form.Find("XFA"); !found {
+		return nil
+	}
+
+	fields, _ := ctx.DereferenceArray(form["Fields"])
+	needsRendering := catalog.BooleanEntry("NeedsRendering")
+	if len(fields) == 0 || (needsRendering != nil && *needsRendering) {
+		return ErrXFAForm
+	}
+	result.warn("the form also has an XFA version, its AcroForm fields are printed")
+	return nil
+}
+
+// flattenPageAnnotations draws the printable annotations of a page after
+// its content and removes the page's annotations. It returns the number of
+// annotations drawn and dropped.
+func (p *PDFProcessor) flattenPageAnnotations(ctx *model.Context, pageNr int, oc *optionalContent, result *Result) (int, int, error) {
+	pageDict, _, _, err := ctx.PageDict(pageNr, false)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get page dict: %w", err)
+	}
+	annots, err := ctx.DereferenceArray(pageDict["Annots"])
+	if err != nil || len(annots) == 0 {
+		pageDict.Delete("Annots")
+		return 0, 0, nil
+	}
+
+	var ops strings.Builder
+	xobjects := types.Dict{}
+	dropped := 0
+	for _, obj := range annots {
+		annot, err := ctx.DereferenceDict(obj)
+		if err != nil || annot == nil {
+			dropped++
+			continue
+		}
+		if !printable(annot) || (oc != nil && !annotationVisible(oc, annot)) {
+			dropped++
+			continue
+		}
+
+		ref, sd, ok := appearance(ctx, annot)
+		if !ok {
+			if subtype := annot.Subtype(); subtype != nil && *subtype == "Widget" && fieldHasValue(ctx, annot) {
+				result.warn("form field %q on page %d has no appearance and is not printed", fieldName(ctx, annot), pageNr)
+			}
+			dropped++
+			continue
+		}
+		placement, ok := appearanceMatrix(ctx, annot, sd)
+		if !ok {
+			dropped++
+			continue
+		}
+
+		// Some producers leave out the form XObject entries of appearances
+		sd.Dict.Update("Type", types.Name("XObject"))
+		sd.Dict.Update("Subtype", types.Name("Form"))
+
+		name := fmt.Sprintf("Annot%d", ref.ObjectNumber.Value())
+		xobjects[name] = ref
+		fmt.Fprintf(&ops, "q\n%s cm\n/%s Do\nQ\n", placement, name)
+	}
+	pageDict.Delete("Annots")
+
+	flattened := len(xobjects)
+	if flattened == 0 {
+		return 0, dropped, nil
+	}
+	if err := addPageXObjects(ctx, pageDict, pageNr, xobjects); err != nil {
+		return 0, 0, err
+	}
+	if err := p.wrapPageContent(ctx, pageDict, "q\n", "\nQ\n"+ops.String()); err != nil {
+		return 0, 0, err
+	}
+	return flattened, dropped, nil
+}
+
+// printable reports whether the flags of an annotation let it print
+func printable(annot types.Dict) bool {
+	flags := 0
+	if f := annot.IntEntry("F"); f != nil {
+		flags = *f
+	}
+	return flags&annotFlagPrint != 0 && flags&annotFlagHidden == 0
+}
+
+// annotationVisible reports whether the optional content of an annotation prints
+func annotationVisible(oc *optionalContent, annot types.Dict) bool {
+	group, found := annot.Find("OC")
+	return !found || oc.visible(group)
+}
+
+// appearance returns the normal appearance stream of an annotation, in the
+// state selected by its appearance state if it has several
+func appearance(ctx *model.Context, annot types.Dict) (types.IndirectRef, *types.StreamDict, bool) {
+	ap, err := ctx.DereferenceDict(annot["AP"])
+	if err != nil || ap == nil {
+		return types.IndirectRef{}, nil, false
+	}
+
+	obj := ap["N"]
+	if states, err := ctx.DereferenceDict(obj); err == nil && states != nil {
+		state := annot.NameEntry("AS")
+		if state == nil {
+			return types.IndirectRef{}, nil, false
+		}
+		obj = states[*state]
+	}
+
+	ref, ok := obj.(types.IndirectRef)
+	if !ok {
+		return types.IndirectRef{}, nil, false
+	}
+	sd, _, err := ctx.DereferenceStreamDict(ref)
+	if err != nil || sd == nil {
+		return types.IndirectRef{}, nil, false
+	}
+	return ref, sd, true
+}
+
+// appearanceMatrix returns the matrix that maps the appearance stream's
+// bounding box, after its own matrix, onto the annotation rectangle as
+// described in ISO 32000-1, 12.5.5
+func appearanceMatrix(ctx *model.Context, annot types.Dict, sd *types.StreamDict) (matrix, bool) {
+	rectArray, err := ctx.DereferenceArray(annot["Rect"])
+	if err != nil || len(rectArray) != 4 {
+		return matrix{}, false
+	}
+	rect, err := ctx.RectForArray(rectArray)
+	if err != nil {
+		return matrix{}, false
+	}
+	bboxArray, err := ctx.DereferenceArray(sd.Dict["BBox"])
+	if err != nil || len(bboxArray) != 4 {
+		return matrix{}, false
+	}
+	bbox, err := ctx.RectForArray(bboxArray)
+	if err != nil {
+		return matrix{}, false
+	}
+
+	form := matrix{1, 0, 0, 1, 0, 0}
+	if m, err := ctx.DereferenceArray(sd.Dict["Matrix"]); err == nil && len(m) == 6 {
+		for i, v := range m {
+			if form[i], err = ctx.DereferenceNumber(v); err != nil {
+				return matrix{}, false
+			}
+		}
+	}
+
+	// Bounding box of the transformed appearance bounding box
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, corner := range [][2]float64{
+		{bbox.LL.X, bbox.LL.Y}, {bbox.UR.X, bbox.LL.Y}, {bbox.LL.X, bbox.UR.Y}, {bbox.UR.X, bbox.UR.Y},
+	} {
+		x := form[0]*corner[0] + form[2]*corner[1] + form[4]
+		y := form[1]*corner[0] + form[3]*corner[1] + form[5]
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	if maxX-minX <= 0 || maxY-minY <= 0 {
+		return matrix{}, false
+	}
+
+	sx := rect.Width() / (maxX - minX)
+	sy := rect.Height() / (maxY - minY)
+	return matrix{sx, 0, 0, sy, rect.LL.X - sx*minX, rect.LL.Y - sy*minY}, true
+}
+
+// addPageXObjects adds XObject resources to a page. Inherited resources are
+// copied to the page so that other pages do not change.
+func addPageXObjects(ctx *model.Context, pageDict types.Dict, pageNr int, xobjects types.Dict) error {
+	resources, err := pageResources(ctx, pageNr)
+	if err != nil {
+		return err
+	}
+	if _, found := pageDict.Find("Resources"); !found || resources == nil {
+		if resources == nil {
+			resources = types.Dict{}
+		} else {
+			resources = resources.Clone().(types.Dict)
+		}
+		pageDict.Update("Resources", resources)
+	}
+
+	existing, err := ctx.DereferenceDict(resources["XObject"])
+	if err != nil || existing == nil {
+		existing = types.Dict{}
+		resources.Update("XObject", existing)
+	}
+	for name, ref := range xobjects {
+		existing.Update(name, ref)
+	}
+	return nil
+}
+
+// fieldHasValue reports whether the field of a widget annotation has a value
+func fieldHasValue(ctx *model.Context, widget types.Dict) bool {
+	for d, depth := widget, 0; d != nil && depth < maxFormDepth; depth++ {
+		if v, found := d.Find("V"); found && v != nil {
+			return true
+		}
+		d, _ = ctx.DereferenceDict(d["Parent"])
+	}
+	return false
+}
+
+// fieldName returns the fully qualified name of the field of a widget annotation
+func fieldName(ctx *model.Context, widget types.Dict) string {
+	var parts []string
+	for d, depth := widget, 0; d != nil && depth < maxFormDepth; depth++ {
+		if obj, err := ctx.Dereference(d["T"]); err == nil && obj != nil {
+			if name, err := types.StringOrHexLiteral(obj); err == nil && name != nil {
+				parts = append([]string{*name}, parts...)
+			}
+		}
+		d, _ = ctx.DereferenceDict(d["Parent"])
+	}
+	if len(parts) == 0 {
+		return "unnamed"
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,506 @@
+package processor
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PDF/A conformance levels of the archival copy
+const (
+	ArchivePDFA2B = "pdfa-2b"
+
+	// ArchivePDFA3B also permits embedded files of any type, e.g. invoice data
+	ArchivePDFA3B = "pdfa-3b"
+)
+
+// archiveParts maps the conformance levels to their PDF/A part
+var archiveParts = map[string]int{
+	ArchivePDFA2B: 2,
+	ArchivePDFA3B: 3,
+}
+
+// ValidateArchiveLevel checks a PDF/A conformance level
+func ValidateArchiveLevel(level string) error {
+	if _, ok := archiveParts[level]; !ok {
+		return fmt.Errorf("unknown archive level %q, expected %s or %s", level, ArchivePDFA2B, ArchivePDFA3B)
+	}
+	return nil
+}
+
+// WriteArchive writes a PDF/A copy of convertedFile to archiveFile and
+// validates it. Fonts that are not embedded get a substitute from the font
+// directory, pages whose fonts have none and pages with transparency are
+// rasterized. The archival copy has an sRGB output intent and XMP metadata
+// matching the document information. Embedded e-invoice XML is kept as an
+// associated file, which needs PDF/A-3.
+func (p *PDFProcessor) WriteArchive(convertedFile, archiveFile, level string) (err error) {
+	part, ok := archiveParts[level]
+	if !ok {
+		return ValidateArchiveLevel(level)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"input":  convertedFile,
+		"output": archiveFile,
+		"level":  level,
+	}).Info("Writing archival copy")
+
+	// Never leave a copy behind that is not an archive
+	defer func() {
+		if err != nil {
+			os.Remove(archiveFile)
+		}
+	}()
+
+	ctx, err := p.readContextFile(convertedFile)
+	if err != nil {
+		return err
+	}
+	invoice, err := readInvoice(ctx)
+	if err != nil {
+		return err
+	}
+	if err := checkInvoiceArchive(invoice, level); err != nil {
+		return err
+	}
+	if err := writeContextInPlace(ctx, archiveFile); err != nil {
+		return err
+	}
+
+	if err := p.flattenForArchive(archiveFile); err != nil {
+		return err
+	}
+
+	// pdfcpu stamps the document information with the time of writing, the
+	// XMP dates have to match it to the second
+	for attempt := 1; ; attempt++ {
+		if ctx, err = p.readContextFile(archiveFile); err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := addArchiveMetadata(ctx, part, invoice, now); err != nil {
+			return err
+		}
+		if err := writeContextInPlace(ctx, archiveFile); err != nil {
+			return err
+		}
+		if types.DateString(time.Now()) == types.DateString(now) || attempt == 3 {
+			break
+		}
+	}
+
+	if problems := p.validateArchive(archiveFile, part); len(problems) > 0 {
+		return fmt.Errorf("%w: archival copy is not %s: %s", ErrNonCompliant, level, strings.Join(problems, "; "))
+	}
+
+	logrus.WithField("output", archiveFile).Info("Successfully created archival copy")
+	return nil
+}
+
+// flattenForArchive embeds substitutes for fonts that are not embedded and
+// rasterizes the pages with remaining font problems or transparency
+func (p *PDFProcessor) flattenForArchive(archiveFile string) error {
+	ctx, err := p.readContextFile(archiveFile)
+	if err != nil {
+		return err
+	}
+
+	issues, err := p.findFontIssues(ctx)
+	if err != nil {
+		return err
+	}
+	if len(issues) > 0 {
+		embedded, err := p.embedSubstituteFonts(ctx, p.opts.FontDir)
+		if err != nil {
+			return err
+		}
+		if len(embedded) > 0 {
+			logrus.WithField("fonts", embedded).Info("Embedded substitute fonts in archival copy")
+			if err := writeContextInPlace(ctx, archiveFile); err != nil {
+				return err
+			}
+			if issues, err = p.findFontIssues(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	pages := uniquePages(append(issuePages(issues), transparentPages(ctx)...))
+	if len(pages) == 0 {
+		return nil
+	}
+	return p.rasterizePages(archiveFile, pages, p.opts.Raster)
+}
+
+// uniquePages sorts pages and removes duplicates
+func uniquePages(pages []int) []int {
+	sort.Ints(pages)
+	unique := pages[:0]
+	for i, page := range pages {
+		if i == 0 || page != pages[i-1] {
+			unique = append(unique, page)
+		}
+	}
+	return unique
+}
+
+// addArchiveMetadata adds the sRGB output intent and the XMP metadata of a
+// PDF/A document of the given part to ctx, describing the invoice if any
+func addArchiveMetadata(ctx *model.Context, part int, invoice *Invoice, now time.Time) error {
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return fmt.Errorf("failed to get catalog: %w", err)
+	}
+
+	profile, err := ctx.NewStreamDictForBuf(srgbProfile())
+	if err != nil {
+		return fmt.Errorf("failed to create ICC profile stream: %w", err)
+	}
+	profile.InsertInt("N", 3)
+	if err := profile.Encode(); err != nil {
+		return fmt.Errorf("failed to encode ICC profile stream: %w", err)
+	}
+	profileRef, err := ctx.IndRefForNewObject(*profile)
+	if err != nil {
+		return err
+	}
+	catalog.Update("OutputIntents", types.Array{types.Dict{
+		"Type":                      types.Name("OutputIntent"),
+		"S":                         types.Name("GTS_PDFA1"),
+		"OutputConditionIdentifier": types.StringLiteral(sRGBOutputCondition),
+		"Info":                      types.StringLiteral(sRGBOutputCondition),
+		"DestOutputProfile":         *profileRef,
+	}})
+
+	// The metadata stream must not be compressed
+	metadata := types.StreamDict{
+		Dict: types.Dict{
+			"Type":    types.Name("Metadata"),
+			"Subtype": types.Name("XML"),
+		},
+		Content: xmpMetadata(documentInfo(ctx), part, invoice, now),
+	}
+	if err := metadata.Encode(); err != nil {
+		return fmt.Errorf("failed to encode metadata stream: %w", err)
+	}
+	metadataRef, err := ctx.IndRefForNewObject(metadata)
+	if err != nil {
+		return err
+	}
+	catalog.Update("Metadata", *metadataRef)
+	return nil
+}
+
+// documentInfo returns the text entries of the document information
+// dictionary that are kept when writing
+func documentInfo(ctx *model.Context) map[string]string {
+	info := make(map[string]string)
+	if ctx.Info == nil {
+		return info
+	}
+	d, err := ctx.DereferenceDict(*ctx.Info)
+	if err != nil || d == nil {
+		return info
+	}
+	for _, key := range []string{"Title", "Author", "Subject", "Keywords", "Creator"} {
+		obj, err := ctx.Dereference(d[key])
+		if err != nil || obj == nil {
+			continue
+		}
+		if s, err := types.StringOrHexLiteral(obj); err == nil && s != nil && *s != "" {
+			info[key] = *s
+		}
+	}
+	return info
+}
+
+// xmpDateFormat is the XMP date format with a time zone offset
+const xmpDateFormat = "2006-01-02T15:04:05-07:00"
+
+// xmpMetadata returns an XMP packet identifying a PDF/A-<part>b document.
+// Its properties mirror the document information, including the producer
+// and dates pdfcpu writes. An embedded invoice is described as well.
+func xmpMetadata(info map[string]string, part int, invoice *Invoice, now time.Time) []byte {
+	date := now.Format(xmpDateFormat)
+
+	var b strings.Builder
+	b.WriteString("<?xpacket begin=\"\uFEFF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\"\n")
+	b.WriteString("    xmlns:pdfaid=\"http://www.aiim.org/pdfa/ns/id/\"\n")
+	b.WriteString("    xmlns:dc=\"http://purl.org/dc/elements/1.1/\"\n")
+	b.WriteString("    xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\"\n")
+	b.WriteString("    xmlns:pdf=\"http://ns.adobe.com/pdf/1.3/\">\n")
+	fmt.Fprintf(&b, "   <pdfaid:part>%d</pdfaid:part>\n", part)
+	b.WriteString("   <pdfaid:conformance>B</pdfaid:conformance>\n")
+	b.WriteString("   <dc:format>application/pdf</dc:format>\n")
+	if title, ok := info["Title"]; ok {
+		fmt.Fprintf(&b, "   <dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n", xmlEscape(title))
+	}
+	if author, ok := info["Author"]; ok {
+		fmt.Fprintf(&b, "   <dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>\n", xmlEscape(author))
+	}
+	if subject, ok := info["Subject"]; ok {
+		fmt.Fprintf(&b, "   <dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:description>\n", xmlEscape(subject))
+	}
+	if keywords, ok := info["Keywords"]; ok {
+		fmt.Fprintf(&b, "   <pdf:Keywords>%s</pdf:Keywords>\n", xmlEscape(keywords))
+	}
+	if creator, ok := info["Creator"]; ok {
+		fmt.Fprintf(&b, "   <xmp:CreatorTool>%s</xmp:CreatorTool>\n", xmlEscape(creator))
+	}
+	fmt.Fprintf(&b, "   <pdf:Producer>%s</pdf:Producer>\n", xmlEscape("pdfcpu "+model.VersionStr))
+	fmt.Fprintf(&b, "   <xmp:CreateDate>%s</xmp:CreateDate>\n", date)
+	fmt.Fprintf(&b, "   <xmp:ModifyDate>%s</xmp:ModifyDate>\n", date)
+	fmt.Fprintf(&b, "   <xmp:MetadataDate>%s</xmp:MetadataDate>\n", date)
+	b.WriteString("  </rdf:Description>\n")
+	if invoice != nil {
+		writeInvoiceXMP(&b, invoice)
+	}
+	b.WriteString(" </rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+	b.WriteString("<?xpacket end=\"w\"?>")
+	return []byte(b.String())
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// transparentPages returns the pages of ctx that use transparency: a
+// transparency group, soft masks, constant alpha below one or blend modes
+func transparentPages(ctx *model.Context) []int {
+	var pages []int
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		pageDict, _, _, err := ctx.PageDict(pageNr, false)
+		if err != nil {
+			continue
+		}
+		resources, _ := pageResources(ctx, pageNr)
+		if transparencyGroup(ctx, pageDict) || transparentResources(ctx, resources, 0) {
+			pages = append(pages, pageNr)
+		}
+	}
+	return pages
+}
+
+// transparencyGroup reports whether a page or form has a transparency group
+func transparencyGroup(ctx *model.Context, d types.Dict) bool {
+	group, err := ctx.DereferenceDict(d["Group"])
+	if err != nil || group == nil {
+		return false
+	}
+	s := group.NameEntry("S")
+	return s != nil && *s == "Transparency"
+}
+
+// transparentResources reports whether the graphics states, images or forms
+// of resources use transparency
+func transparentResources(ctx *model.Context, resources types.Dict, depth int) bool {
+	if resources == nil || depth >= maxFormDepth {
+		return false
+	}
+
+	if states, err := ctx.DereferenceDict(resources["ExtGState"]); err == nil && states != nil {
+		for _, obj := range states {
+			gs, err := ctx.DereferenceDict(obj)
+			if err == nil && gs != nil && transparentGraphicsState(ctx, gs) {
+				return true
+			}
+		}
+	}
+
+	xobjects, err := ctx.DereferenceDict(resources["XObject"])
+	if err != nil || xobjects == nil {
+		return false
+	}
+	for _, obj := range xobjects {
+		sd, _, err := ctx.DereferenceStreamDict(obj)
+		if err != nil || sd == nil {
+			continue
+		}
+		switch subtype := sd.Subtype(); {
+		case subtype == nil:
+		case *subtype == "Image":
+			if _, found := sd.Find("SMask"); found {
+				return true
+			}
+			if n := sd.IntEntry("SMaskInData"); n != nil && *n != 0 {
+				return true
+			}
+		case *subtype == "Form":
+			if transparencyGroup(ctx, sd.Dict) {
+				return true
+			}
+			formResources, err := ctx.DereferenceDict(sd.Dict["Resources"])
+			if err == nil && transparentResources(ctx, formResources, depth+1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// transparentGraphicsState reports whether an ExtGState sets a soft mask,
+// an alpha below one or a blend mode other than Normal
+func transparentGraphicsState(ctx *model.Context, gs types.Dict) bool {
+	if obj, found := gs.Find("SMask"); found {
+		if name, ok := obj.(types.Name); !ok || name != "None" {
+			return true
+		}
+	}
+	for _, key := range []string{"CA", "ca"} {
+		obj, err := ctx.Dereference(gs[key])
+		if err != nil || obj == nil {
+			continue
+		}
+		switch v := obj.(type) {
+		case types.Float:
+			if v < 1 {
+				return true
+			}
+		case types.Integer:
+			if v < 1 {
+				return true
+			}
+		}
+	}
+	if obj, found := gs.Find("BM"); found {
+		switch v := obj.(type) {
+		case types.Name:
+			return v != "Normal" && v != "Compatible"
+		case types.Array:
+			return len(v) > 0 && v[0] != types.Name("Normal") && v[0] != types.Name("Compatible")
+		}
+	}
+	return false
+}
+
+// validateArchive checks the PDF/A requirements the archival copy is built
+// to meet and returns the problems found
+func (p *PDFProcessor) validateArchive(archiveFile string, part int) []string {
+	ctx, err := NewPDFProcessor().readContextFile(archiveFile)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	if ctx.Encrypt != nil {
+		problems = append(problems, "the file is encrypted")
+	}
+	if len(ctx.ID) != 2 {
+		problems = append(problems, "the trailer has no file identifier")
+	}
+
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	if !hasPDFAOutputIntent(ctx, catalog) {
+		problems = append(problems, "no PDF/A output intent with an ICC profile")
+	}
+	if xmp, ok := pdfaMetadata(ctx, catalog, part); !ok {
+		problems = append(problems, fmt.Sprintf("no XMP metadata identifying PDF/A-%db", part))
+	} else if modDate := documentDate(ctx, "ModDate"); modDate != "" && !strings.Contains(xmp, ">"+modDate+"<") {
+		problems = append(problems, "the XMP metadata does not match the document information")
+	}
+
+	if names, err := ctx.DereferenceDict(catalog["Names"]); err == nil && names != nil {
+		if _, found := names.Find("JavaScript"); found {
+			problems = append(problems, "the document contains JavaScript")
+		}
+		if _, found := names.Find("EmbeddedFiles"); found && part < 3 {
+			problems = append(problems, "embedded files need PDF/A-3")
+		}
+	}
+
+	issues, err := p.findFontIssues(ctx)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, issue := range issues {
+		problems = append(problems, issue.String())
+	}
+	for _, page := range transparentPages(ctx) {
+		problems = append(problems, fmt.Sprintf("page %d uses transparency", page))
+	}
+
+	return problems
+}
+
+// hasPDFAOutputIntent reports whether the catalog has a GTS_PDFA1 output
+// intent with an embedded ICC profile
+func hasPDFAOutputIntent(ctx *model.Context, catalog types.Dict) bool {
+	intents, err := ctx.DereferenceArray(catalog["OutputIntents"])
+	if err != nil {
+		return false
+	}
+	for _, obj := range intents {
+		intent, err := ctx.DereferenceDict(obj)
+		if err != nil || intent == nil {
+			continue
+		}
+		if s := intent.NameEntry("S"); s == nil || *s != "GTS_PDFA1" {
+			continue
+		}
+		if profile, _, err := ctx.DereferenceStreamDict(intent["DestOutputProfile"]); err == nil && profile != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// pdfaMetadata returns the uncompressed XMP metadata stream of the catalog
+// if it declares the given PDF/A part
+func pdfaMetadata(ctx *model.Context, catalog types.Dict, part int) (string, bool) {
+	sd, _, err := ctx.DereferenceStreamDict(catalog["Metadata"])
+	if err != nil || sd == nil {
+		return "", false
+	}
+	if _, found := sd.Find("Filter"); found {
+		return "", false
+	}
+	if err := sd.Decode(); err != nil {
+		return "", false
+	}
+	xmp := string(sd.Content)
+	ok := strings.Contains(xmp, fmt.Sprintf("<pdfaid:part>%d</pdfaid:part>", part)) &&
+		strings.Contains(xmp, "<pdfaid:conformance>B</pdfaid:conformance>")
+	return xmp, ok
+}
+
+// documentDate returns a date of the document information in XMP format,
+// or "" if it is not set
+func documentDate(ctx *model.Context, key string) string {
+	if ctx.Info == nil {
+		return ""
+	}
+	d, err := ctx.DereferenceDict(*ctx.Info)
+	if err != nil || d == nil {
+		return ""
+	}
+	obj, err := ctx.Dereference(d[key])
+	if err != nil || obj == nil {
+		return ""
+	}
+	s, err := types.StringOrHexLiteral(obj)
+	if err != nil || s == nil {
+		return ""
+	}
+	t, ok := types.DateTime(*s, true)
+	if !ok {
+		return ""
+	}
+	return t.Format(xmpDateFormat)
+}
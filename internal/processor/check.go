@@ -0,0 +1,86 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CheckResult describes whether a PDF can be sent to LetterXpress as it is
+type CheckResult struct {
+	File       string           `json:"file"`
+	PageCount  int              `json:"page_count"`
+	Compliant  bool             `json:"compliant"`
+	Findings   []string         `json:"findings"`
+	Warnings   []string         `json:"warnings"`
+	ColorPages []int            `json:"color_pages"`
+	FontIssues []FontIssue      `json:"font_issues"`
+	Postage    *PostageEstimate `json:"postage,omitempty"`
+}
+
+func (r *CheckResult) addFinding(format string, args ...interface{}) {
+	r.Findings = append(r.Findings, fmt.Sprintf(format, args...))
+	r.Compliant = false
+}
+
+// Check inspects a PDF, usually a converted letter, without changing it and
+// estimates its postage
+func (p *PDFProcessor) Check(inputFile string) (*CheckResult, error) {
+	logrus.WithField("input", inputFile).Info("Checking PDF")
+
+	result := &CheckResult{File: inputFile, Compliant: true, Findings: []string{}, Warnings: []string{}}
+
+	ctx, err := p.readContextFile(inputFile)
+	if err != nil {
+		return result, err
+	}
+	result.PageCount = ctx.PageCount
+
+	if ctx.PageCount == 0 {
+		result.addFinding("document has no pages")
+	}
+
+	dims, err := ctx.PageDims()
+	if err != nil {
+		return result, fmt.Errorf("%w: failed to get page dimensions: %w", ErrInvalidPDF, err)
+	}
+
+	// Same tolerance as the verification of converted output
+	const tolerance = 1.0
+	for i, dim := range dims {
+		if math.Abs(dim.Width-A4WidthPoints) > tolerance || math.Abs(dim.Height-A4HeightPoints) > tolerance {
+			result.addFinding("page %d is %.1f × %.1f mm instead of A4 portrait", i+1, dim.Width/PointsPerMM, dim.Height/PointsPerMM)
+		}
+	}
+
+	result.FontIssues, err = p.findFontIssues(ctx)
+	if err != nil {
+		return result, err
+	}
+	for _, issue := range result.FontIssues {
+		result.addFinding("%s", issue)
+	}
+
+	detection := &Result{}
+	result.ColorPages, err = p.findColorPages(ctx, inputFile, true, detection)
+	if err != nil {
+		return result, err
+	}
+	result.Warnings = append(result.Warnings, detection.Warnings...)
+
+	postageOpts := p.opts.Postage
+	postageOpts.Duplex = p.opts.Duplex
+	result.Postage, err = EstimatePostage(ctx.PageCount, postageOpts)
+	if err != nil {
+		result.addFinding("%v", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"input":     inputFile,
+		"compliant": result.Compliant,
+		"findings":  len(result.Findings),
+	}).Info("Finished check")
+
+	return result, nil
+}
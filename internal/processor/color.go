@@ -0,0 +1,140 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/sirupsen/logrus"
+)
+
+// Raster color sampling renders pages at colorSampleDPI and treats a page as
+// color when more than colorPixelShare of its pixels have a chroma above
+// colorChromaThreshold. The share ignores JPEG artifacts around black text
+// but still catches a small colored logo.
+const (
+	colorSampleDPI       = 30
+	colorChromaThreshold = "15%"
+	colorPixelShare      = 0.0005
+)
+
+// findColorPages returns the pages of ctx that print in color. Pages whose
+// content stream is inconclusive, e.g. because of RGB images, are rendered
+// and sampled when raster is set and ImageMagick is available, otherwise
+// they are counted as color.
+func (p *PDFProcessor) findColorPages(ctx *model.Context, inputFile string, raster bool, result *Result) ([]int, error) {
+	if raster {
+		if _, err := exec.LookPath("convert"); err != nil {
+			raster = false
+		}
+	}
+
+	colorPages := []int{}
+	var assumed []int
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		content, err := p.analyzePage(ctx, pageNr)
+		if err != nil {
+			return nil, err
+		}
+
+		isColor := content.Color
+		if !isColor && content.ColorUncertain {
+			if raster {
+				isColor, err = p.isColorPage(inputFile, pageNr)
+				if err != nil {
+					result.warn("color sampling failed on page %d: %v", pageNr, err)
+					isColor = true
+				}
+			} else {
+				assumed = append(assumed, pageNr)
+				isColor = true
+			}
+		}
+
+		if isColor {
+			colorPages = append(colorPages, pageNr)
+		}
+	}
+
+	if len(assumed) > 0 {
+		result.warn("pages %s contain images that could not be sampled and are counted as color", joinPages(assumed))
+	}
+
+	logrus.WithField("pages", colorPages).Debug("Detected color pages")
+	return colorPages, nil
+}
+
+// isColorPage renders a page and measures the share of clearly colored pixels
+func (p *PDFProcessor) isColorPage(inputFile string, pageNr int) (bool, error) {
+	cmd := exec.Command("convert",
+		"-density", strconv.Itoa(colorSampleDPI),
+		fmt.Sprintf("%s[%d]", inputFile, pageNr-1),
+		"-background", "white",
+		"-flatten",
+		"-colorspace", "HCL",
+		"-channel", "G",
+		"-separate",
+		"+channel",
+		"-threshold", colorChromaThreshold,
+		"-format", "%[fx:mean]",
+		"info:",
+	)
+	logrus.WithField("command", strings.Join(cmd.Args, " ")).Debug("Sampling page colors")
+
+	output, err := cmd.Output()
+	if err != nil {
+		return false, err
+	}
+
+	share, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return false, fmt.Errorf("unexpected ImageMagick output %q", output)
+	}
+	return share > colorPixelShare, nil
+}
+
+// convertToGrayscale converts outputFile in place so that it prints
+// black-and-white. Vector content stays vector.
+func (p *PDFProcessor) convertToGrayscale(outputFile string) error {
+	if _, err := exec.LookPath("gs"); err != nil {
+		return fmt.Errorf("%w: grayscale conversion needs Ghostscript: %w", ErrToolMissing, err)
+	}
+
+	tempFile := outputFile + ".gray.tmp"
+	defer os.Remove(tempFile)
+
+	cmd := exec.Command("gs",
+		"-q", "-dNOPAUSE", "-dBATCH", "-dSAFER",
+		"-sDEVICE=pdfwrite",
+		"-sColorConversionStrategy=Gray",
+		"-dProcessColorModel=/DeviceGray",
+		"-dAutoRotatePages=/None",
+		"-o", tempFile,
+		outputFile,
+	)
+	logrus.WithField("command", strings.Join(cmd.Args, " ")).Debug("Converting output to grayscale")
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		logrus.WithError(err).WithField("output", string(output)).Error("Grayscale conversion failed")
+		return fmt.Errorf("%w: grayscale conversion failed: %w", ErrEngineFailed, err)
+	}
+
+	if err := os.Rename(tempFile, outputFile); err != nil {
+		return fmt.Errorf("%w: failed to replace output: %w", ErrOutputNotWritable, err)
+	}
+
+	logrus.WithField("output", outputFile).Info("Converted output to grayscale")
+	return nil
+}
+
+// joinPages formats page numbers as a comma separated list
+func joinPages(pages []int) string {
+	s := make([]string, len(pages))
+	for i, page := range pages {
+		s[i] = strconv.Itoa(page)
+	}
+	return strings.Join(s, ", ")
+}
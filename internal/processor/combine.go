@@ -0,0 +1,157 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/sirupsen/logrus"
+)
+
+// Section is one of the documents that were combined into a letter
+type Section struct {
+	File string
+
+	// FirstPage is the 1-based page of the combined letter the section starts on
+	FirstPage int
+	PageCount int
+
+	// BlankPagesAdded counts the blank pages inserted after the section
+	BlankPagesAdded int
+}
+
+// CombineOptions controls Combine
+type CombineOptions struct {
+	// SheetAligned inserts a blank page after every document with an odd
+	// page count so that the next one starts on a new sheet in duplex
+	SheetAligned bool
+
+	// DryRun only plans the conversion of the combined document. The inputs
+	// are still converted into PDFs and concatenated in a temporary directory
+	// to know their pages.
+	DryRun bool
+}
+
+// Combine concatenates inputFiles in order and converts the result into a
+// single letter
+func (p *PDFProcessor) Combine(inputFiles []string, outputFile string, opts CombineOptions) (*Result, error) {
+	logrus.WithFields(logrus.Fields{
+		"inputs": len(inputFiles),
+		"output": outputFile,
+	}).Info("Combining PDF files into one letter")
+
+	result := &Result{OutputFile: outputFile, StartedAt: time.Now()}
+	result.FinishedAt = result.StartedAt
+	if len(inputFiles) == 0 {
+		return result, fmt.Errorf("no input files to combine")
+	}
+	result.InputFile = inputFiles[0]
+
+	tempDir, err := os.MkdirTemp("", "pdf2letterexpress-combine-")
+	if err != nil {
+		return result, fmt.Errorf("cannot create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var sections []Section
+	var insertAfter []string
+	var sourceFiles []string
+	encrypted := false
+	nextPage := 1
+	for i, inputFile := range inputFiles {
+		// Encrypted inputs are combined from decrypted copies in tempDir
+		sourceFile, _, err := p.openInput(inputFile, tempDir, result)
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", inputFile, err)
+		}
+		sourceFiles = append(sourceFiles, sourceFile)
+		encrypted = encrypted || sourceFile != inputFile
+
+		ctx, err := p.readContextFile(sourceFile)
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", inputFile, err)
+		}
+
+		section := Section{File: inputFile, FirstPage: nextPage, PageCount: ctx.PageCount}
+		nextPage += ctx.PageCount
+
+		if opts.SheetAligned && i < len(inputFiles)-1 && ctx.PageCount%2 == 1 {
+			insertAfter = append(insertAfter, strconv.Itoa(nextPage-1))
+			section.BlankPagesAdded = 1
+			nextPage++
+		}
+		sections = append(sections, section)
+	}
+
+	combinedFile := filepath.Join(tempDir, "combined.pdf")
+	if len(sourceFiles) == 1 {
+		combinedFile = sourceFiles[0]
+	} else if err := api.MergeCreateFile(sourceFiles, combinedFile, false, p.config); err != nil {
+		return result, fmt.Errorf("%w: failed to concatenate input files: %w", ErrInvalidPDF, err)
+	}
+
+	if len(insertAfter) > 0 {
+		alignedFile := filepath.Join(tempDir, "aligned.pdf")
+		if err := api.InsertPagesFile(combinedFile, alignedFile, insertAfter, false, nil, p.config); err != nil {
+			return result, fmt.Errorf("failed to insert blank pages: %w", err)
+		}
+		combinedFile = alignedFile
+		logrus.WithField("afterPages", insertAfter).Debug("Inserted blank pages for duplex")
+	}
+
+	// In duplex mode every document starts on a front side, even after blank pages were removed
+	procOpts := p.opts
+	if procOpts.Duplex {
+		procOpts.SectionStarts = nil
+		for _, section := range sections {
+			procOpts.SectionStarts = append(procOpts.SectionStarts, section.FirstPage)
+		}
+	}
+
+	// Keep the warnings about the inputs
+	inputWarnings := result.Warnings
+
+	if opts.DryRun {
+		result, err = p.plan(combinedFile, outputFile, procOpts)
+	} else {
+		result, err = p.process(combinedFile, outputFile, procOpts)
+	}
+	result.InputFile = inputFiles[0]
+	result.Encrypted = result.Encrypted || encrypted
+	result.Warnings = append(inputWarnings, result.Warnings...)
+	result.Sections = renumberSections(sections, result.RemovedPages, result.InsertedPages)
+	return result, err
+}
+
+// renumberSections moves the sections to their pages in the output after
+// blank pages were removed from or inserted into the combined document
+func renumberSections(sections []Section, removed, inserted []int) []Section {
+	outputPage := func(page int) int {
+		for _, r := range removed {
+			if r < page {
+				page--
+			}
+		}
+		for _, i := range inserted {
+			if i <= page {
+				page++
+			}
+		}
+		return page
+	}
+
+	for i := range sections {
+		first := sections[i].FirstPage
+		last := first + sections[i].PageCount - 1
+		for _, r := range removed {
+			if r >= first && r <= last {
+				sections[i].PageCount--
+			}
+		}
+		sections[i].FirstPage = outputPage(first)
+	}
+	return sections
+}
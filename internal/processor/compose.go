@@ -0,0 +1,448 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/text/encoding/charmap"
+
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
+)
+
+// DIN 5008 Form B layout of composed letters in mm from the top left corner.
+// The address uses the position of DefaultMergeLayout.
+const (
+	composeLeftMM       = 25.0
+	composeRightMM      = 190.0
+	composeSenderTopMM  = 15.0
+	composeInfoLeftMM   = 125.0
+	composeSubjectTopMM = 98.46
+	composeNextTopMM    = 20.0
+	composeBottomMM     = 270.0
+	composeFooterTopMM  = 282.0
+	composeIndentMM     = 5.0
+
+	composeFontSize    = 11.0
+	composeSenderSize  = 9.0
+	composeReturnSize  = 7.0
+	composeFooterSize  = 8.0
+	composeLineSpacing = 1.25
+)
+
+// composeFontNames are the font resource names by textStyle
+var composeFontNames = [...]string{"F1", "F2", "F3", "F4"}
+
+// Letter is the content of a letter for Compose
+type Letter struct {
+	Sender    []string
+	Recipient []string
+	Subject   string
+	// Date is printed as given, ISO dates like 2006-01-02 as 02.01.2006.
+	// Empty means today.
+	Date string
+	Body string
+	// Markdown formats the body as Markdown instead of plain text
+	Markdown bool
+}
+
+// ComposeResult describes a letter written by Compose
+type ComposeResult struct {
+	OutputFile string   `json:"output_file"`
+	PageCount  int      `json:"page_count"`
+	Warnings   []string `json:"warnings"`
+	// Check is the check of the written letter
+	Check *CheckResult `json:"check,omitempty"`
+}
+
+func (r *ComposeResult) warn(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	r.Warnings = append(r.Warnings, msg)
+	logrus.Warn(msg)
+}
+
+// LoadLetter reads the body of a letter from a Markdown (.md, .markdown) or
+// text file. YAML front matter fills in the sender, recipient, subject and
+// date where letter does not set them.
+func LoadLetter(path string, letter *Letter) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: file does not exist: %s", utils.ErrInputNotFound, path)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read letter: %w", err)
+	}
+
+	front, body := splitFrontMatter(data)
+	if front != nil {
+		if err := parseFrontMatter(front, letter); err != nil {
+			return err
+		}
+	}
+	letter.Body = string(body)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown":
+		letter.Markdown = true
+	}
+	return nil
+}
+
+// Compose lays out a letter on DIN 5008 Form B pages with embedded fonts and
+// writes it to outputFile. The body breaks onto further pages, which are
+// numbered in the footer. The written letter is checked, see Check.
+func (p *PDFProcessor) Compose(letter Letter, outputFile string) (*ComposeResult, error) {
+	logrus.WithField("output", outputFile).Info("Composing letter")
+
+	result := &ComposeResult{OutputFile: outputFile, Warnings: []string{}}
+	if len(letter.Recipient) == 0 {
+		return result, fmt.Errorf("the letter has no recipient")
+	}
+
+	c, err := newComposer(result)
+	if err != nil {
+		return result, err
+	}
+	c.header(letter)
+
+	blocks := parsePlainText(letter.Body)
+	if letter.Markdown {
+		blocks = parseMarkdown(letter.Body)
+	}
+	c.body(blocks)
+	c.footer()
+	if c.unencodable {
+		result.warn("characters outside Windows-1252 are printed as ?")
+	}
+
+	ctx, err := pdfcpu.CreateContextWithXRefTable(p.config, &types.Dim{Width: A4WidthPoints, Height: A4HeightPoints})
+	if err != nil {
+		return result, fmt.Errorf("failed to create PDF: %w", err)
+	}
+	pagesRef, pages, err := pageTree(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	// Only the styles in use are embedded
+	fonts := types.Dict{}
+	for style, used := range c.used {
+		if !used {
+			continue
+		}
+		fontRef, err := c.fonts[style].Embed(ctx)
+		if err != nil {
+			return result, err
+		}
+		fonts[composeFontNames[style]] = *fontRef
+	}
+
+	kids := types.Array{}
+	for _, texts := range c.pages {
+		contentRef, err := p.newContentStream(ctx, c.content(texts))
+		if err != nil {
+			return result, err
+		}
+		pageRef, err := ctx.IndRefForNewObject(types.Dict{
+			"Type":      types.Name("Page"),
+			"Parent":    pagesRef,
+			"Contents":  *contentRef,
+			"Resources": types.Dict{"Font": fonts.Clone()},
+		})
+		if err != nil {
+			return result, err
+		}
+		kids = append(kids, *pageRef)
+	}
+	pages.Update("Kids", kids)
+	pages.Update("Count", types.Integer(len(kids)))
+	ctx.PageCount = len(kids)
+
+	if err := writeContextInPlace(ctx, outputFile); err != nil {
+		return result, err
+	}
+	result.PageCount = len(kids)
+
+	result.Check, err = p.Check(outputFile)
+	if err != nil {
+		return result, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"output": outputFile,
+		"pages":  result.PageCount,
+	}).Info("Composed letter")
+	return result, nil
+}
+
+// composedText is a run of text in one style; yMM is the top of its line
+type composedText struct {
+	xMM   float64
+	yMM   float64
+	size  float64
+	style textStyle
+	text  string
+}
+
+// composer places the text of a letter onto pages
+type composer struct {
+	fonts  [4]*TrueTypeFont
+	result *ComposeResult
+	pages  [][]composedText
+	// y is the top of the next body line on the last page
+	y           float64
+	used        [4]bool
+	unencodable bool
+}
+
+func newComposer(result *ComposeResult) (*composer, error) {
+	c := &composer{result: result, pages: [][]composedText{nil}}
+	for style, data := range [][]byte{goregular.TTF, gobold.TTF, goitalic.TTF, gobolditalic.TTF} {
+		font, err := parseTrueTypeFont(data)
+		if err != nil {
+			return nil, err
+		}
+		c.fonts[style] = font
+	}
+	return c, nil
+}
+
+// lineHeight returns the distance of lines of the given font size in mm
+func lineHeight(size float64) float64 {
+	return size * composeLineSpacing / PointsPerMM
+}
+
+// width returns the width of text in mm
+func (c *composer) width(text string, size float64, style textStyle) float64 {
+	return c.fonts[style].TextWidth(text, size) / PointsPerMM
+}
+
+// place adds text to the last page
+func (c *composer) place(xMM, yMM, size float64, style textStyle, text string) {
+	c.placeOn(len(c.pages)-1, xMM, yMM, size, style, text)
+}
+
+func (c *composer) placeOn(page int, xMM, yMM, size float64, style textStyle, text string) {
+	for _, r := range text {
+		if _, ok := charmap.Windows1252.EncodeRune(r); !ok {
+			c.unencodable = true
+		}
+	}
+	c.used[style] = true
+	c.pages[page] = append(c.pages[page], composedText{xMM: xMM, yMM: yMM, size: size, style: style, text: text})
+}
+
+// header places the letterhead, return address, recipient, date and subject
+// on the first page
+func (c *composer) header(letter Letter) {
+	y := composeSenderTopMM
+	for i, line := range letter.Sender {
+		style := styleRegular
+		if i == 0 {
+			style = styleBold
+		}
+		c.place(composeRightMM-c.width(line, composeSenderSize, style), y, composeSenderSize, style, line)
+		y += lineHeight(composeSenderSize)
+	}
+
+	layout := DefaultMergeLayout()
+	address := layout.Address
+	if len(letter.Sender) > 0 {
+		returnAddress := strings.Join(letter.Sender, " · ")
+		c.place(address.XMM, AddressWindowTopMM+1.5, composeReturnSize, styleRegular, returnAddress)
+		if c.width(returnAddress, composeReturnSize, styleRegular) > address.WidthMM {
+			c.result.warn("the return address is wider than the address window")
+		}
+	}
+
+	if maxLines := int((AddressWindowTopMM + AddressWindowHeightMM - address.YMM) / address.LineHeightMM); len(letter.Recipient) > maxLines {
+		c.result.warn("the recipient has %d lines, the address window shows %d", len(letter.Recipient), maxLines)
+	}
+	for i, line := range letter.Recipient {
+		c.place(address.XMM, address.YMM+float64(i)*address.LineHeightMM, layout.FontSize, styleRegular, line)
+		if c.width(line, layout.FontSize, styleRegular) > address.WidthMM {
+			c.result.warn("recipient line %q is wider than the address window", line)
+		}
+	}
+
+	c.place(composeInfoLeftMM, AddressWindowTopMM+ReturnAddressHeightMM, layout.FontSize, styleRegular, letterDate(letter.Date, time.Now()))
+
+	c.y = composeSubjectTopMM
+	if letter.Subject != "" {
+		for _, line := range c.wrap([]textSpan{{text: letter.Subject, style: styleBold}}, composeRightMM-composeLeftMM) {
+			c.placeLine(composeLeftMM, line)
+		}
+		// Two blank lines between subject and body
+		c.y += 2 * lineHeight(composeFontSize)
+	}
+}
+
+// letterDate returns the date printed on a letter
+func letterDate(date string, now time.Time) string {
+	if date == "" {
+		return now.Format("02.01.2006")
+	}
+	if t, err := time.Parse("2006-01-02", date); err == nil {
+		return t.Format("02.01.2006")
+	}
+	return date
+}
+
+// body places the blocks of the body below the subject with a blank line
+// between paragraphs, breaking onto new pages at the bottom margin
+func (c *composer) body(blocks []textBlock) {
+	lh := lineHeight(composeFontSize)
+	for i, block := range blocks {
+		gap := lh
+		if i == 0 || block.marker != "" && blocks[i-1].marker != "" {
+			gap = 0
+		}
+		// A heading is kept together with the first line that follows it
+		need := lh
+		if block.heading {
+			need = 2 * lh
+		}
+		if c.y+gap+need > composeBottomMM {
+			c.newPage()
+		} else {
+			c.y += gap
+		}
+
+		indent := 0.0
+		if block.marker != "" || block.quote {
+			indent = composeIndentMM
+		}
+		for j, line := range c.wrap(block.spans, composeRightMM-composeLeftMM-indent) {
+			if c.y+lh > composeBottomMM {
+				c.newPage()
+			}
+			if j == 0 && block.marker != "" {
+				c.place(composeLeftMM, c.y, composeFontSize, styleRegular, block.marker)
+			}
+			c.placeLine(composeLeftMM+indent, line)
+		}
+	}
+}
+
+// placeLine places a wrapped line of spans at c.y and advances c.y
+func (c *composer) placeLine(xMM float64, line []textSpan) {
+	for _, span := range line {
+		c.place(xMM, c.y, composeFontSize, span.style, span.text)
+		xMM += c.width(span.text, composeFontSize, span.style)
+	}
+	c.y += lineHeight(composeFontSize)
+}
+
+func (c *composer) newPage() {
+	c.pages = append(c.pages, nil)
+	c.y = composeNextTopMM
+}
+
+// footer numbers the pages of letters with more than one page
+func (c *composer) footer() {
+	if len(c.pages) < 2 {
+		return
+	}
+	for i := range c.pages {
+		text := fmt.Sprintf("Seite %d von %d", i+1, len(c.pages))
+		c.placeOn(i, composeRightMM-c.width(text, composeFooterSize, styleRegular), composeFooterTopMM, composeFooterSize, styleRegular, text)
+	}
+}
+
+// wrap breaks spans into lines no wider than maxWidth mm at spaces and line
+// breaks. Words that are wider than a line are broken anywhere.
+func (c *composer) wrap(spans []textSpan, maxWidth float64) [][]textSpan {
+	var lines [][]textSpan
+	var line []textSpan
+	lineWidth := 0.0
+	space := false
+
+	add := func(text string, style textStyle) {
+		if n := len(line); n > 0 && line[n-1].style == style {
+			line[n-1].text += text
+		} else {
+			line = append(line, textSpan{text: text, style: style})
+		}
+	}
+	breakLine := func() {
+		lines = append(lines, line)
+		line, lineWidth, space = nil, 0, false
+	}
+	addWord := func(word string, style textStyle) {
+		w := c.width(word, composeFontSize, style)
+		spaceWidth := 0.0
+		if space && len(line) > 0 {
+			spaceWidth = c.width(" ", composeFontSize, style)
+		}
+		if len(line) > 0 && lineWidth+spaceWidth+w > maxWidth {
+			breakLine()
+			spaceWidth = 0
+		}
+		for runes := []rune(word); len(line) == 0 && w > maxWidth && len(runes) > 1; runes = []rune(word) {
+			n := 1
+			for n < len(runes)-1 && c.width(string(runes[:n+1]), composeFontSize, style) <= maxWidth {
+				n++
+			}
+			add(string(runes[:n]), style)
+			breakLine()
+			word = string(runes[n:])
+			w = c.width(word, composeFontSize, style)
+		}
+		if spaceWidth > 0 {
+			add(" ", style)
+		}
+		add(word, style)
+		lineWidth += spaceWidth + w
+		space = false
+	}
+
+	for _, span := range spans {
+		for i, text := range strings.Split(span.text, "\n") {
+			if i > 0 {
+				breakLine()
+			}
+			var word strings.Builder
+			for _, r := range text {
+				if r == ' ' || r == '\t' {
+					if word.Len() > 0 {
+						addWord(word.String(), span.style)
+						word.Reset()
+					}
+					space = true
+					continue
+				}
+				word.WriteRune(r)
+			}
+			if word.Len() > 0 {
+				addWord(word.String(), span.style)
+			}
+		}
+	}
+	if len(line) > 0 || len(lines) == 0 {
+		breakLine()
+	}
+	return lines
+}
+
+// content returns the content stream that draws texts
+func (c *composer) content(texts []composedText) string {
+	var b strings.Builder
+	b.WriteString("0 g\nBT\n")
+	for _, t := range texts {
+		font := c.fonts[t.style]
+		baseline := A4HeightPoints - t.yMM*PointsPerMM - float64(font.ascent)*t.size/1000
+		fmt.Fprintf(&b, "/%s %.2f Tf\n1 0 0 1 %.2f %.2f Tm\n(%s) Tj\n", composeFontNames[t.style], t.size, t.xMM*PointsPerMM, baseline, font.Encode(t.text))
+	}
+	b.WriteString("ET\n")
+	return b.String()
+}
@@ -0,0 +1,543 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// maxFormDepth limits how deep nested form XObjects are followed
+const maxFormDepth = 4
+
+// pageContent summarizes what the content stream of a page draws
+type pageContent struct {
+	// Text shown by the page, decoded as WinAnsi. Fonts with other
+	// encodings, e.g. Identity-H, produce unreadable text here.
+	Text string
+
+	// Painted is set when the page draws anything visible
+	Painted bool
+
+	// Color is set when the page uses chromatic colors
+	Color bool
+
+	// Images is set when the page draws images or shadings
+	Images bool
+
+	// ColorUncertain is set when the page draws images, shadings or uses
+	// color spaces whose pixels have to be inspected to know whether they
+	// are colored
+	ColorUncertain bool
+}
+
+// analyzePage scans the content of a page including its form XObjects
+func (p *PDFProcessor) analyzePage(ctx *model.Context, pageNr int) (*pageContent, error) {
+	pageDict, _, inhPAttrs, err := ctx.PageDict(pageNr, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get page dict: %w", err)
+	}
+
+	info := &pageContent{}
+
+	content, err := ctx.PageContent(pageDict, pageNr)
+	if errors.Is(err, model.ErrNoContent) {
+		return info, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read content of page %d: %w", pageNr, err)
+	}
+
+	var resources types.Dict
+	if obj, found := pageDict.Find("Resources"); found {
+		resources, _ = ctx.DereferenceDict(obj)
+	} else if inhPAttrs != nil {
+		resources = inhPAttrs.Resources
+	}
+
+	scanner := &contentScanner{ctx: ctx, info: info}
+	scanner.scan(content, resources, 0)
+	info.Text = strings.TrimSpace(scanner.text.String())
+
+	return info, nil
+}
+
+// contentScanner walks the operators of a page and its form XObjects
+type contentScanner struct {
+	ctx  *model.Context
+	info *pageContent
+	text strings.Builder
+}
+
+// Kinds of color spaces as far as color detection is concerned
+type colorSpaceKind int
+
+const (
+	colorSpaceGray colorSpaceKind = iota
+	colorSpaceRGB
+	colorSpaceCMYK
+	colorSpaceOther
+)
+
+// colorState is the color a fill or stroke operation paints with
+type colorState struct {
+	space     colorSpaceKind
+	color     bool
+	uncertain bool
+}
+
+// scan walks the operators of a content stream
+func (s *contentScanner) scan(content []byte, resources types.Dict, depth int) {
+	var operands []contentToken
+	var fill, stroke colorState
+
+	// paint records a fill or stroke with the current color
+	paint := func(states ...colorState) {
+		s.info.Painted = true
+		for _, state := range states {
+			s.info.Color = s.info.Color || state.color
+			s.info.ColorUncertain = s.info.ColorUncertain || state.uncertain
+		}
+	}
+
+	for _, tok := range tokenizeContent(content) {
+		if tok.kind != tokenOperator {
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch tok.value {
+		case "Tj", "TJ", "'", "\"":
+			for _, operand := range operands {
+				if operand.kind != tokenString {
+					continue
+				}
+				text := decodeWinAnsi(operand.value)
+				s.text.WriteString(text)
+				if strings.TrimSpace(text) != "" {
+					paint(fill)
+				}
+			}
+		case "Td", "TD", "T*", "Tm":
+			s.text.WriteString(" ")
+		case "ET":
+			s.text.WriteString("\n")
+		case "f", "F", "f*":
+			paint(fill)
+		case "S", "s":
+			paint(stroke)
+		case "B", "B*", "b", "b*":
+			paint(fill, stroke)
+		case "BI":
+			s.info.Painted = true
+		case "sh":
+			s.info.Images = true
+			paint(colorState{uncertain: true})
+		case "ID":
+			s.inlineImage(operands, resources, fill)
+		case "Do":
+			if len(operands) > 0 {
+				s.xobject(resources, operands[len(operands)-1].value, depth, fill)
+			}
+
+		case "g":
+			fill = colorState{space: colorSpaceGray}
+		case "G":
+			stroke = colorState{space: colorSpaceGray}
+		case "rg":
+			fill = s.color(colorSpaceRGB, operands)
+		case "RG":
+			stroke = s.color(colorSpaceRGB, operands)
+		case "k":
+			fill = s.color(colorSpaceCMYK, operands)
+		case "K":
+			stroke = s.color(colorSpaceCMYK, operands)
+		case "cs", "CS":
+			if len(operands) > 0 {
+				state := colorState{space: s.colorSpace(resources, types.Name(strings.TrimPrefix(operands[0].value, "/")), 0)}
+				state.uncertain = state.space == colorSpaceOther
+				if tok.value == "cs" {
+					fill = state
+				} else {
+					stroke = state
+				}
+			}
+		case "sc", "scn":
+			fill = s.color(fill.space, operands)
+		case "SC", "SCN":
+			stroke = s.color(stroke.space, operands)
+		}
+		operands = operands[:0]
+	}
+}
+
+// color classifies the operands of a color operator in the given color space
+func (s *contentScanner) color(space colorSpaceKind, operands []contentToken) colorState {
+	state := colorState{space: space}
+
+	var values []float64
+	for _, operand := range operands {
+		v, err := strconv.ParseFloat(operand.value, 64)
+		if err != nil {
+			// Pattern names and other non-numeric operands
+			state.uncertain = true
+			return state
+		}
+		values = append(values, v)
+	}
+
+	const tolerance = 0.01
+	spread := func(v []float64) float64 {
+		lo, hi := v[0], v[0]
+		for _, x := range v[1:] {
+			lo, hi = math.Min(lo, x), math.Max(hi, x)
+		}
+		return hi - lo
+	}
+
+	switch {
+	case space == colorSpaceRGB && len(values) == 3:
+		// Equal components are a neutral gray
+		state.color = spread(values) > tolerance
+	case space == colorSpaceCMYK && len(values) == 4:
+		// Black ink alone or equal C, M and Y give a neutral gray
+		state.color = spread(values[:3]) > tolerance
+	case space == colorSpaceOther:
+		state.uncertain = true
+	}
+	return state
+}
+
+// colorSpace classifies a color space given by name or definition
+func (s *contentScanner) colorSpace(resources types.Dict, obj types.Object, depth int) colorSpaceKind {
+	obj, err := s.ctx.Dereference(obj)
+	if err != nil || obj == nil || depth > 2 {
+		return colorSpaceOther
+	}
+
+	switch o := obj.(type) {
+	case types.Name:
+		switch o {
+		case "DeviceGray", "CalGray", "G":
+			return colorSpaceGray
+		case "DeviceRGB", "CalRGB", "RGB":
+			return colorSpaceRGB
+		case "DeviceCMYK", "CMYK":
+			return colorSpaceCMYK
+		}
+		if resources != nil {
+			if spaces, err := s.ctx.DereferenceDict(resources["ColorSpace"]); err == nil && spaces != nil {
+				if def, found := spaces[string(o)]; found {
+					return s.colorSpace(nil, def, depth+1)
+				}
+			}
+		}
+
+	case types.Array:
+		if len(o) == 0 {
+			return colorSpaceOther
+		}
+		family, _ := o[0].(types.Name)
+		switch family {
+		case "CalGray":
+			return colorSpaceGray
+		case "CalRGB":
+			return colorSpaceRGB
+		case "ICCBased":
+			if len(o) > 1 {
+				if sd, _, err := s.ctx.DereferenceStreamDict(o[1]); err == nil && sd != nil {
+					switch n := sd.IntEntry("N"); {
+					case n != nil && *n == 1:
+						return colorSpaceGray
+					case n != nil && *n == 3:
+						return colorSpaceRGB
+					case n != nil && *n == 4:
+						return colorSpaceCMYK
+					}
+				}
+			}
+		case "Indexed", "I":
+			// A palette on a gray base can only hold grays
+			if len(o) > 1 && s.colorSpace(resources, o[1], depth+1) == colorSpaceGray {
+				return colorSpaceGray
+			}
+		case "Separation":
+			if len(o) > 1 {
+				if name, ok := o[1].(types.Name); ok && (name == "Black" || name == "All" || name == "None") {
+					return colorSpaceGray
+				}
+			}
+		}
+	}
+
+	return colorSpaceOther
+}
+
+// inlineImage checks the dictionary of an inline image, given as the operands of ID
+func (s *contentScanner) inlineImage(operands []contentToken, resources types.Dict, fill colorState) {
+	space := types.Object(nil)
+	for i := 0; i+1 < len(operands); i++ {
+		switch operands[i].value {
+		case "/IM", "/ImageMask":
+			if operands[i+1].value == "true" {
+				// Stencil masks paint with the current fill color
+				s.info.Color = s.info.Color || fill.color
+				s.info.ColorUncertain = s.info.ColorUncertain || fill.uncertain
+				return
+			}
+		case "/CS", "/ColorSpace":
+			space = types.Name(strings.TrimPrefix(operands[i+1].value, "/"))
+		}
+	}
+
+	s.info.Images = true
+	if space == nil || s.colorSpace(resources, space, 0) != colorSpaceGray {
+		s.info.ColorUncertain = true
+	}
+}
+
+// xobject follows a Do operator. Images count as painted, forms are scanned.
+func (s *contentScanner) xobject(resources types.Dict, name string, depth int, fill colorState) {
+	var sd *types.StreamDict
+	if resources != nil {
+		if xobjects, err := s.ctx.DereferenceDict(resources["XObject"]); err == nil && xobjects != nil {
+			sd, _, _ = s.ctx.DereferenceStreamDict(xobjects[strings.TrimPrefix(name, "/")])
+		}
+	}
+
+	// Anything that cannot be inspected is assumed to draw something in color
+	if sd == nil || depth >= maxFormDepth {
+		s.info.Painted = true
+		s.info.Images = true
+		s.info.ColorUncertain = true
+		return
+	}
+
+	if subtype := sd.Subtype(); subtype == nil || *subtype != "Form" {
+		s.info.Painted = true
+		if mask := sd.BooleanEntry("ImageMask"); mask != nil && *mask {
+			s.info.Color = s.info.Color || fill.color
+			s.info.ColorUncertain = s.info.ColorUncertain || fill.uncertain
+			return
+		}
+		s.info.Images = true
+		if s.colorSpace(resources, sd.Dict["ColorSpace"], 0) != colorSpaceGray {
+			s.info.ColorUncertain = true
+		}
+		return
+	}
+
+	if err := sd.Decode(); err != nil {
+		s.info.Painted = true
+		s.info.ColorUncertain = true
+		return
+	}
+
+	formResources := resources
+	if obj, found := sd.Find("Resources"); found {
+		if d, err := s.ctx.DereferenceDict(obj); err == nil && d != nil {
+			formResources = d
+		}
+	}
+
+	s.scan(sd.Content, formResources, depth+1)
+}
+
+func decodeWinAnsi(s string) string {
+	decoded, err := charmap.Windows1252.NewDecoder().String(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
+
+type contentTokenKind int
+
+const (
+	tokenOperator contentTokenKind = iota
+	tokenString
+	tokenOperand
+)
+
+// contentToken is a lexical token of a content stream. String values hold
+// the decoded bytes of literal and hex strings, end is the offset after the
+// token, after the data of inline images for ID.
+type contentToken struct {
+	kind  contentTokenKind
+	value string
+	end   int
+}
+
+func isContentWhitespace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isContentDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// tokenizeContent splits a content stream into operators and operands.
+// Inline image data is skipped.
+func tokenizeContent(content []byte) []contentToken {
+	var tokens []contentToken
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case isContentWhitespace(c):
+			i++
+
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+
+		case c == '(':
+			s, next := readLiteralString(content, i+1)
+			tokens = append(tokens, contentToken{tokenString, s, next})
+			i = next
+
+		case c == '<' && i+1 < len(content) && content[i+1] == '<',
+			c == '>' && i+1 < len(content) && content[i+1] == '>':
+			tokens = append(tokens, contentToken{tokenOperand, string(content[i : i+2]), i + 2})
+			i += 2
+
+		case c == '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				end = len(content) - i
+			}
+			tokens = append(tokens, contentToken{tokenString, decodeHexString(content[i+1 : i+end]), min(i+end+1, len(content))})
+			i += end + 1
+
+		case c == '[' || c == ']' || c == '{' || c == '}' || c == ')' || c == '>':
+			tokens = append(tokens, contentToken{tokenOperand, string(c), i + 1})
+			i++
+
+		default:
+			start := i
+			i++
+			for i < len(content) && !isContentWhitespace(content[i]) && !isContentDelimiter(content[i]) {
+				i++
+			}
+			word := string(content[start:i])
+
+			if c == '/' || c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9') {
+				tokens = append(tokens, contentToken{tokenOperand, word, i})
+				continue
+			}
+
+			if word == "true" || word == "false" || word == "null" {
+				tokens = append(tokens, contentToken{tokenOperand, word, i})
+				continue
+			}
+
+			if word == "ID" {
+				i = skipInlineImage(content, i)
+			}
+			tokens = append(tokens, contentToken{tokenOperator, word, i})
+		}
+	}
+
+	return tokens
+}
+
+// readLiteralString decodes a literal string starting after its opening
+// parenthesis and returns the position after the closing one
+func readLiteralString(content []byte, i int) (string, int) {
+	var b strings.Builder
+	depth := 1
+
+	for i < len(content) {
+		c := content[i]
+		i++
+
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return b.String(), i
+			}
+		case '\\':
+			if i >= len(content) {
+				return b.String(), i
+			}
+			e := content[i]
+			i++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// Line continuation
+				if e == '\r' && i < len(content) && content[i] == '\n' {
+					i++
+				}
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for n := 0; n < 2 && i < len(content) && content[i] >= '0' && content[i] <= '7'; n++ {
+						v = v*8 + int(content[i]-'0')
+						i++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String(), i
+}
+
+func decodeHexString(hex []byte) string {
+	var digits []byte
+	for _, c := range hex {
+		if !isContentWhitespace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+
+	out := make([]byte, 0, len(digits)/2)
+	for i := 0; i < len(digits); i += 2 {
+		var v byte
+		if _, err := fmt.Sscanf(string(digits[i:i+2]), "%02x", &v); err != nil {
+			break
+		}
+		out = append(out, v)
+	}
+	return string(out)
+}
+
+// skipInlineImage returns the position after the EI operator that ends the
+// binary data of an inline image
+func skipInlineImage(content []byte, i int) int {
+	for j := i + 1; j+2 <= len(content); j++ {
+		if content[j] == 'E' && content[j+1] == 'I' && isContentWhitespace(content[j-1]) &&
+			(j+2 == len(content) || isContentWhitespace(content[j+2])) {
+			return j + 2
+		}
+	}
+	return len(content)
+}
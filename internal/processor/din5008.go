@@ -0,0 +1,18 @@
+package processor
+
+// DIN 5008 Form B letter geometry in mm, measured from the top left corner
+// of the A4 page as used by LetterXpress for window envelopes
+const (
+	AddressWindowLeftMM   = 20.0
+	AddressWindowTopMM    = 45.0
+	AddressWindowWidthMM  = 85.0
+	AddressWindowHeightMM = 45.0
+
+	// Return address line at the top of the address field
+	ReturnAddressHeightMM = 5.0
+
+	// Fold marks for DL envelopes and the punch mark in the middle
+	FoldMarkTopMM    = 105.0
+	FoldMarkBottomMM = 210.0
+	PunchMarkMM      = 148.5
+)
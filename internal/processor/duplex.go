@@ -0,0 +1,253 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Raster blank page detection renders pages at blankPageDPI and treats a page
+// as blank when at least blankPageWhiteShare of its pixels are near white.
+// This tolerates dust and scanner noise on otherwise empty scans.
+const (
+	blankPageDPI        = 30
+	blankPageWhiteShare = 0.999
+)
+
+// duplexPlan lists the page changes of the duplex and blank page handling
+type duplexPlan struct {
+	// remove are input pages that are dropped
+	remove []int
+
+	// insertBefore are pages, numbered after the removal, that get a blank page in front
+	insertBefore []int
+
+	// padEnd appends a blank page to reach an even page count
+	padEnd bool
+
+	// pageCount is the final number of pages
+	pageCount int
+}
+
+func (plan *duplexPlan) empty() bool {
+	return len(plan.remove) == 0 && len(plan.insertBefore) == 0 && !plan.padEnd
+}
+
+// planDuplex decides which pages are removed and where blank pages are
+// inserted, and records the decisions on result
+func (p *PDFProcessor) planDuplex(ctx *model.Context, inputFile string, opts Options, result *Result) (*duplexPlan, error) {
+	plan := &duplexPlan{pageCount: ctx.PageCount}
+	if !opts.Duplex && !opts.RemoveBlankPages {
+		return plan, nil
+	}
+	result.Duplex = opts.Duplex
+
+	blank, err := p.findBlankPages(ctx, inputFile, opts.RasterBlankDetection, result)
+	if err != nil {
+		return nil, err
+	}
+	result.BlankPages = blank
+
+	if opts.RemoveBlankPages && len(blank) > 0 {
+		if len(blank) == ctx.PageCount {
+			result.warn("all %d pages are blank, keeping them", len(blank))
+		} else {
+			plan.remove = blank
+		}
+	}
+	result.RemovedPages = plan.remove
+
+	// Number every input page after the removal. A removed page maps to the
+	// page that follows it, so a section starting on it starts there instead.
+	removed := make(map[int]bool, len(plan.remove))
+	for _, page := range plan.remove {
+		removed[page] = true
+	}
+	newNumber := make(map[int]int, ctx.PageCount)
+	remaining := 0
+	for page := 1; page <= ctx.PageCount; page++ {
+		if !removed[page] {
+			remaining++
+			newNumber[page] = remaining
+		} else {
+			newNumber[page] = remaining + 1
+		}
+	}
+	plan.pageCount = remaining
+
+	if opts.Duplex {
+		starts := append([]int(nil), opts.SectionStarts...)
+		sort.Ints(starts)
+
+		seen := make(map[int]bool)
+		for _, start := range starts {
+			page, ok := newNumber[start]
+			if !ok || page > remaining || seen[page] {
+				continue
+			}
+			seen[page] = true
+
+			// Even output pages are back sides
+			if (page+len(plan.insertBefore))%2 == 0 {
+				result.InsertedPages = append(result.InsertedPages, page+len(plan.insertBefore))
+				plan.insertBefore = append(plan.insertBefore, page)
+			}
+		}
+		plan.pageCount += len(plan.insertBefore)
+
+		if plan.pageCount%2 == 1 {
+			plan.padEnd = true
+			plan.pageCount++
+			result.InsertedPages = append(result.InsertedPages, plan.pageCount)
+		}
+	}
+
+	result.PageCount = plan.pageCount
+
+	logrus.WithFields(logrus.Fields{
+		"blank":    blank,
+		"removed":  result.RemovedPages,
+		"inserted": result.InsertedPages,
+		"pages":    plan.pageCount,
+	}).Debug("Planned duplex page handling")
+
+	return plan, nil
+}
+
+// applyDuplex removes blank pages from and inserts blank back sides into the
+// converted output
+func (p *PDFProcessor) applyDuplex(ctx *model.Context, inputFile, outputFile string, opts Options, result *Result) error {
+	plan, err := p.planDuplex(ctx, inputFile, opts, result)
+	if err != nil {
+		return err
+	}
+	if plan.empty() {
+		return nil
+	}
+
+	outCtx, err := p.readContextFile(outputFile)
+	if err != nil {
+		return err
+	}
+
+	if len(plan.remove) > 0 {
+		removed := make(map[int]bool, len(plan.remove))
+		for _, page := range plan.remove {
+			removed[page] = true
+		}
+		var keep []int
+		for page := 1; page <= outCtx.PageCount; page++ {
+			if !removed[page] {
+				keep = append(keep, page)
+			}
+		}
+
+		outCtx, err = pdfcpu.ExtractPages(outCtx, keep, false)
+		if err != nil {
+			return fmt.Errorf("failed to remove blank pages: %w", err)
+		}
+		// ExtractPages does not count the pages of the new context
+		outCtx.PageCount = len(keep)
+		logrus.WithField("pages", plan.remove).Info("Removed blank pages")
+	}
+
+	a4 := &types.Dim{Width: A4WidthPoints, Height: A4HeightPoints}
+	pageCount := outCtx.PageCount
+	if len(plan.insertBefore) > 0 {
+		pages := types.IntSet{}
+		for _, page := range plan.insertBefore {
+			pages[page] = true
+		}
+		if err := outCtx.InsertBlankPages(pages, a4, true); err != nil {
+			return fmt.Errorf("failed to insert blank pages: %w", err)
+		}
+		pageCount += len(plan.insertBefore)
+	}
+
+	if plan.padEnd {
+		if err := outCtx.InsertBlankPages(types.IntSet{pageCount: true}, a4, false); err != nil {
+			return fmt.Errorf("failed to pad to an even page count: %w", err)
+		}
+	}
+
+	tempFile := outputFile + ".tmp"
+	if err := api.WriteContextFile(outCtx, tempFile); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("%w: failed to write duplex output: %w", ErrOutputNotWritable, err)
+	}
+	if err := os.Rename(tempFile, outputFile); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("%w: failed to replace output: %w", ErrOutputNotWritable, err)
+	}
+
+	return p.verifyOutput(outputFile, plan.pageCount)
+}
+
+// findBlankPages returns the input pages that draw nothing, or with raster
+// set, render almost completely white
+func (p *PDFProcessor) findBlankPages(ctx *model.Context, inputFile string, raster bool, result *Result) ([]int, error) {
+	if raster {
+		if _, err := exec.LookPath("convert"); err != nil {
+			result.warn("raster blank page detection unavailable: ImageMagick not found")
+			raster = false
+		}
+	}
+
+	var blank []int
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		content, err := p.analyzePage(ctx, pageNr)
+		if err != nil {
+			return nil, err
+		}
+
+		isBlank := !content.Painted
+		if !isBlank && raster {
+			isBlank, err = p.isNearWhitePage(inputFile, pageNr)
+			if err != nil {
+				result.warn("raster blank page detection failed on page %d: %v", pageNr, err)
+				raster = false
+			}
+		}
+
+		if isBlank {
+			blank = append(blank, pageNr)
+		}
+	}
+
+	return blank, nil
+}
+
+// isNearWhitePage renders a page and checks the share of near-white pixels
+func (p *PDFProcessor) isNearWhitePage(inputFile string, pageNr int) (bool, error) {
+	cmd := exec.Command("convert",
+		"-density", strconv.Itoa(blankPageDPI),
+		fmt.Sprintf("%s[%d]", inputFile, pageNr-1),
+		"-background", "white",
+		"-flatten",
+		"-colorspace", "Gray",
+		"-threshold", "90%",
+		"-format", "%[fx:mean]",
+		"info:",
+	)
+	logrus.WithField("command", strings.Join(cmd.Args, " ")).Debug("Measuring page whiteness")
+
+	output, err := cmd.Output()
+	if err != nil {
+		return false, err
+	}
+
+	white, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return false, fmt.Errorf("unexpected ImageMagick output %q", output)
+	}
+	return white >= blankPageWhiteShare, nil
+}
@@ -0,0 +1,128 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/sirupsen/logrus"
+)
+
+// User access permissions of an encrypted PDF by bit, see PDF 32000-1 table 22
+var permissionNames = []struct {
+	bit  int
+	name string
+}{
+	{0x0004, "print"},
+	{0x0008, "modify"},
+	{0x0010, "copy"},
+	{0x0020, "annotate"},
+	{0x0100, "fill-forms"},
+	{0x0200, "extract-accessibility"},
+	{0x0400, "assemble"},
+	{0x0800, "print-high-quality"},
+}
+
+// Encryption describes the encryption of an input PDF
+type Encryption struct {
+	// Permissions lists the granted user access permissions
+	Permissions []string
+}
+
+// permitted reports whether a named permission is granted
+func (e *Encryption) permitted(name string) bool {
+	for _, permission := range e.Permissions {
+		if permission == name {
+			return true
+		}
+	}
+	return false
+}
+
+// inspectEncryption returns the encryption of inputFile, or nil if it is not
+// encrypted. It fails with ErrPasswordRequired or ErrWrongPassword if the
+// file cannot be opened with the configured password.
+func (p *PDFProcessor) inspectEncryption(inputFile string) (*Encryption, error) {
+	ctx, err := p.readContextFile(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Encrypt == nil || ctx.E == nil {
+		return nil, nil
+	}
+
+	enc := &Encryption{Permissions: []string{}}
+	for _, permission := range permissionNames {
+		if ctx.E.P&permission.bit != 0 {
+			enc.Permissions = append(enc.Permissions, permission.name)
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"input":       inputFile,
+		"permissions": enc.Permissions,
+	}).Info("Input PDF is encrypted")
+	return enc, nil
+}
+
+// openInput checks inputFile for encryption and, if it is encrypted, writes
+// a decrypted copy to dir for the rest of the processing. External tools
+// cannot read encrypted files, and nothing derived from the copy is written
+// encrypted. Image and office inputs are converted into a PDF instead, see
+// openConverted. The returned cleanup function removes the copy.
+func (p *PDFProcessor) openInput(inputFile, dir string, result *Result) (string, func(), error) {
+	noop := func() {}
+
+	if convertedFile, cleanup, err := p.openConverted(inputFile, dir, result); err != nil || convertedFile != inputFile {
+		return convertedFile, cleanup, err
+	}
+
+	enc, err := p.inspectEncryption(inputFile)
+	if err != nil || enc == nil {
+		return inputFile, noop, err
+	}
+
+	recordEncryption(enc, result)
+
+	f, err := os.CreateTemp(dir, ".pdf2letterexpress-decrypted-*.pdf")
+	if err != nil {
+		return inputFile, noop, fmt.Errorf("%w: cannot create decrypted copy: %w", ErrOutputNotWritable, err)
+	}
+	f.Close()
+	decryptedFile := f.Name()
+	cleanup := func() { os.Remove(decryptedFile) }
+
+	// DecryptFile changes the command mode of the configuration
+	conf := *p.config
+	if err := api.DecryptFile(inputFile, decryptedFile, &conf); err != nil {
+		cleanup()
+		return inputFile, noop, fmt.Errorf("%w: failed to decrypt: %w", classifyReadError(err, p.opts.Password), err)
+	}
+
+	logrus.WithField("input", inputFile).Debug("Decrypted input")
+	return decryptedFile, cleanup, nil
+}
+
+// recordEncryption records the encryption of the input on result
+func recordEncryption(enc *Encryption, result *Result) {
+	if enc == nil {
+		return
+	}
+	result.Encrypted = true
+	result.Permissions = enc.Permissions
+	if !enc.permitted("print") {
+		result.warn("the document does not permit printing")
+	}
+}
+
+// ensureNotEncrypted fails if outputFile is encrypted
+func ensureNotEncrypted(outputFile string) error {
+	enc, err := NewPDFProcessor().inspectEncryption(outputFile)
+	if err != nil {
+		return fmt.Errorf("%w: cannot read output: %w", ErrNonCompliant, err)
+	}
+	if enc != nil {
+		return fmt.Errorf("%w: output is encrypted", ErrNonCompliant)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
+)
+
+var (
+	// ErrInvalidPDF is returned when the input cannot be parsed as a PDF
+	ErrInvalidPDF = utils.ErrInvalidPDF
+	// ErrUnreadableInput is returned when an image or office input cannot be read or converted
+	ErrUnreadableInput = utils.ErrUnreadableInput
+	// ErrEncrypted is returned when the input is encrypted and cannot be opened
+	ErrEncrypted = errors.New("PDF is encrypted")
+	// ErrPasswordRequired is returned when the input is encrypted and no password was given
+	ErrPasswordRequired = fmt.Errorf("%w: a password is required", ErrEncrypted)
+	// ErrWrongPassword is returned when the given password does not open the input
+	ErrWrongPassword = fmt.Errorf("%w: the password is wrong", ErrEncrypted)
+	// ErrXFAForm is returned when the input is an XFA form without AcroForm fields
+	ErrXFAForm = fmt.Errorf("%w: XFA forms are not supported, print the form to PDF first", ErrInvalidPDF)
+	// ErrToolMissing is returned when an external tool required by an engine is not installed
+	ErrToolMissing = errors.New("required tool not found")
+	// ErrEngineFailed is returned when an engine or the whole fallback chain failed
+	ErrEngineFailed = errors.New("conversion engine failed")
+	// ErrOutputNotWritable is returned when the output file cannot be created
+	ErrOutputNotWritable = utils.ErrOutputNotWritable
+	// ErrNonCompliant is returned when the output does not meet the LetterXpress requirements
+	ErrNonCompliant = errors.New("output is not LetterXpress compliant")
+	// ErrAuditDamaged is returned when an audit log entry was changed, removed or cannot be read
+	ErrAuditDamaged = errors.New("audit log is damaged")
+	// ErrTooLarge is returned when the output cannot be made to fit the size limit
+	ErrTooLarge = errors.New("output exceeds the size limit")
+)
+
+// Error codes as used in reports and mapped to exit codes by the CLI
+const (
+	CodeOK                = "ok"
+	CodeInputNotFound     = "input_not_found"
+	CodeInvalidPDF        = "invalid_pdf"
+	CodeEncrypted         = "encrypted"
+	CodeToolMissing       = "tool_missing"
+	CodeEngineFailed      = "engine_failed"
+	CodeOutputNotWritable = "output_not_writable"
+	CodeNonCompliant      = "non_compliant"
+	CodeTooLarge          = "too_large"
+	CodeAuditDamaged      = "audit_damaged"
+	CodeUnreadableInput   = "unreadable_input"
+	CodeInternal          = "internal"
+)
+
+// ErrorCode returns the code of the most specific known error in err's chain
+func ErrorCode(err error) string {
+	switch {
+	case err == nil:
+		return CodeOK
+	case errors.Is(err, utils.ErrInputNotFound):
+		return CodeInputNotFound
+	case errors.Is(err, ErrEncrypted):
+		return CodeEncrypted
+	case errors.Is(err, ErrInvalidPDF):
+		return CodeInvalidPDF
+	case errors.Is(err, ErrUnreadableInput):
+		return CodeUnreadableInput
+	case errors.Is(err, ErrOutputNotWritable):
+		return CodeOutputNotWritable
+	case errors.Is(err, ErrNonCompliant):
+		return CodeNonCompliant
+	case errors.Is(err, ErrAuditDamaged):
+		return CodeAuditDamaged
+	case errors.Is(err, ErrTooLarge):
+		return CodeTooLarge
+	case errors.Is(err, ErrToolMissing):
+		return CodeToolMissing
+	case errors.Is(err, ErrEngineFailed):
+		return CodeEngineFailed
+	default:
+		return CodeInternal
+	}
+}
+
+// classifyReadError attaches the matching sentinel to an error from reading
+// a PDF with the given password
+func classifyReadError(err error, password string) error {
+	if errors.Is(err, pdfcpu.ErrWrongPassword) {
+		if password == "" {
+			return ErrPasswordRequired
+		}
+		return ErrWrongPassword
+	}
+	if errors.Is(err, pdfcpu.ErrUnsupportedEncryptionFeature) {
+		return ErrEncrypted
+	}
+	return ErrInvalidPDF
+}
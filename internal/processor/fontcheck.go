@@ -0,0 +1,529 @@
+package processor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/image/font/sfnt"
+)
+
+// Font problems that print services reject or mis-render
+const (
+	FontNotEmbedded = "not_embedded"
+	FontType3       = "type3"
+)
+
+// Ways of fixing font problems
+const (
+	// FixFontsEmbed embeds substitute fonts from the font directory and
+	// rasterizes the pages whose fonts have no substitute
+	FixFontsEmbed = "embed"
+
+	// FixFontsRasterize rasterizes every page with a font problem
+	FixFontsRasterize = "rasterize"
+)
+
+// DefaultFontDir is searched for substitute fonts if no font directory is set
+const DefaultFontDir = "/usr/share/fonts"
+
+// FontIssue is a font of a page that is not embedded or a Type 3 font
+type FontIssue struct {
+	Page    int    `json:"page"`
+	Font    string `json:"font"`
+	Subtype string `json:"subtype"`
+	Problem string `json:"problem"`
+}
+
+func (i FontIssue) String() string {
+	if i.Problem == FontType3 {
+		return fmt.Sprintf("page %d: Type 3 font %s", i.Page, i.Font)
+	}
+	return fmt.Sprintf("page %d: font %s is not embedded", i.Page, i.Font)
+}
+
+// findFontIssues lists the font problems of every page of ctx, including
+// fonts used by form XObjects
+func (p *PDFProcessor) findFontIssues(ctx *model.Context) ([]FontIssue, error) {
+	issues := []FontIssue{}
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		resources, err := pageResources(ctx, pageNr)
+		if err != nil {
+			return nil, err
+		}
+
+		seen := make(map[string]bool)
+		walkFonts(ctx, resources, 0, func(font types.Dict) {
+			issue, ok := fontIssue(ctx, font)
+			if !ok || seen[issue.Font+issue.Problem] {
+				return
+			}
+			seen[issue.Font+issue.Problem] = true
+			issue.Page = pageNr
+			issues = append(issues, issue)
+		})
+	}
+	return issues, nil
+}
+
+// pageResources returns the own or inherited resources of a page
+func pageResources(ctx *model.Context, pageNr int) (types.Dict, error) {
+	pageDict, _, inhPAttrs, err := ctx.PageDict(pageNr, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get page dict: %w", err)
+	}
+	if obj, found := pageDict.Find("Resources"); found {
+		resources, _ := ctx.DereferenceDict(obj)
+		return resources, nil
+	}
+	if inhPAttrs != nil {
+		return inhPAttrs.Resources, nil
+	}
+	return nil, nil
+}
+
+// walkFonts calls fn for every font dictionary of resources and of the
+// form XObjects they contain
+func walkFonts(ctx *model.Context, resources types.Dict, depth int, fn func(font types.Dict)) {
+	if resources == nil || depth >= maxFormDepth {
+		return
+	}
+
+	if fonts, err := ctx.DereferenceDict(resources["Font"]); err == nil && fonts != nil {
+		names := make([]string, 0, len(fonts))
+		for name := range fonts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if font, err := ctx.DereferenceDict(fonts[name]); err == nil && font != nil {
+				fn(font)
+			}
+		}
+	}
+
+	xobjects, err := ctx.DereferenceDict(resources["XObject"])
+	if err != nil || xobjects == nil {
+		return
+	}
+	for _, obj := range xobjects {
+		sd, _, err := ctx.DereferenceStreamDict(obj)
+		if err != nil || sd == nil {
+			continue
+		}
+		if subtype := sd.Subtype(); subtype == nil || *subtype != "Form" {
+			continue
+		}
+		if formResources, err := ctx.DereferenceDict(sd.Dict["Resources"]); err == nil {
+			walkFonts(ctx, formResources, depth+1, fn)
+		}
+	}
+}
+
+// fontIssue checks a font dictionary and reports whether it has a problem
+func fontIssue(ctx *model.Context, font types.Dict) (FontIssue, bool) {
+	issue := FontIssue{Font: "unnamed"}
+	if name := font.NameEntry("BaseFont"); name != nil {
+		issue.Font = *name
+	}
+	if subtype := font.NameEntry("Subtype"); subtype != nil {
+		issue.Subtype = *subtype
+	}
+
+	switch issue.Subtype {
+	case "Type3":
+		issue.Problem = FontType3
+		return issue, true
+	case "Type0":
+		// The font program belongs to the descendant CID font
+		descendants, err := ctx.DereferenceArray(font["DescendantFonts"])
+		if err != nil || len(descendants) == 0 {
+			issue.Problem = FontNotEmbedded
+			return issue, true
+		}
+		if font, err = ctx.DereferenceDict(descendants[0]); err != nil || font == nil {
+			issue.Problem = FontNotEmbedded
+			return issue, true
+		}
+	}
+
+	descriptor, err := ctx.DereferenceDict(font["FontDescriptor"])
+	if err == nil && descriptor != nil {
+		for _, key := range []string{"FontFile", "FontFile2", "FontFile3"} {
+			if _, found := descriptor.Find(key); found {
+				return issue, false
+			}
+		}
+	}
+
+	issue.Problem = FontNotEmbedded
+	return issue, true
+}
+
+// checkFonts records the font problems of the output and, if requested,
+// fixes them by embedding substitute fonts or rasterizing the affected pages
+func (p *PDFProcessor) checkFonts(outputFile string, opts Options, result *Result) error {
+	ctx, err := p.readContextFile(outputFile)
+	if err != nil {
+		return err
+	}
+	issues, err := p.findFontIssues(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(issues) > 0 && opts.FixFonts == FixFontsEmbed {
+		embedded, err := p.embedSubstituteFonts(ctx, opts.FontDir)
+		if err != nil {
+			return err
+		}
+		if len(embedded) > 0 {
+			if err := writeContextInPlace(ctx, outputFile); err != nil {
+				return err
+			}
+			result.EmbeddedFonts = embedded
+			if issues, err = p.findFontIssues(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(issues) > 0 && opts.FixFonts != "" {
+		pages := issuePages(issues)
+		if err := p.rasterizePages(outputFile, pages, opts.Raster); err != nil {
+			return err
+		}
+		result.RasterizedPages = pages
+		issues = nil
+	}
+
+	result.FontIssues = issues
+	for _, issue := range issues {
+		result.warn("%s", issue)
+	}
+	return nil
+}
+
+// issuePages returns the pages of issues in ascending order without duplicates
+func issuePages(issues []FontIssue) []int {
+	var pages []int
+	seen := make(map[int]bool)
+	for _, issue := range issues {
+		if !seen[issue.Page] {
+			seen[issue.Page] = true
+			pages = append(pages, issue.Page)
+		}
+	}
+	sort.Ints(pages)
+	return pages
+}
+
+// embedSubstituteFonts embeds a TrueType font from fontDir into every simple
+// font that is not embedded and has a substitute. The glyph widths of the
+// document are kept so that the layout does not change. It returns the
+// substitutions made, e.g. "Helvetica → LiberationSans".
+func (p *PDFProcessor) embedSubstituteFonts(ctx *model.Context, fontDir string) ([]string, error) {
+	if fontDir == "" {
+		fontDir = DefaultFontDir
+	}
+	index, err := loadFontIndex(fontDir)
+	if err != nil {
+		return nil, err
+	}
+
+	type substitute struct {
+		font       *TrueTypeFont
+		descriptor *types.IndirectRef
+	}
+	substitutes := make(map[string]*substitute)
+
+	var embedded []string
+	var embedErr error
+	for pageNr := 1; pageNr <= ctx.PageCount && embedErr == nil; pageNr++ {
+		resources, err := pageResources(ctx, pageNr)
+		if err != nil {
+			return nil, err
+		}
+
+		walkFonts(ctx, resources, 0, func(font types.Dict) {
+			issue, ok := fontIssue(ctx, font)
+			if embedErr != nil || !ok || issue.Problem != FontNotEmbedded || !substitutable(font) {
+				return
+			}
+			path := index.lookup(issue.Font)
+			if path == "" {
+				return
+			}
+
+			sub, ok := substitutes[path]
+			if !ok {
+				ttf, err := LoadTrueTypeFont(path)
+				if err != nil {
+					logrus.WithError(err).WithField("font", path).Warn("Cannot load substitute font")
+					return
+				}
+				descriptor, err := ttf.embedDescriptor(ctx)
+				if err != nil {
+					embedErr = err
+					return
+				}
+				sub = &substitute{font: ttf, descriptor: descriptor}
+				substitutes[path] = sub
+			}
+
+			font["Subtype"] = types.Name("TrueType")
+			font["BaseFont"] = types.Name(sub.font.Name)
+			font["FontDescriptor"] = *sub.descriptor
+			if _, found := font.Find("Widths"); !found {
+				font["FirstChar"] = types.Integer(32)
+				font["LastChar"] = types.Integer(255)
+				font["Widths"] = sub.font.widthsArray()
+			}
+			if _, found := font.Find("Encoding"); !found {
+				font["Encoding"] = types.Name("WinAnsiEncoding")
+			}
+
+			embedded = append(embedded, fmt.Sprintf("%s → %s", issue.Font, sub.font.Name))
+			logrus.WithFields(logrus.Fields{
+				"font":       issue.Font,
+				"substitute": path,
+			}).Info("Embedded substitute font")
+		})
+	}
+	if embedErr != nil {
+		return nil, fmt.Errorf("failed to embed substitute font: %w", embedErr)
+	}
+
+	return embedded, nil
+}
+
+// substitutable reports whether a font can take a TrueType substitute with
+// WinAnsi encoding: simple Latin fonts without custom encodings
+func substitutable(font types.Dict) bool {
+	subtype := font.NameEntry("Subtype")
+	if subtype == nil || (*subtype != "Type1" && *subtype != "TrueType" && *subtype != "MMType1") {
+		return false
+	}
+	if name := font.NameEntry("BaseFont"); name == nil || strings.HasPrefix(*name, "Symbol") || strings.HasPrefix(*name, "ZapfDingbats") {
+		return false
+	}
+	if obj, found := font.Find("Encoding"); found {
+		name, ok := obj.(types.Name)
+		return ok && (name == "WinAnsiEncoding" || name == "StandardEncoding")
+	}
+	return true
+}
+
+// Families of metric-compatible substitutes for the standard PDF fonts
+var fontFamilies = map[string][]string{
+	"helvetica": {"helvetica", "arial", "liberationsans", "nimbussans", "freesans"},
+	"arial":     {"arial", "helvetica", "liberationsans", "nimbussans", "freesans"},
+	"times":     {"times", "timesnewroman", "liberationserif", "nimbusroman", "freeserif"},
+	"courier":   {"courier", "couriernew", "liberationmono", "nimbusmono", "freemono"},
+}
+
+// fontIndex maps normalized family and style names to TrueType font files
+type fontIndex map[string]string
+
+// loadFontIndex indexes the TrueType fonts below dir by their PostScript names
+func loadFontIndex(dir string) (fontIndex, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("cannot read font directory: %w", err)
+	}
+
+	index := fontIndex{}
+	var buf sfnt.Buffer
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".ttf") {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		f, err := sfnt.Parse(data)
+		if err != nil {
+			return nil
+		}
+		name, err := f.Name(&buf, sfnt.NameIDPostScript)
+		if err != nil || name == "" {
+			name = strings.TrimSuffix(d.Name(), filepath.Ext(d.Name()))
+		}
+		family, style := splitFontName(name)
+		if _, found := index[family+"/"+style]; !found {
+			index[family+"/"+style] = path
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read font directory: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{"dir": dir, "fonts": len(index)}).Debug("Indexed substitute fonts")
+	return index, nil
+}
+
+// lookup returns the font file for a PDF font name, trying metric-compatible
+// families for the standard fonts
+func (index fontIndex) lookup(baseFont string) string {
+	family, style := splitFontName(baseFont)
+
+	candidates := []string{family}
+	for key, families := range fontFamilies {
+		if family == key {
+			candidates = append(candidates, families...)
+		}
+	}
+	for _, candidate := range candidates {
+		if path, found := index[candidate+"/"+style]; found {
+			return path
+		}
+	}
+	return ""
+}
+
+// splitFontName splits a font name such as "ABCDEF+Arial,BoldItalic" or
+// "TimesNewRomanPS-BoldMT" into a normalized family and style
+func splitFontName(name string) (family, style string) {
+	if i := strings.IndexByte(name, '+'); i == 6 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexAny(name, "-,"); i >= 0 {
+		family, style = name[:i], name[i+1:]
+	} else {
+		family = name
+	}
+
+	family = normalizeFontName(family)
+	for _, suffix := range []string{"psmt", "mt", "ps"} {
+		family = strings.TrimSuffix(family, suffix)
+	}
+
+	style = strings.TrimSuffix(normalizeFontName(style), "mt")
+	style = strings.ReplaceAll(style, "oblique", "italic")
+	switch style {
+	case "regular", "roman", "book", "normal":
+		style = ""
+	}
+	return family, style
+}
+
+func normalizeFontName(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// rasterizePages replaces the given pages of outputFile by rendered images
+// and keeps all other pages as they are
+func (p *PDFProcessor) rasterizePages(outputFile string, pages []int, raster RasterOptions) error {
+	if _, err := exec.LookPath("convert"); err != nil {
+		return fmt.Errorf("%w: rasterizing pages needs ImageMagick: %w", ErrToolMissing, err)
+	}
+	raster = raster.withDefaults()
+
+	pageCount, err := api.PageCountFile(outputFile)
+	if err != nil {
+		return fmt.Errorf("%w: cannot read output: %w", ErrInvalidPDF, err)
+	}
+
+	rasterize := make(map[int]bool, len(pages))
+	for _, page := range pages {
+		rasterize[page] = true
+	}
+
+	tempDir := filepath.Dir(outputFile)
+	var pageFiles []string
+	defer func() { p.cleanupFiles(pageFiles) }()
+
+	for page := 1; page <= pageCount; page++ {
+		pageFile := filepath.Join(tempDir, fmt.Sprintf("temp_font_page_%d.pdf", page))
+		pageFiles = append(pageFiles, pageFile)
+
+		if !rasterize[page] {
+			if err := api.TrimFile(outputFile, pageFile, []string{strconv.Itoa(page)}, p.config); err != nil {
+				return fmt.Errorf("failed to extract page %d: %w", page, err)
+			}
+			continue
+		}
+
+		args := []string{
+			"-density", strconv.Itoa(raster.DPI),
+			fmt.Sprintf("%s[%d]", outputFile, page-1),
+			"-background", "white",
+			"-flatten",
+		}
+		args = append(args, rasterPageArgs(ColorModeColor, raster)...)
+		args = append(args, "-define", "pdf:page-size=a4", pageFile)
+		cmd := exec.Command("convert", args...)
+		logrus.WithField("command", strings.Join(cmd.Args, " ")).Debug("Rasterizing page")
+
+		if output, err := cmd.CombinedOutput(); err != nil {
+			logrus.WithError(err).WithField("output", string(output)).Error("Page rasterization failed")
+			return fmt.Errorf("%w: rasterizing page %d failed: %w", ErrEngineFailed, page, err)
+		}
+	}
+
+	tempFile := outputFile + ".tmp"
+	if err := api.MergeCreateFile(pageFiles, tempFile, false, p.config); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to combine pages: %w", err)
+	}
+	if err := os.Rename(tempFile, outputFile); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("%w: failed to replace output: %w", ErrOutputNotWritable, err)
+	}
+
+	logrus.WithField("pages", pages).Debug("Rasterized pages")
+	return nil
+}
+
+// writeContextInPlace writes ctx over outputFile through a temporary file
+func writeContextInPlace(ctx *model.Context, outputFile string) error {
+	if err := decodeObjectStreams(ctx); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPDF, err)
+	}
+
+	tempFile := outputFile + ".tmp"
+	if err := api.WriteContextFile(ctx, tempFile); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("%w: failed to write output: %w", ErrOutputNotWritable, err)
+	}
+	if err := os.Rename(tempFile, outputFile); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("%w: failed to replace output: %w", ErrOutputNotWritable, err)
+	}
+	return nil
+}
+
+// decodeObjectStreams decodes all objects of object streams that have not
+// been dereferenced yet. pdfcpu writes them as they are, without the objects
+// they refer to.
+func decodeObjectStreams(ctx *model.Context) error {
+	for objNr, entry := range ctx.Table {
+		if _, ok := entry.Object.(types.LazyObjectStreamObject); !ok {
+			continue
+		}
+		genNr := 0
+		if entry.Generation != nil {
+			genNr = *entry.Generation
+		}
+		if _, err := ctx.Dereference(*types.NewIndirectRef(objNr, genNr)); err != nil {
+			return fmt.Errorf("failed to decode object %d: %w", objNr, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// TrueTypeFont is a TrueType font that can be embedded into a PDF with
+// WinAnsi encoding, which covers German umlauts and the Euro sign
+type TrueTypeFont struct {
+	Name string
+
+	data      []byte
+	widths    [256]int
+	bbox      [4]int
+	ascent    int
+	descent   int
+	capHeight int
+}
+
+// LoadTrueTypeFont loads a TrueType font file. An empty path selects the
+// built-in Go Regular font so that letters can be produced without any
+// fonts installed on the system.
+func LoadTrueTypeFont(path string) (*TrueTypeFont, error) {
+	data := goregular.TTF
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read font: %w", err)
+		}
+	}
+	return parseTrueTypeFont(data)
+}
+
+func parseTrueTypeFont(data []byte) (*TrueTypeFont, error) {
+	f, err := sfnt.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse font: %w", err)
+	}
+
+	var buf sfnt.Buffer
+	// Measure everything in PDF glyph space, i.e. 1/1000 em
+	ppem := fixed.I(1000)
+
+	name, err := f.Name(&buf, sfnt.NameIDPostScript)
+	if err != nil || name == "" {
+		name = "EmbeddedFont"
+	}
+
+	ttf := &TrueTypeFont{
+		Name: strings.ReplaceAll(name, " ", ""),
+		data: data,
+	}
+
+	if metrics, err := f.Metrics(&buf, ppem, font.HintingNone); err == nil {
+		ttf.ascent = metrics.Ascent.Round()
+		ttf.descent = -metrics.Descent.Round()
+		ttf.capHeight = metrics.CapHeight.Round()
+	}
+
+	if bounds, err := f.Bounds(&buf, ppem, font.HintingNone); err == nil {
+		// sfnt uses a y-down coordinate system
+		ttf.bbox = [4]int{bounds.Min.X.Round(), -bounds.Max.Y.Round(), bounds.Max.X.Round(), -bounds.Min.Y.Round()}
+	}
+
+	for code := 32; code < 256; code++ {
+		r := charmap.Windows1252.DecodeByte(byte(code))
+		glyph, err := f.GlyphIndex(&buf, r)
+		if err != nil || glyph == 0 {
+			continue
+		}
+		advance, err := f.GlyphAdvance(&buf, glyph, ppem, font.HintingNone)
+		if err != nil {
+			continue
+		}
+		ttf.widths[code] = advance.Round()
+	}
+
+	return ttf, nil
+}
+
+// Encode converts s to WinAnsi bytes escaped for a PDF literal string.
+// Characters outside WinAnsi are replaced with '?'.
+func (f *TrueTypeFont) Encode(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		c, ok := charmap.Windows1252.EncodeRune(r)
+		if !ok {
+			c = '?'
+		}
+		switch c {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			if c < 32 || c > 126 {
+				fmt.Fprintf(&b, "\\%03o", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+// TextWidth returns the width of s in points at the given font size
+func (f *TrueTypeFont) TextWidth(s string, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		c, ok := charmap.Windows1252.EncodeRune(r)
+		if !ok {
+			c = '?'
+		}
+		total += f.widths[c]
+	}
+	return float64(total) * size / 1000
+}
+
+// Embed adds the font with its font program to ctx and returns the font dictionary
+func (f *TrueTypeFont) Embed(ctx *model.Context) (*types.IndirectRef, error) {
+	descriptor, err := f.embedDescriptor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.IndRefForNewObject(types.Dict{
+		"Type":           types.Name("Font"),
+		"Subtype":        types.Name("TrueType"),
+		"BaseFont":       types.Name(f.Name),
+		"Encoding":       types.Name("WinAnsiEncoding"),
+		"FirstChar":      types.Integer(32),
+		"LastChar":       types.Integer(255),
+		"Widths":         f.widthsArray(),
+		"FontDescriptor": *descriptor,
+	})
+}
+
+// widthsArray returns the glyph widths of the WinAnsi codes 32 to 255
+func (f *TrueTypeFont) widthsArray() types.Array {
+	widths := types.Array{}
+	for code := 32; code < 256; code++ {
+		widths = append(widths, types.Integer(f.widths[code]))
+	}
+	return widths
+}
+
+// embedDescriptor adds the font program and its font descriptor to ctx
+func (f *TrueTypeFont) embedDescriptor(ctx *model.Context) (*types.IndirectRef, error) {
+	sd, err := ctx.NewStreamDictForBuf(f.data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create font stream: %w", err)
+	}
+	sd.InsertInt("Length1", len(f.data))
+	if err := sd.Encode(); err != nil {
+		return nil, fmt.Errorf("failed to encode font stream: %w", err)
+	}
+	fontFile, err := ctx.IndRefForNewObject(*sd)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.IndRefForNewObject(types.Dict{
+		"Type":        types.Name("FontDescriptor"),
+		"FontName":    types.Name(f.Name),
+		"Flags":       types.Integer(32), // Nonsymbolic
+		"FontBBox":    types.NewIntegerArray(f.bbox[0], f.bbox[1], f.bbox[2], f.bbox[3]),
+		"ItalicAngle": types.Integer(0),
+		"Ascent":      types.Integer(f.ascent),
+		"Descent":     types.Integer(f.descent),
+		"CapHeight":   types.Integer(f.capHeight),
+		"StemV":       types.Integer(80),
+		"FontFile2":   *fontFile,
+	})
+}
@@ -0,0 +1,105 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// sRGBOutputCondition identifies the color space of srgbProfile in output intents
+const sRGBOutputCondition = "sRGB IEC61966-2.1"
+
+// srgbProfile returns an ICC version 2 display profile of the sRGB color
+// space. It is generated rather than read from the system so that archival
+// copies do not depend on installed color management packages.
+func srgbProfile() []byte {
+	// Tone response curve of IEC 61966-2-1, shared by all three channels
+	trc := make([]uint16, 1024)
+	for i := range trc {
+		c := float64(i) / float64(len(trc)-1)
+		if c <= 0.04045 {
+			c /= 12.92
+		} else {
+			c = math.Pow((c+0.055)/1.055, 2.4)
+		}
+		trc[i] = uint16(math.Round(c * 65535))
+	}
+
+	curve := iccTag("curv", func(b *bytes.Buffer) {
+		binary.Write(b, binary.BigEndian, uint32(len(trc)))
+		binary.Write(b, binary.BigEndian, trc)
+	})
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", iccTag("desc", func(b *bytes.Buffer) {
+			// textDescriptionType: ASCII, empty Unicode and ScriptCode descriptions
+			binary.Write(b, binary.BigEndian, uint32(len(sRGBOutputCondition)+1))
+			b.WriteString(sRGBOutputCondition + "\x00")
+			b.Write(make([]byte, 4+4+2+1+67))
+		})},
+		{"cprt", iccTag("text", func(b *bytes.Buffer) { b.WriteString("No copyright, use freely\x00") })},
+		{"wtpt", iccXYZ(0.9505, 1.0, 1.0891)},
+		{"rXYZ", iccXYZ(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", iccXYZ(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", iccXYZ(0.1431, 0.0606, 0.7141)},
+		{"rTRC", curve},
+		{"gTRC", curve},
+		{"bTRC", curve},
+	}
+
+	// Tag data follows the header and the tag table, curves are stored once
+	var data bytes.Buffer
+	table := new(bytes.Buffer)
+	binary.Write(table, binary.BigEndian, uint32(len(tags)))
+	offsets := make(map[*byte]uint32)
+	dataStart := uint32(128 + 4 + 12*len(tags))
+	for _, tag := range tags {
+		offset, ok := offsets[&tag.data[0]]
+		if !ok {
+			offset = dataStart + uint32(data.Len())
+			offsets[&tag.data[0]] = offset
+			data.Write(tag.data)
+			for data.Len()%4 != 0 {
+				data.WriteByte(0)
+			}
+		}
+		table.WriteString(tag.sig)
+		binary.Write(table, binary.BigEndian, offset)
+		binary.Write(table, binary.BigEndian, uint32(len(tag.data)))
+	}
+
+	size := dataStart + uint32(data.Len())
+	header := new(bytes.Buffer)
+	binary.Write(header, binary.BigEndian, size)
+	header.Write(make([]byte, 4))                              // preferred CMM
+	binary.Write(header, binary.BigEndian, uint32(0x02100000)) // version 2.1
+	header.WriteString("mntrRGB XYZ ")
+	binary.Write(header, binary.BigEndian, [6]uint16{2024, 1, 1, 0, 0, 0})
+	header.WriteString("acsp")
+	header.Write(make([]byte, 4+4+4+4+8+4)) // platform, flags, device, attributes, intent
+	header.Write(iccXYZ(0.9642, 1.0, 0.8249)[8:])
+	header.Write(make([]byte, 128-header.Len()))
+
+	return append(append(header.Bytes(), table.Bytes()...), data.Bytes()...)
+}
+
+// iccTag returns the data of a tag of the given type written by fn
+func iccTag(typ string, fn func(b *bytes.Buffer)) []byte {
+	var b bytes.Buffer
+	b.WriteString(typ)
+	b.Write(make([]byte, 4))
+	fn(&b)
+	return b.Bytes()
+}
+
+// iccXYZ returns an XYZType tag of a single color
+func iccXYZ(x, y, z float64) []byte {
+	return iccTag("XYZ ", func(b *bytes.Buffer) {
+		for _, v := range []float64{x, y, z} {
+			binary.Write(b, binary.BigEndian, int32(math.Round(v*65536)))
+		}
+	})
+}
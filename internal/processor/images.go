@@ -0,0 +1,604 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/image/tiff"
+)
+
+// Images printed below this resolution look blurred
+const minImageDPI = 150
+
+// Auto-crop only removes borders darker than borderCropWhite whose lines
+// are at least borderCropShare within borderCropTolerance of the corners
+const (
+	borderCropWhite     = 200
+	borderCropTolerance = 48
+	borderCropShare     = 0.98
+)
+
+// ImagePage describes a page made from an image input
+type ImagePage struct {
+	Page int `json:"page"`
+
+	// Size of the image in pixels as stored in the file
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	// DPI is the resolution the image prints at, SourceDPI the one stored
+	// in the file, 0 if it has none
+	DPI       float64 `json:"dpi"`
+	SourceDPI float64 `json:"source_dpi,omitempty"`
+
+	// Orientation is the EXIF orientation applied, 0 or 1 for none
+	Orientation int `json:"orientation,omitempty"`
+
+	// Cropped is set when a scanner border was removed
+	Cropped bool `json:"cropped,omitempty"`
+}
+
+func (page ImagePage) String() string {
+	s := fmt.Sprintf("Page %d: %d × %d px image at %.0f dpi", page.Page, page.Width, page.Height, page.DPI)
+	if page.Orientation > 1 {
+		s += ", turned upright"
+	}
+	if page.Cropped {
+		s += ", scanner border removed"
+	}
+	return s
+}
+
+// sourceImage is one page of an image input
+type sourceImage struct {
+	// jpeg is passed through unchanged if set, img holds the decoded pixels otherwise
+	jpeg       []byte
+	colorSpace string
+	img        image.Image
+
+	width, height int
+	xDPI, yDPI    float64
+	orientation   int
+}
+
+// openImage converts a JPEG, PNG or TIFF input into a PDF with one page of
+// the size of the A4 content area per image, so that every engine places
+// it unscaled within the margins
+func (p *PDFProcessor) openImage(inputFile, dir string, result *Result) (string, func(), error) {
+	noop := func() {}
+
+	images, err := readImages(inputFile)
+	if err != nil {
+		return inputFile, noop, err
+	}
+	ctx, err := p.imagePDF(images, result)
+	if err != nil {
+		return inputFile, noop, err
+	}
+
+	f, err := os.CreateTemp(dir, ".pdf2letterexpress-image-*.pdf")
+	if err != nil {
+		return inputFile, noop, fmt.Errorf("%w: cannot create PDF of image: %w", ErrOutputNotWritable, err)
+	}
+	f.Close()
+	imageFile := f.Name()
+	cleanup := func() { os.Remove(imageFile) }
+
+	if err := writeContextInPlace(ctx, imageFile); err != nil {
+		cleanup()
+		return inputFile, noop, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"input": inputFile,
+		"pages": len(images),
+	}).Info("Converted image input to PDF")
+	return imageFile, cleanup, nil
+}
+
+// imagePDF returns a PDF with a page for every image and records the pages on result
+func (p *PDFProcessor) imagePDF(images []sourceImage, result *Result) (*model.Context, error) {
+	width := A4WidthPoints - 2*MarginPoints
+	height := A4HeightPoints - 2*MarginPoints
+
+	ctx, err := pdfcpu.CreateContextWithXRefTable(p.config, &types.Dim{Width: width, Height: height})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PDF: %w", err)
+	}
+	pagesRef, pages, err := pageTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	kids := types.Array{}
+	for i, src := range images {
+		page := ImagePage{Page: i + 1, Width: src.width, Height: src.height, Orientation: src.orientation}
+		if src.xDPI > 0 {
+			page.SourceDPI = math.Round(src.xDPI)
+		}
+
+		crop := image.Rect(0, 0, src.width, src.height)
+		if p.opts.AutoCrop {
+			if img, err := src.pixels(); err != nil {
+				result.warn("page %d: cannot look for a scanner border: %v", page.Page, err)
+			} else {
+				crop = borderCrop(img).Sub(img.Bounds().Min)
+				page.Cropped = crop != image.Rect(0, 0, src.width, src.height)
+			}
+		}
+
+		m, clip, dpi := src.placement(crop, width, height)
+		page.DPI = math.Round(dpi)
+		if page.DPI < minImageDPI {
+			result.warn("page %d: image prints at %.0f dpi and may look blurred", page.Page, page.DPI)
+		}
+
+		imageRef, err := src.xobject(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed image %d: %w", page.Page, err)
+		}
+
+		var content strings.Builder
+		content.WriteString("q\n")
+		if page.Cropped {
+			fmt.Fprintf(&content, "%.4f %.4f %.4f %.4f re W n\n", clip.LL.X, clip.LL.Y, clip.Width(), clip.Height())
+		}
+		fmt.Fprintf(&content, "%s cm\n/Im0 Do\nQ\n", m)
+		contentRef, err := p.newContentStream(ctx, content.String())
+		if err != nil {
+			return nil, err
+		}
+
+		pageRef, err := ctx.IndRefForNewObject(types.Dict{
+			"Type":      types.Name("Page"),
+			"Parent":    pagesRef,
+			"Contents":  *contentRef,
+			"Resources": types.Dict{"XObject": types.Dict{"Im0": *imageRef}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		kids = append(kids, *pageRef)
+		result.Images = append(result.Images, page)
+
+		logrus.WithFields(logrus.Fields{
+			"page":        page.Page,
+			"pixels":      fmt.Sprintf("%dx%d", src.width, src.height),
+			"dpi":         page.DPI,
+			"orientation": src.orientation,
+			"cropped":     page.Cropped,
+		}).Debug("Placed image")
+	}
+
+	pages.Update("Kids", kids)
+	pages.Update("Count", types.Integer(len(kids)))
+	ctx.PageCount = len(kids)
+	return ctx, nil
+}
+
+// pageTree returns the root of the page tree of a new document
+func pageTree(ctx *model.Context) (types.IndirectRef, types.Dict, error) {
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return types.IndirectRef{}, nil, fmt.Errorf("failed to get catalog: %w", err)
+	}
+	pagesRef, ok := catalog["Pages"].(types.IndirectRef)
+	if !ok {
+		return types.IndirectRef{}, nil, fmt.Errorf("failed to get page tree")
+	}
+	pages, err := ctx.DereferenceDict(pagesRef)
+	if err != nil {
+		return types.IndirectRef{}, nil, fmt.Errorf("failed to get page tree: %w", err)
+	}
+	return pagesRef, pages, nil
+}
+
+// placement returns the matrix that draws the image, oriented and cropped
+// to crop, centered into a width × height page, the clip rectangle of the
+// cropped image and the resolution it prints at. Images with a resolution
+// are never enlarged, those without are fitted to the page.
+func (src sourceImage) placement(crop image.Rectangle, width, height float64) (matrix, *types.Rectangle, float64) {
+	// Size of the stored image in points
+	w, h := float64(src.width), float64(src.height)
+	if src.xDPI > 0 {
+		w = w * 72 / src.xDPI
+		h = h * 72 / src.yDPI
+	}
+
+	// Maps the unit square of the image onto its upright size in points
+	var orient matrix
+	switch src.orientation {
+	case 2:
+		orient = matrix{-w, 0, 0, h, w, 0}
+	case 3:
+		orient = matrix{-w, 0, 0, -h, w, h}
+	case 4:
+		orient = matrix{w, 0, 0, -h, 0, h}
+	case 5:
+		orient = matrix{0, -w, -h, 0, h, w}
+	case 6:
+		orient = matrix{0, -w, h, 0, 0, w}
+	case 7:
+		orient = matrix{0, w, h, 0, 0, 0}
+	case 8:
+		orient = matrix{0, w, -h, 0, h, 0}
+	default:
+		orient = matrix{w, 0, 0, h, 0, 0}
+	}
+
+	// The crop rectangle in the unit square, whose origin is the bottom left pixel
+	u0, u1 := float64(crop.Min.X)/float64(src.width), float64(crop.Max.X)/float64(src.width)
+	v0, v1 := 1-float64(crop.Max.Y)/float64(src.height), 1-float64(crop.Min.Y)/float64(src.height)
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, corner := range [][2]float64{{u0, v0}, {u1, v0}, {u0, v1}, {u1, v1}} {
+		x := orient[0]*corner[0] + orient[2]*corner[1] + orient[4]
+		y := orient[1]*corner[0] + orient[3]*corner[1] + orient[5]
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	cropWidth, cropHeight := maxX-minX, maxY-minY
+
+	scale := math.Min(width/cropWidth, height/cropHeight)
+	if src.xDPI > 0 && scale > 1 {
+		scale = 1
+	}
+	offsetX := (width - cropWidth*scale) / 2
+	offsetY := (height - cropHeight*scale) / 2
+
+	m := orient.then(matrix{1, 0, 0, 1, -minX, -minY}).then(matrix{scale, 0, 0, scale, offsetX, offsetY})
+	clip := types.NewRectangle(offsetX, offsetY, offsetX+cropWidth*scale, offsetY+cropHeight*scale)
+	dpi := float64(src.width) * 72 / (w * scale)
+	return m, clip, dpi
+}
+
+// xobject adds the image to ctx as an image XObject. JPEG data is embedded
+// as it is, decoded pixels flate encoded in gray or RGB on white.
+func (src sourceImage) xobject(ctx *model.Context) (*types.IndirectRef, error) {
+	if src.jpeg != nil {
+		sd, err := model.CreateDCTImageStreamDict(ctx.XRefTable, src.jpeg, src.width, src.height, 8, src.colorSpace)
+		if err != nil {
+			return nil, err
+		}
+		return ctx.IndRefForNewObject(*sd)
+	}
+
+	buf, colorSpace := imageSamples(src.img)
+	sd, err := model.CreateFlateImageStreamDict(ctx.XRefTable, buf, nil, src.width, src.height, 8, colorSpace)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.IndRefForNewObject(*sd)
+}
+
+// pixels returns the decoded image
+func (src sourceImage) pixels() (image.Image, error) {
+	if src.img != nil {
+		return src.img, nil
+	}
+	return jpeg.Decode(bytes.NewReader(src.jpeg))
+}
+
+// imageSamples returns the 8-bit samples of img composited on white, in
+// DeviceGray if all pixels are gray and in DeviceRGB otherwise
+func imageSamples(img image.Image) ([]byte, string) {
+	b := img.Bounds()
+	if gray, ok := img.(*image.Gray); ok {
+		buf := make([]byte, 0, b.Dx()*b.Dy())
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			i := gray.PixOffset(b.Min.X, y)
+			buf = append(buf, gray.Pix[i:i+b.Dx()]...)
+		}
+		return buf, model.DeviceGrayCS
+	}
+
+	rgb := make([]byte, 0, 3*b.Dx()*b.Dy())
+	isGray := true
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			r, g, bl := onWhite(c.R, c.A), onWhite(c.G, c.A), onWhite(c.B, c.A)
+			isGray = isGray && r == g && g == bl
+			rgb = append(rgb, r, g, bl)
+		}
+	}
+	if !isGray {
+		return rgb, model.DeviceRGBCS
+	}
+	gray := make([]byte, len(rgb)/3)
+	for i := range gray {
+		gray[i] = rgb[3*i]
+	}
+	return gray, model.DeviceGrayCS
+}
+
+// onWhite returns the 8-bit value of a 16-bit sample with alpha a drawn on white
+func onWhite(v, a uint16) uint8 {
+	return uint8((uint32(v)*uint32(a)/0xffff + 0xffff - uint32(a)) >> 8)
+}
+
+// borderCrop returns the part of img inside a dark scanner border, or
+// img.Bounds() if there is none. Light edges are left alone, they are the
+// margin of the scanned page.
+func borderCrop(img image.Image) image.Rectangle {
+	b := img.Bounds()
+	if b.Dx() < 4 || b.Dy() < 4 {
+		return b
+	}
+	luma := func(x, y int) int {
+		return int(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+	}
+
+	corners := []int{luma(b.Min.X, b.Min.Y), luma(b.Max.X-1, b.Min.Y), luma(b.Min.X, b.Max.Y-1), luma(b.Max.X-1, b.Max.Y-1)}
+	sort.Ints(corners)
+	border := (corners[1] + corners[2]) / 2
+	if border >= borderCropWhite {
+		return b
+	}
+
+	isBorder := func(x0, y0, dx, dy, n int) bool {
+		matching := 0
+		for i := 0; i < n; i++ {
+			if d := luma(x0+i*dx, y0+i*dy) - border; d >= -borderCropTolerance && d <= borderCropTolerance {
+				matching++
+			}
+		}
+		return float64(matching) >= borderCropShare*float64(n)
+	}
+
+	crop := b
+	for crop.Min.Y < crop.Max.Y && isBorder(b.Min.X, crop.Min.Y, 1, 0, b.Dx()) {
+		crop.Min.Y++
+	}
+	for crop.Max.Y > crop.Min.Y && isBorder(b.Min.X, crop.Max.Y-1, 1, 0, b.Dx()) {
+		crop.Max.Y--
+	}
+	for crop.Min.X < crop.Max.X && isBorder(crop.Min.X, crop.Min.Y, 0, 1, crop.Dy()) {
+		crop.Min.X++
+	}
+	for crop.Max.X > crop.Min.X && isBorder(crop.Max.X-1, crop.Min.Y, 0, 1, crop.Dy()) {
+		crop.Max.X--
+	}
+
+	// More than half of the image is not a border but a dark page
+	if crop.Dx() < b.Dx()/2 || crop.Dy() < b.Dy()/2 {
+		return b
+	}
+	return crop
+}
+
+// readImages reads the pages of a JPEG, PNG or TIFF file
+func readImages(filename string) ([]sourceImage, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot read image: %w", ErrUnreadableInput, err)
+	}
+
+	var images []sourceImage
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		images, err = readJPEG(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		images, err = readPNG(data)
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		images, err = readTIFF(data)
+	default:
+		return nil, fmt.Errorf("%w: not a JPEG, PNG or TIFF image", ErrUnreadableInput)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot decode image: %w", ErrUnreadableInput, err)
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("%w: image has no pages", ErrUnreadableInput)
+	}
+	return images, nil
+}
+
+// readJPEG reads a JPEG image, which is embedded without decoding it
+func readJPEG(data []byte) ([]sourceImage, error) {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	src := sourceImage{jpeg: data, width: config.Width, height: config.Height}
+	switch config.ColorModel {
+	case color.GrayModel:
+		src.colorSpace = model.DeviceGrayCS
+	case color.CMYKModel:
+		src.colorSpace = model.DeviceCMYKCS
+	default:
+		src.colorSpace = model.DeviceRGBCS
+	}
+
+	// Resolution from the JFIF header, orientation and resolution from EXIF
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) {
+			break
+		}
+		segment := data[i+4 : end]
+		switch {
+		case marker == 0xe0 && len(segment) >= 12 && bytes.HasPrefix(segment, []byte("JFIF\x00")):
+			x, y := float64(binary.BigEndian.Uint16(segment[8:])), float64(binary.BigEndian.Uint16(segment[10:]))
+			switch segment[7] {
+			case 1:
+				src.setDPI(x, y)
+			case 2:
+				src.setDPI(x*2.54, y*2.54)
+			}
+		case marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			if dirs, _ := readTIFFDirectories(segment[6:]); len(dirs) > 0 {
+				src.setTags(dirs[0].tags)
+			}
+		}
+		i = end
+	}
+	return []sourceImage{src}, nil
+}
+
+// readPNG reads a PNG image with its resolution and EXIF orientation
+func readPNG(data []byte) ([]sourceImage, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	src := sourceImage{img: img, width: img.Bounds().Dx(), height: img.Bounds().Dy()}
+
+	for i := 8; i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		if length < 0 || i+12+length > len(data) {
+			break
+		}
+		chunk := data[i+8 : i+8+length]
+		switch string(data[i+4 : i+8]) {
+		case "pHYs":
+			// Pixels per meter
+			if len(chunk) == 9 && chunk[8] == 1 {
+				src.setDPI(float64(binary.BigEndian.Uint32(chunk))*0.0254, float64(binary.BigEndian.Uint32(chunk[4:]))*0.0254)
+			}
+		case "eXIf":
+			if dirs, _ := readTIFFDirectories(chunk); len(dirs) > 0 {
+				src.setTags(dirs[0].tags)
+			}
+		}
+		i += 12 + length
+	}
+	return []sourceImage{src}, nil
+}
+
+// readTIFF reads every page of a TIFF image. The TIFF decoder only reads
+// the first directory, so each page is decoded from a copy whose header
+// points to the page's directory.
+func readTIFF(data []byte) ([]sourceImage, error) {
+	dirs, order := readTIFFDirectories(data)
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no image directory found")
+	}
+
+	patched := append([]byte(nil), data...)
+	images := make([]sourceImage, 0, len(dirs))
+	for i, dir := range dirs {
+		order.PutUint32(patched[4:], dir.offset)
+		img, err := tiff.Decode(bytes.NewReader(patched))
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", i+1, err)
+		}
+		src := sourceImage{img: img, width: img.Bounds().Dx(), height: img.Bounds().Dy()}
+		src.setTags(dir.tags)
+		images = append(images, src)
+	}
+	return images, nil
+}
+
+// TIFF and EXIF tags used for placement
+const (
+	tagOrientation    = 274
+	tagXResolution    = 282
+	tagYResolution    = 283
+	tagResolutionUnit = 296
+)
+
+// setTags takes orientation and resolution from TIFF or EXIF tags
+func (src *sourceImage) setTags(tags map[uint16]float64) {
+	if o := int(tags[tagOrientation]); o >= 1 && o <= 8 {
+		src.orientation = o
+	}
+	x, y := tags[tagXResolution], tags[tagYResolution]
+	switch unit, found := tags[tagResolutionUnit]; {
+	case !found || unit == 2:
+		src.setDPI(x, y)
+	case unit == 3:
+		src.setDPI(x*2.54, y*2.54)
+	}
+}
+
+// setDPI sets the resolution if it is plausible. A single value is used
+// for both directions.
+func (src *sourceImage) setDPI(x, y float64) {
+	plausible := func(dpi float64) bool { return dpi >= 30 && dpi <= 5000 }
+	switch {
+	case plausible(x) && plausible(y):
+		src.xDPI, src.yDPI = x, y
+	case plausible(x):
+		src.xDPI, src.yDPI = x, x
+	case plausible(y):
+		src.xDPI, src.yDPI = y, y
+	}
+}
+
+// tiffDirectory is an image file directory of a TIFF structure with the
+// first value of its numeric tags
+type tiffDirectory struct {
+	offset uint32
+	tags   map[uint16]float64
+}
+
+// readTIFFDirectories returns the chain of image file directories of a
+// TIFF structure, as used by TIFF files and EXIF data, and its byte order
+func readTIFFDirectories(data []byte) ([]tiffDirectory, binary.ByteOrder) {
+	if len(data) < 8 {
+		return nil, nil
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, nil
+	}
+
+	var dirs []tiffDirectory
+	seen := make(map[uint32]bool)
+	for offset := order.Uint32(data[4:]); offset != 0 && !seen[offset]; {
+		seen[offset] = true
+		if int64(offset)+2 > int64(len(data)) {
+			break
+		}
+		n := int(order.Uint16(data[offset:]))
+		next := int64(offset) + 2 + 12*int64(n)
+		if next+4 > int64(len(data)) {
+			break
+		}
+
+		dir := tiffDirectory{offset: offset, tags: make(map[uint16]float64)}
+		for i := 0; i < n; i++ {
+			entry := data[int64(offset)+2+12*int64(i):]
+			tag, typ := order.Uint16(entry), order.Uint16(entry[2:])
+			switch typ {
+			case 3: // SHORT
+				dir.tags[tag] = float64(order.Uint16(entry[8:]))
+			case 4: // LONG
+				dir.tags[tag] = float64(order.Uint32(entry[8:]))
+			case 5: // RATIONAL
+				at := int64(order.Uint32(entry[8:]))
+				if at+8 <= int64(len(data)) {
+					if den := order.Uint32(data[at+4:]); den != 0 {
+						dir.tags[tag] = float64(order.Uint32(data[at:])) / float64(den)
+					}
+				}
+			}
+		}
+		dirs = append(dirs, dir)
+		offset = order.Uint32(data[next:])
+	}
+	return dirs, order
+}
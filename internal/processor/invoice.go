@@ -0,0 +1,410 @@
+package processor
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Invoice is embedded e-invoice XML such as ZUGFeRD, Factur-X or XRechnung
+type Invoice struct {
+	Name             string `json:"name"`
+	Relationship     string `json:"relationship"`
+	ConformanceLevel string `json:"conformance_level"`
+	DocumentType     string `json:"document_type"`
+	Version          string `json:"version"`
+	Size             int    `json:"size"`
+
+	// namespace and prefix of the XMP schema describing the invoice
+	namespace string
+	prefix    string
+
+	description string
+	modDate     string
+	data        []byte
+}
+
+func (inv *Invoice) String() string {
+	return fmt.Sprintf("%s (%s)", inv.Name, inv.ConformanceLevel)
+}
+
+// invoiceSchema is the XMP schema of an e-invoice file name
+type invoiceSchema struct {
+	namespace string
+	prefix    string
+}
+
+// invoiceSchemas maps the lower case file names of e-invoice XML to their
+// XMP schema
+var invoiceSchemas = map[string]invoiceSchema{
+	"factur-x.xml":        {"urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#", "fx"},
+	"xrechnung.xml":       {"urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#", "fx"},
+	"zugferd-invoice.xml": {"urn:zugferd:pdfa:CrossIndustryDocument:invoice:2p0#", "fx"},
+}
+
+// isInvoiceFile reports whether an embedded file name is e-invoice XML
+func isInvoiceFile(name string) bool {
+	_, ok := invoiceSchemas[strings.ToLower(name)]
+	return ok
+}
+
+var (
+	xmpInvoiceProperty = regexp.MustCompile(`<(\w+):(DocumentType|DocumentFileName|Version|ConformanceLevel)>\s*([^<]*?)\s*</`)
+	invoiceGuideline   = regexp.MustCompile(`GuidelineSpecifiedDocumentContextParameter>\s*<\w+:ID>\s*([^<]*?)\s*<`)
+)
+
+// readInvoice returns the e-invoice XML embedded in ctx, nil if there is none
+func readInvoice(ctx *model.Context) (*Invoice, error) {
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get catalog: %w", err)
+	}
+
+	var filespecs []types.Object
+	if names, err := ctx.DereferenceDict(catalog["Names"]); err == nil && names != nil {
+		for _, entry := range nameTreeEntries(ctx, names["EmbeddedFiles"], 0) {
+			filespecs = append(filespecs, entry.value)
+		}
+	}
+	if af, err := ctx.DereferenceArray(catalog["AF"]); err == nil {
+		filespecs = append(filespecs, af...)
+	}
+
+	for _, obj := range filespecs {
+		filespec, err := ctx.DereferenceDict(obj)
+		if err != nil || filespec == nil {
+			continue
+		}
+		name := filespecName(ctx, filespec)
+		if !isInvoiceFile(name) {
+			continue
+		}
+		inv, err := readInvoiceFile(ctx, filespec, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		readInvoiceMetadata(ctx, catalog, inv)
+		return inv, nil
+	}
+	return nil, nil
+}
+
+// filespecName returns the file name of a file specification
+func filespecName(ctx *model.Context, filespec types.Dict) string {
+	for _, key := range []string{"UF", "F"} {
+		obj, err := ctx.Dereference(filespec[key])
+		if err != nil || obj == nil {
+			continue
+		}
+		if s, err := types.StringOrHexLiteral(obj); err == nil && s != nil && *s != "" {
+			return *s
+		}
+	}
+	return ""
+}
+
+// readInvoiceFile reads the embedded file of an invoice file specification
+func readInvoiceFile(ctx *model.Context, filespec types.Dict, name string) (*Invoice, error) {
+	ef, err := ctx.DereferenceDict(filespec["EF"])
+	if err != nil || ef == nil {
+		return nil, fmt.Errorf("no embedded file stream")
+	}
+	obj, found := ef.Find("UF")
+	if !found {
+		obj = ef["F"]
+	}
+	sd, _, err := ctx.DereferenceStreamDict(obj)
+	if err != nil || sd == nil {
+		return nil, fmt.Errorf("no embedded file stream")
+	}
+	if err := sd.Decode(); err != nil {
+		return nil, err
+	}
+
+	inv := &Invoice{
+		Name:         name,
+		Relationship: "Alternative",
+		DocumentType: "INVOICE",
+		Version:      "1.0",
+		Size:         len(sd.Content),
+		data:         sd.Content,
+	}
+	schema := invoiceSchemas[strings.ToLower(name)]
+	inv.namespace, inv.prefix = schema.namespace, schema.prefix
+	if rel := filespec.NameEntry("AFRelationship"); rel != nil {
+		inv.Relationship = *rel
+	}
+	if obj, err := ctx.Dereference(filespec["Desc"]); err == nil && obj != nil {
+		if s, err := types.StringOrHexLiteral(obj); err == nil && s != nil {
+			inv.description = *s
+		}
+	}
+	if params, err := ctx.DereferenceDict(sd.Dict["Params"]); err == nil && params != nil {
+		if obj, err := ctx.Dereference(params["ModDate"]); err == nil && obj != nil {
+			if s, err := types.StringOrHexLiteral(obj); err == nil && s != nil {
+				inv.modDate = *s
+			}
+		}
+	}
+	inv.ConformanceLevel = guidelineConformance(inv.data)
+	return inv, nil
+}
+
+// readInvoiceMetadata takes the invoice properties from the XMP metadata of
+// the catalog, if it describes the invoice
+func readInvoiceMetadata(ctx *model.Context, catalog types.Dict, inv *Invoice) {
+	sd, _, err := ctx.DereferenceStreamDict(catalog["Metadata"])
+	if err != nil || sd == nil || sd.Decode() != nil {
+		return
+	}
+	xmp := string(sd.Content)
+	for _, m := range xmpInvoiceProperty.FindAllStringSubmatch(xmp, -1) {
+		prefix, property, value := m[1], m[2], m[3]
+		if strings.HasPrefix(prefix, "pdfa") || value == "" {
+			continue
+		}
+		switch property {
+		case "DocumentType":
+			inv.DocumentType = value
+		case "Version":
+			inv.Version = value
+		case "ConformanceLevel":
+			inv.ConformanceLevel = value
+		case "DocumentFileName":
+			continue
+		}
+		if ns := regexp.MustCompile(`xmlns:` + regexp.QuoteMeta(prefix) + `="([^"]*)"`).FindStringSubmatch(xmp); ns != nil {
+			inv.namespace, inv.prefix = ns[1], prefix
+		}
+	}
+}
+
+// guidelineConformance derives the conformance level from the guideline
+// of the invoice XML, e.g. "urn:cen.eu:en16931:2017" is EN 16931
+func guidelineConformance(data []byte) string {
+	m := invoiceGuideline.FindSubmatch(data)
+	if m == nil {
+		return "EN 16931"
+	}
+	guideline := strings.ToLower(string(m[1]))
+	for _, level := range []struct{ match, level string }{
+		{"xrechnung", "XRECHNUNG"},
+		{"extended", "EXTENDED"},
+		{"basicwl", "BASIC WL"},
+		{"basic", "BASIC"},
+		{"minimum", "MINIMUM"},
+	} {
+		if strings.Contains(guideline, level.match) {
+			return level.level
+		}
+	}
+	return "EN 16931"
+}
+
+// attachInvoice embeds the invoice into outputFile, replacing a copy the
+// engine may have carried over, and checks that it arrived unchanged
+func (p *PDFProcessor) attachInvoice(outputFile string, inv *Invoice) error {
+	ctx, err := p.readContextFile(outputFile)
+	if err != nil {
+		return err
+	}
+	if err := embedInvoice(ctx, inv); err != nil {
+		return fmt.Errorf("failed to embed %s: %w", inv.Name, err)
+	}
+	if err := writeContextInPlace(ctx, outputFile); err != nil {
+		return err
+	}
+
+	if ctx, err = p.readContextFile(outputFile); err != nil {
+		return err
+	}
+	embedded, err := readInvoice(ctx)
+	if err != nil || embedded == nil || !bytes.Equal(embedded.data, inv.data) {
+		return fmt.Errorf("%w: the e-invoice XML %s was not preserved", ErrNonCompliant, inv.Name)
+	}
+
+	logrus.WithField("invoice", inv.Name).Info("Carried e-invoice XML into the output")
+	return nil
+}
+
+// embedInvoice adds the invoice as an associated file of the document with
+// its relationship and file metadata, and describes it in the XMP metadata.
+// Earlier copies of e-invoice XML are removed.
+func embedInvoice(ctx *model.Context, inv *Invoice) error {
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return fmt.Errorf("failed to get catalog: %w", err)
+	}
+
+	modDate := inv.modDate
+	if modDate == "" {
+		modDate = types.DateString(time.Now())
+	}
+	checksum := md5.Sum(inv.data)
+	file, err := ctx.NewStreamDictForBuf(inv.data)
+	if err != nil {
+		return err
+	}
+	file.InsertName("Type", "EmbeddedFile")
+	file.InsertName("Subtype", "text/xml")
+	file.Insert("Params", types.Dict{
+		"Size":     types.Integer(len(inv.data)),
+		"ModDate":  types.StringLiteral(modDate),
+		"CheckSum": types.HexLiteral(fmt.Sprintf("%X", checksum)),
+	})
+	if err := file.Encode(); err != nil {
+		return err
+	}
+	fileRef, err := ctx.IndRefForNewObject(*file)
+	if err != nil {
+		return err
+	}
+
+	description := inv.description
+	if description == "" {
+		description = "E-invoice XML"
+	}
+	filespecRef, err := ctx.IndRefForNewObject(types.Dict{
+		"Type":           types.Name("Filespec"),
+		"F":              types.StringLiteral(inv.Name),
+		"UF":             types.StringLiteral(inv.Name),
+		"Desc":           types.StringLiteral(description),
+		"AFRelationship": types.Name(inv.Relationship),
+		"EF":             types.Dict{"F": *fileRef, "UF": *fileRef},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The name tree is rebuilt as a single node without other invoices
+	names, _ := ctx.DereferenceDict(catalog["Names"])
+	if names == nil {
+		names = types.Dict{}
+		catalog.Update("Names", names)
+	}
+	entries := []nameTreeEntry{{key: inv.Name, value: *filespecRef}}
+	for _, entry := range nameTreeEntries(ctx, names["EmbeddedFiles"], 0) {
+		if !isInvoiceFile(entry.key) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	tree := types.Array{}
+	for _, entry := range entries {
+		tree = append(tree, types.StringLiteral(entry.key), entry.value)
+	}
+	names.Update("EmbeddedFiles", types.Dict{"Names": tree})
+
+	af := types.Array{*filespecRef}
+	if existing, err := ctx.DereferenceArray(catalog["AF"]); err == nil {
+		for _, obj := range existing {
+			if filespec, err := ctx.DereferenceDict(obj); err == nil && filespec != nil && !isInvoiceFile(filespecName(ctx, filespec)) {
+				af = append(af, obj)
+			}
+		}
+	}
+	catalog.Update("AF", af)
+
+	metadata := types.StreamDict{
+		Dict:    types.Dict{"Type": types.Name("Metadata"), "Subtype": types.Name("XML")},
+		Content: invoiceMetadata(inv),
+	}
+	if err := metadata.Encode(); err != nil {
+		return err
+	}
+	metadataRef, err := ctx.IndRefForNewObject(metadata)
+	if err != nil {
+		return err
+	}
+	catalog.Update("Metadata", *metadataRef)
+	return nil
+}
+
+// invoiceMetadata returns an XMP packet that only describes the invoice
+func invoiceMetadata(inv *Invoice) []byte {
+	var b strings.Builder
+	b.WriteString("<?xpacket begin=\"\uFEFF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	writeInvoiceXMP(&b, inv)
+	b.WriteString(" </rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+	b.WriteString("<?xpacket end=\"w\"?>")
+	return []byte(b.String())
+}
+
+// writeInvoiceXMP writes the XMP descriptions of the invoice and, as PDF/A
+// requires for custom schemas, of its extension schema
+func writeInvoiceXMP(b *strings.Builder, inv *Invoice) {
+	fmt.Fprintf(b, "  <rdf:Description rdf:about=\"\" xmlns:%s=\"%s\">\n", inv.prefix, xmlEscape(inv.namespace))
+	for _, property := range [][2]string{
+		{"DocumentType", inv.DocumentType},
+		{"DocumentFileName", inv.Name},
+		{"Version", inv.Version},
+		{"ConformanceLevel", inv.ConformanceLevel},
+	} {
+		fmt.Fprintf(b, "   <%s:%s>%s</%s:%s>\n", inv.prefix, property[0], xmlEscape(property[1]), inv.prefix, property[0])
+	}
+	b.WriteString("  </rdf:Description>\n")
+
+	b.WriteString("  <rdf:Description rdf:about=\"\"\n")
+	b.WriteString("    xmlns:pdfaExtension=\"http://www.aiim.org/pdfa/ns/extension/\"\n")
+	b.WriteString("    xmlns:pdfaSchema=\"http://www.aiim.org/pdfa/ns/schema#\"\n")
+	b.WriteString("    xmlns:pdfaProperty=\"http://www.aiim.org/pdfa/ns/property#\">\n")
+	b.WriteString("   <pdfaExtension:schemas><rdf:Bag><rdf:li rdf:parseType=\"Resource\">\n")
+	b.WriteString("    <pdfaSchema:schema>Factur-X PDFA Extension Schema</pdfaSchema:schema>\n")
+	fmt.Fprintf(b, "    <pdfaSchema:namespaceURI>%s</pdfaSchema:namespaceURI>\n", xmlEscape(inv.namespace))
+	fmt.Fprintf(b, "    <pdfaSchema:prefix>%s</pdfaSchema:prefix>\n", inv.prefix)
+	b.WriteString("    <pdfaSchema:property><rdf:Seq>\n")
+	for _, property := range [][2]string{
+		{"DocumentFileName", "The name of the embedded XML document"},
+		{"DocumentType", "The type of the hybrid document in capital letters, e.g. INVOICE"},
+		{"Version", "The actual version of the standard applying to the embedded XML document"},
+		{"ConformanceLevel", "The conformance level of the embedded XML document"},
+	} {
+		b.WriteString("     <rdf:li rdf:parseType=\"Resource\">\n")
+		fmt.Fprintf(b, "      <pdfaProperty:name>%s</pdfaProperty:name>\n", property[0])
+		b.WriteString("      <pdfaProperty:valueType>Text</pdfaProperty:valueType>\n")
+		b.WriteString("      <pdfaProperty:category>external</pdfaProperty:category>\n")
+		fmt.Fprintf(b, "      <pdfaProperty:description>%s</pdfaProperty:description>\n", property[1])
+		b.WriteString("     </rdf:li>\n")
+	}
+	b.WriteString("    </rdf:Seq></pdfaSchema:property>\n")
+	b.WriteString("   </rdf:li></rdf:Bag></pdfaExtension:schemas>\n")
+	b.WriteString("  </rdf:Description>\n")
+}
+
+// readInputInvoice records the e-invoice XML of the input. It fails if the
+// requested archival copy cannot carry it and warns if there is none that
+// conforms to PDF/A-3 like ZUGFeRD and Factur-X require.
+func (p *PDFProcessor) readInputInvoice(ctx *model.Context, opts Options, result *Result) error {
+	inv, err := readInvoice(ctx)
+	if err != nil || inv == nil {
+		return err
+	}
+	if err := checkInvoiceArchive(inv, opts.ArchiveLevel); err != nil {
+		return err
+	}
+	result.Invoice = inv
+	if opts.ArchiveLevel == "" {
+		result.warn("the output carries the e-invoice XML %s but is not PDF/A-3, use --archive %s for a conforming copy", inv.Name, ArchivePDFA3B)
+	}
+	return nil
+}
+
+// checkInvoiceArchive refuses archival copies that cannot carry the invoice
+func checkInvoiceArchive(inv *Invoice, level string) error {
+	if inv == nil || level == "" || archiveParts[level] >= 3 {
+		return nil
+	}
+	return fmt.Errorf("%w: the e-invoice XML %s cannot be carried into a %s archival copy, use %s",
+		ErrNonCompliant, inv.Name, level, ArchivePDFA3B)
+}
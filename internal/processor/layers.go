@@ -0,0 +1,515 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Layer is an optional content group of the input and whether it prints
+type Layer struct {
+	Name    string `json:"name"`
+	Printed bool   `json:"printed"`
+}
+
+func (l Layer) String() string {
+	if l.Printed {
+		return fmt.Sprintf("layer %q flattened", l.Name)
+	}
+	return fmt.Sprintf("layer %q removed, it is hidden for printing", l.Name)
+}
+
+// optionalContent holds the print state of the optional content groups of
+// a document
+type optionalContent struct {
+	ctx *model.Context
+
+	// printed maps the object numbers of the groups to their print state
+	printed map[int]bool
+}
+
+// readOptionalContent determines the print state of every optional content
+// group of ctx. Groups start in the state of the default configuration and
+// take their print usage if the configuration applies it on printing. It
+// returns nil if the document has no optional content.
+func readOptionalContent(ctx *model.Context) (*optionalContent, []Layer, error) {
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get catalog: %w", err)
+	}
+	properties, err := ctx.DereferenceDict(catalog["OCProperties"])
+	if err != nil || properties == nil {
+		return nil, nil, err
+	}
+	groups, err := ctx.DereferenceArray(properties["OCGs"])
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := ctx.DereferenceDict(properties["D"])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	oc := &optionalContent{ctx: ctx, printed: make(map[int]bool)}
+	base := true
+	if config != nil {
+		if state := config.NameEntry("BaseState"); state != nil && *state == "OFF" {
+			base = false
+		}
+	}
+	for _, obj := range groups {
+		if ref, ok := obj.(types.IndirectRef); ok {
+			oc.printed[ref.ObjectNumber.Value()] = base
+		}
+	}
+	if config == nil {
+		return oc, oc.layers(groups), nil
+	}
+
+	for key, state := range map[string]bool{"ON": true, "OFF": false} {
+		refs, _ := ctx.DereferenceArray(config[key])
+		for _, obj := range refs {
+			if ref, ok := obj.(types.IndirectRef); ok {
+				oc.printed[ref.ObjectNumber.Value()] = state
+			}
+		}
+	}
+
+	// Usage application dictionaries of the Print event
+	usages, _ := ctx.DereferenceArray(config["AS"])
+	for _, obj := range usages {
+		usage, err := ctx.DereferenceDict(obj)
+		if err != nil || usage == nil {
+			continue
+		}
+		if event := usage.NameEntry("Event"); event == nil || *event != "Print" {
+			continue
+		}
+		refs, _ := ctx.DereferenceArray(usage["OCGs"])
+		for _, obj := range refs {
+			ref, ok := obj.(types.IndirectRef)
+			if !ok {
+				continue
+			}
+			if state, ok := oc.printState(ref); ok {
+				oc.printed[ref.ObjectNumber.Value()] = state
+			}
+		}
+	}
+
+	return oc, oc.layers(groups), nil
+}
+
+// printState returns the PrintState of the print usage of a group, if any
+func (oc *optionalContent) printState(ref types.IndirectRef) (bool, bool) {
+	group, err := oc.ctx.DereferenceDict(ref)
+	if err != nil || group == nil {
+		return false, false
+	}
+	usage, err := oc.ctx.DereferenceDict(group["Usage"])
+	if err != nil || usage == nil {
+		return false, false
+	}
+	printUsage, err := oc.ctx.DereferenceDict(usage["Print"])
+	if err != nil || printUsage == nil {
+		return false, false
+	}
+	state := printUsage.NameEntry("PrintState")
+	if state == nil {
+		return false, false
+	}
+	return *state == "ON", true
+}
+
+// layers lists the groups with their names and print state
+func (oc *optionalContent) layers(groups types.Array) []Layer {
+	var layers []Layer
+	for _, obj := range groups {
+		ref, ok := obj.(types.IndirectRef)
+		if !ok {
+			continue
+		}
+		layer := Layer{Name: "unnamed", Printed: oc.printed[ref.ObjectNumber.Value()]}
+		if group, err := oc.ctx.DereferenceDict(ref); err == nil && group != nil {
+			if obj, err := oc.ctx.Dereference(group["Name"]); err == nil && obj != nil {
+				if name, err := types.StringOrHexLiteral(obj); err == nil && name != nil {
+					layer.Name = *name
+				}
+			}
+		}
+		layers = append(layers, layer)
+	}
+	return layers
+}
+
+// visible reports whether content marked with an optional content group or
+// membership dictionary prints. Visibility expressions are not evaluated,
+// the policy of a membership dictionary is.
+func (oc *optionalContent) visible(obj types.Object) bool {
+	if ref, ok := obj.(types.IndirectRef); ok {
+		if printed, found := oc.printed[ref.ObjectNumber.Value()]; found {
+			return printed
+		}
+	}
+
+	d, err := oc.ctx.DereferenceDict(obj)
+	if err != nil || d == nil {
+		return true
+	}
+	if typ := d.NameEntry("Type"); typ == nil || *typ != "OCMD" {
+		return true
+	}
+
+	var states []bool
+	switch groups := d["OCGs"].(type) {
+	case types.IndirectRef:
+		states = append(states, oc.visible(groups))
+	case types.Array:
+		for _, group := range groups {
+			states = append(states, oc.visible(group))
+		}
+	}
+	if len(states) == 0 {
+		return true
+	}
+
+	on := 0
+	for _, state := range states {
+		if state {
+			on++
+		}
+	}
+	policy := "AnyOn"
+	if p := d.NameEntry("P"); p != nil {
+		policy = *p
+	}
+	switch policy {
+	case "AllOn":
+		return on == len(states)
+	case "AnyOff":
+		return on < len(states)
+	case "AllOff":
+		return on == 0
+	default:
+		return on > 0
+	}
+}
+
+// isOptionalContent reports whether obj is an optional content group or
+// membership dictionary
+func (oc *optionalContent) isOptionalContent(obj types.Object) bool {
+	d, err := oc.ctx.DereferenceDict(obj)
+	if err != nil || d == nil {
+		return false
+	}
+	typ := d.NameEntry("Type")
+	return typ != nil && (*typ == "OCG" || *typ == "OCMD")
+}
+
+// flattenOptionalContent removes the content hidden for printing from all
+// pages, form XObjects and annotations of ctx and then drops the optional
+// content, so that every viewer and printer shows the print state. It
+// returns the layers of the document, nil if it has none.
+func (p *PDFProcessor) flattenOptionalContent(ctx *model.Context) ([]Layer, error) {
+	oc, layers, err := readOptionalContent(ctx)
+	if err != nil || oc == nil {
+		return nil, err
+	}
+
+	flattener := &contentFlattener{oc: oc, forms: make(map[int]bool)}
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		if err := flattener.page(pageNr); err != nil {
+			return nil, err
+		}
+	}
+
+	// Resources are shared, the marks can only go once all content is rewritten
+	for _, d := range flattener.marked {
+		d.Delete("OC")
+	}
+	for _, properties := range flattener.properties {
+		for name, obj := range properties {
+			if oc.isOptionalContent(obj) {
+				properties.Delete(name)
+			}
+		}
+	}
+
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get catalog: %w", err)
+	}
+	catalog.Delete("OCProperties")
+
+	logrus.WithField("layers", len(layers)).Info("Flattened optional content to its print state")
+	return layers, nil
+}
+
+// contentFlattener rewrites content streams to their print state
+type contentFlattener struct {
+	oc *optionalContent
+
+	// forms are the object numbers of the form XObjects already rewritten
+	forms map[int]bool
+
+	// marked are the XObjects with optional content, properties the
+	// property resources seen
+	marked     []types.Dict
+	properties []types.Dict
+}
+
+// page rewrites the content, form XObjects and annotations of a page
+func (f *contentFlattener) page(pageNr int) error {
+	ctx := f.oc.ctx
+	pageDict, _, _, err := ctx.PageDict(pageNr, false)
+	if err != nil {
+		return fmt.Errorf("failed to get page dict: %w", err)
+	}
+	resources, err := pageResources(ctx, pageNr)
+	if err != nil {
+		return err
+	}
+
+	content, err := ctx.PageContent(pageDict, pageNr)
+	if err != nil && !errors.Is(err, model.ErrNoContent) {
+		return fmt.Errorf("failed to read content of page %d: %w", pageNr, err)
+	}
+	if flattened, changed := f.content(content, resources); changed {
+		sd, err := ctx.NewStreamDictForBuf(flattened)
+		if err != nil {
+			return fmt.Errorf("failed to create content stream: %w", err)
+		}
+		if err := sd.Encode(); err != nil {
+			return fmt.Errorf("failed to encode content stream: %w", err)
+		}
+		ref, err := ctx.IndRefForNewObject(*sd)
+		if err != nil {
+			return err
+		}
+		pageDict.Update("Contents", *ref)
+	}
+
+	if err := f.resources(resources, 0); err != nil {
+		return err
+	}
+
+	if annots, err := ctx.DereferenceArray(pageDict["Annots"]); err == nil && annots != nil {
+		kept := types.Array{}
+		for _, obj := range annots {
+			annot, err := ctx.DereferenceDict(obj)
+			if err == nil && annot != nil {
+				if group, found := annot.Find("OC"); found {
+					if !f.oc.visible(group) {
+						continue
+					}
+					annot.Delete("OC")
+				}
+			}
+			kept = append(kept, obj)
+		}
+		pageDict.Update("Annots", kept)
+	}
+
+	return nil
+}
+
+// resources rewrites the form XObjects of resources and collects the
+// optional content marks of XObjects and properties
+func (f *contentFlattener) resources(resources types.Dict, depth int) error {
+	if resources == nil || depth >= maxFormDepth {
+		return nil
+	}
+	ctx := f.oc.ctx
+
+	if properties, err := ctx.DereferenceDict(resources["Properties"]); err == nil && properties != nil {
+		f.properties = append(f.properties, properties)
+	}
+
+	xobjects, err := ctx.DereferenceDict(resources["XObject"])
+	if err != nil || xobjects == nil {
+		return nil
+	}
+	for _, obj := range xobjects {
+		ref, ok := obj.(types.IndirectRef)
+		if !ok {
+			continue
+		}
+		sd, _, err := ctx.DereferenceStreamDict(ref)
+		if err != nil || sd == nil {
+			continue
+		}
+		if _, found := sd.Find("OC"); found {
+			f.marked = append(f.marked, sd.Dict)
+		}
+
+		if subtype := sd.Subtype(); subtype == nil || *subtype != "Form" || f.forms[ref.ObjectNumber.Value()] {
+			continue
+		}
+		f.forms[ref.ObjectNumber.Value()] = true
+
+		formResources := resources
+		if d, err := ctx.DereferenceDict(sd.Dict["Resources"]); err == nil && d != nil {
+			formResources = d
+		}
+		if err := sd.Decode(); err != nil {
+			return fmt.Errorf("failed to decode form XObject: %w", err)
+		}
+		if flattened, changed := f.content(sd.Content, formResources); changed {
+			sd.Content = flattened
+			if err := sd.Encode(); err != nil {
+				return fmt.Errorf("failed to encode form XObject: %w", err)
+			}
+			// Stream dictionaries are dereferenced as copies
+			if entry, found := ctx.FindTableEntryForIndRef(&ref); found {
+				entry.Object = *sd
+			}
+		}
+		if err := f.resources(formResources, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// content removes the marked content sections and XObjects hidden for
+// printing from a content stream. Visible sections keep their marks as
+// plain marked content.
+func (f *contentFlattener) content(content []byte, resources types.Dict) ([]byte, bool) {
+	var out bytes.Buffer
+	changed := false
+
+	// hidden holds for every open marked content section whether it is hidden
+	var hidden []bool
+	isHidden := func() bool { return len(hidden) > 0 && hidden[len(hidden)-1] }
+
+	var operands []contentToken
+	start := 0
+	for _, tok := range tokenizeContent(content) {
+		if tok.kind != tokenOperator {
+			operands = append(operands, tok)
+			continue
+		}
+		op := content[start:tok.end]
+		start = tok.end
+
+		drop := isHidden()
+		switch tok.value {
+		case "BDC":
+			if len(operands) == 2 && operands[0].value == "/OC" {
+				group := f.property(resources, operands[1].value)
+				drop = drop || !f.oc.visible(group)
+				hidden = append(hidden, drop)
+				op = []byte(" /OC BMC")
+				changed = true
+			} else {
+				hidden = append(hidden, drop)
+			}
+		case "BMC":
+			hidden = append(hidden, drop)
+		case "EMC":
+			if len(hidden) > 0 {
+				hidden = hidden[:len(hidden)-1]
+			}
+		case "Do":
+			if !drop && len(operands) > 0 {
+				if group, ok := f.xobjectGroup(resources, operands[len(operands)-1].value); ok && !f.oc.visible(group) {
+					drop = true
+				}
+			}
+		}
+		operands = operands[:0]
+
+		if drop {
+			changed = true
+			continue
+		}
+		out.Write(op)
+	}
+	if !isHidden() {
+		out.Write(content[start:])
+	}
+
+	return out.Bytes(), changed
+}
+
+// property returns the properties resource of a marked content name
+func (f *contentFlattener) property(resources types.Dict, name string) types.Object {
+	if resources == nil {
+		return nil
+	}
+	properties, err := f.oc.ctx.DereferenceDict(resources["Properties"])
+	if err != nil || properties == nil {
+		return nil
+	}
+	return properties[strings.TrimPrefix(name, "/")]
+}
+
+// xobjectGroup returns the optional content of an XObject resource
+func (f *contentFlattener) xobjectGroup(resources types.Dict, name string) (types.Object, bool) {
+	if resources == nil {
+		return nil, false
+	}
+	xobjects, err := f.oc.ctx.DereferenceDict(resources["XObject"])
+	if err != nil || xobjects == nil {
+		return nil, false
+	}
+	sd, _, err := f.oc.ctx.DereferenceStreamDict(xobjects[strings.TrimPrefix(name, "/")])
+	if err != nil || sd == nil {
+		return nil, false
+	}
+	return sd.Find("OC")
+}
+
+// recordLayers records the layers of the input and warns about those
+// removed because they are hidden for printing
+func recordLayers(layers []Layer, result *Result) {
+	result.Layers = layers
+	for _, layer := range layers {
+		if !layer.Printed {
+			result.warn("%s", layer)
+		}
+	}
+}
+
+// checkTransparency records the pages of the vector engine's output that
+// use transparency and rasterizes them if requested. The raster engines
+// flatten transparency anyway.
+func (p *PDFProcessor) checkTransparency(outputFile string, opts Options, result *Result) error {
+	if result.Engine != EnginePDFCPU {
+		return nil
+	}
+
+	ctx, err := p.readContextFile(outputFile)
+	if err != nil {
+		return err
+	}
+	pages := transparentPages(ctx)
+	recordTransparency(pages, opts, result)
+	if len(pages) == 0 || !opts.FlattenTransparency {
+		return nil
+	}
+
+	if err := p.rasterizePages(outputFile, pages, opts.Raster); err != nil {
+		return err
+	}
+	logrus.WithField("pages", pages).Info("Flattened transparency by rasterizing pages")
+	return nil
+}
+
+// recordTransparency records the pages with transparency on result
+func recordTransparency(pages []int, opts Options, result *Result) {
+	result.TransparentPages = pages
+	if len(pages) == 0 {
+		return
+	}
+	if opts.FlattenTransparency {
+		result.TransparencyFlattened = true
+		return
+	}
+	result.warn("pages %s use transparency and may print differently than shown, see --flatten-transparency", joinPages(pages))
+}
@@ -0,0 +1,419 @@
+package processor
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/sirupsen/logrus"
+
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
+)
+
+// mergeFontName is the resource name of the embedded font used for stamped text
+const mergeFontName = "PDF2LXMerge"
+
+// MergeLayout describes the text stamped onto page 1 of a template. All
+// positions are in mm from the top left corner of the final A4 letter, so
+// they refer to the DIN 5008 geometry regardless of the template's size.
+type MergeLayout struct {
+	// Font is a TrueType font file, empty for the built-in font
+	Font     string       `json:"font,omitempty"`
+	FontSize float64      `json:"font_size,omitempty"`
+	Address  AddressBlock `json:"address"`
+	Fields   []MergeField `json:"fields,omitempty"`
+}
+
+// AddressBlock is the recipient address placed in the envelope window.
+// Lines that are empty after filling in the placeholders are left out.
+type AddressBlock struct {
+	XMM          float64  `json:"x_mm"`
+	YMM          float64  `json:"y_mm"`
+	WidthMM      float64  `json:"width_mm"`
+	LineHeightMM float64  `json:"line_height_mm"`
+	FontSize     float64  `json:"font_size,omitempty"`
+	Lines        []string `json:"lines"`
+}
+
+// MergeField is a single line of text with {{placeholders}}
+type MergeField struct {
+	Text     string  `json:"text"`
+	XMM      float64 `json:"x_mm"`
+	YMM      float64 `json:"y_mm"`
+	FontSize float64 `json:"font_size,omitempty"`
+}
+
+// DefaultMergeLayout returns a layout that puts a five line address into
+// the address zone of the DIN 5008 Form B window
+func DefaultMergeLayout() MergeLayout {
+	return MergeLayout{
+		FontSize: 10,
+		Address: AddressBlock{
+			// DIN 5008: 5mm indent, below the return address and the 12.7mm remark zone
+			XMM:          AddressWindowLeftMM + 5,
+			YMM:          AddressWindowTopMM + ReturnAddressHeightMM + 12.7,
+			WidthMM:      AddressWindowWidthMM - 10,
+			LineHeightMM: 4.23,
+			Lines: []string{
+				"{{company}}",
+				"{{name}}",
+				"{{street}}",
+				"{{zip}} {{city}}",
+				"{{country}}",
+			},
+		},
+	}
+}
+
+// LoadMergeLayout reads a JSON layout file. Settings that are not given keep
+// the values of DefaultMergeLayout.
+func LoadMergeLayout(path string) (MergeLayout, error) {
+	layout := DefaultMergeLayout()
+	if path == "" {
+		return layout, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return layout, fmt.Errorf("cannot read layout: %w", err)
+	}
+	if err := json.Unmarshal(data, &layout); err != nil {
+		return layout, fmt.Errorf("invalid layout %s: %w", path, err)
+	}
+
+	return layout, nil
+}
+
+// LoadMergeData reads the rows of a CSV file with a header line or of a JSON
+// array of objects. CSV files may be separated by commas or semicolons.
+func LoadMergeData(path string) ([]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read data file: %w", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var raw []map[string]interface{}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("invalid JSON data %s: %w", path, err)
+		}
+		rows := make([]map[string]string, 0, len(raw))
+		for _, r := range raw {
+			row := make(map[string]string, len(r))
+			for k, v := range r {
+				if v != nil {
+					row[k] = fmt.Sprint(v)
+				}
+			}
+			rows = append(rows, row)
+		}
+		return rows, nil
+	}
+
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	reader := csv.NewReader(strings.NewReader(text))
+	header := strings.SplitN(text, "\n", 2)[0]
+	if strings.Count(header, ";") > strings.Count(header, ",") {
+		reader.Comma = ';'
+	}
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV data %s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("CSV data %s has no header line", path)
+	}
+
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(records[0]))
+		for i, key := range records[0] {
+			if i < len(record) {
+				row[strings.TrimSpace(key)] = strings.TrimSpace(record[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// MergeOptions controls MailMerge
+type MergeOptions struct {
+	Layout MergeLayout
+
+	// OutputDir receives one letter per row unless CombinedFile is set
+	OutputDir string
+
+	// NameTemplate names the letters using {{placeholders}} of the row.
+	// {{row}} is the 1-based, zero padded row number.
+	NameTemplate string
+
+	// CombinedFile writes all letters into a single PDF instead
+	CombinedFile string
+}
+
+// MergedLetter describes the letter generated for one data row
+type MergedLetter struct {
+	Row       int               `json:"row"`
+	File      string            `json:"file"`
+	FirstPage int               `json:"first_page"`
+	PageCount int               `json:"page_count"`
+	Fields    map[string]string `json:"fields"`
+	Warnings  []string          `json:"warnings,omitempty"`
+
+	Result *Result `json:"-"`
+}
+
+// MergeResult describes the output of MailMerge
+type MergeResult struct {
+	Template     string         `json:"template"`
+	CreatedAt    time.Time      `json:"created_at"`
+	CombinedFile string         `json:"combined_file,omitempty"`
+	ManifestFile string         `json:"-"`
+	Letters      []MergedLetter `json:"letters"`
+}
+
+// MailMerge stamps the layout filled with each row onto page 1 of the
+// template and runs the result through the margin pipeline. It writes one
+// letter per row, or a single combined file, plus a JSON manifest.
+func (p *PDFProcessor) MailMerge(templateFile string, rows []map[string]string, opts MergeOptions) (*MergeResult, error) {
+	logrus.WithFields(logrus.Fields{
+		"template": templateFile,
+		"rows":     len(rows),
+	}).Info("Starting mail merge")
+
+	font, err := LoadTrueTypeFont(opts.Layout.Font)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.NameTemplate == "" {
+		base := filepath.Base(templateFile)
+		opts.NameTemplate = strings.TrimSuffix(base, filepath.Ext(base)) + " - {{row}}"
+	}
+
+	tempDir, err := os.MkdirTemp("", "pdf2letterexpress-merge-")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The stamped text is positioned for the engine that is expected to run,
+	// mergeRow corrects it when the fallback chain ends up with another one
+	engine := p.selectEngine(&Result{})
+
+	result := &MergeResult{
+		Template:     templateFile,
+		CreatedAt:    time.Now(),
+		CombinedFile: opts.CombinedFile,
+	}
+
+	usedNames := make(map[string]bool)
+	nextPage := 1
+	for i, row := range rows {
+		fields := make(map[string]string, len(row)+1)
+		for k, v := range row {
+			fields[k] = v
+		}
+		fields["row"] = fmt.Sprintf("%03d", i+1)
+
+		letter := MergedLetter{Row: i + 1, Fields: row}
+
+		if opts.CombinedFile != "" {
+			letter.File = filepath.Join(tempDir, fmt.Sprintf("letter_%d.pdf", i+1))
+		} else {
+			letter.File = letterFilename(opts.OutputDir, opts.NameTemplate, fields, i+1, usedNames)
+		}
+
+		stampedFile := filepath.Join(tempDir, fmt.Sprintf("stamped_%d.pdf", i+1))
+		letterResult, err := p.mergeRow(templateFile, stampedFile, &engine, opts.Layout, font, fields, &letter)
+		if err != nil {
+			return result, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		letterResult.InputFile = templateFile
+		letter.Result = letterResult
+		letter.PageCount = letterResult.PageCount
+		letter.FirstPage = nextPage
+		nextPage += letter.PageCount
+
+		result.Letters = append(result.Letters, letter)
+	}
+
+	if opts.CombinedFile != "" {
+		var files []string
+		for i := range result.Letters {
+			files = append(files, result.Letters[i].File)
+			result.Letters[i].File = opts.CombinedFile
+			result.Letters[i].Result.OutputFile = opts.CombinedFile
+		}
+		if err := api.MergeCreateFile(files, opts.CombinedFile, false, p.config); err != nil {
+			return result, fmt.Errorf("%w: failed to write combined file: %w", ErrOutputNotWritable, err)
+		}
+		result.ManifestFile = strings.TrimSuffix(opts.CombinedFile, filepath.Ext(opts.CombinedFile)) + ".manifest.json"
+	} else {
+		for i := range result.Letters {
+			result.Letters[i].FirstPage = 1
+		}
+		result.ManifestFile = filepath.Join(opts.OutputDir, "manifest.json")
+	}
+
+	manifest, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return result, err
+	}
+	if err := os.WriteFile(result.ManifestFile, manifest, 0644); err != nil {
+		return result, fmt.Errorf("%w: failed to write manifest: %w", ErrOutputNotWritable, err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"letters":  len(result.Letters),
+		"manifest": result.ManifestFile,
+	}).Info("Successfully completed mail merge")
+
+	return result, nil
+}
+
+// letterFilename expands the naming template for the letter with the given
+// 1-based index. Empty and duplicate names get the index appended.
+func letterFilename(dir, nameTemplate string, fields map[string]string, index int, used map[string]bool) string {
+	name := utils.SanitizeFilename(utils.ExpandPlaceholders(nameTemplate, fields))
+	switch {
+	case name == "":
+		name = fmt.Sprintf("%03d", index)
+	case used[name]:
+		name = fmt.Sprintf("%s - %03d", name, index)
+	}
+	used[name] = true
+	return filepath.Join(dir, name+".pdf")
+}
+
+// mergeRow stamps the fields of a row onto the template for *engine and
+// converts the result into letter.File. If another engine of the fallback
+// chain produced the letter, the text would be misplaced, so the row is
+// stamped and converted again for that engine, which is kept in *engine for
+// the following rows.
+func (p *PDFProcessor) mergeRow(templateFile, stampedFile string, engine *string, layout MergeLayout, font *TrueTypeFont, fields map[string]string, letter *MergedLetter) (*Result, error) {
+	for attempt := 0; ; attempt++ {
+		letter.Warnings = nil
+		if err := p.stampMergeFields(templateFile, stampedFile, *engine, layout, font, fields, letter); err != nil {
+			return nil, err
+		}
+
+		result, err := p.Process(stampedFile, letter.File)
+		if err != nil {
+			return result, err
+		}
+		if result.Engine == *engine {
+			return result, nil
+		}
+		if attempt > 0 {
+			return result, fmt.Errorf("%w: text placed for engine %s, but the letter was produced by %s",
+				ErrEngineFailed, *engine, result.Engine)
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"expected": *engine,
+			"engine":   result.Engine,
+		}).Info("Engine fell back, placing the text again")
+		*engine = result.Engine
+	}
+}
+
+// stampMergeFields writes a copy of the template with the filled in layout on page 1
+func (p *PDFProcessor) stampMergeFields(templateFile, outputFile, engine string, layout MergeLayout, font *TrueTypeFont, fields map[string]string, letter *MergedLetter) error {
+	ctx, err := p.readContextFile(templateFile)
+	if err != nil {
+		return err
+	}
+
+	// Position the text so it lands on the layout position after conversion
+	plans, err := p.planPages(ctx, engine)
+	if err != nil {
+		return err
+	}
+
+	var items []textItem
+	fontSize := layout.FontSize
+	if fontSize <= 0 {
+		fontSize = 10
+	}
+
+	addressSize := layout.Address.FontSize
+	if addressSize <= 0 {
+		addressSize = fontSize
+	}
+	y := layout.Address.YMM
+	for _, line := range layout.Address.Lines {
+		text := strings.TrimSpace(utils.ExpandPlaceholders(line, fields))
+		if text == "" {
+			continue
+		}
+		if layout.Address.WidthMM > 0 && font.TextWidth(text, addressSize) > layout.Address.WidthMM*PointsPerMM {
+			letter.Warnings = append(letter.Warnings, fmt.Sprintf("address line %q is wider than the address field", text))
+		}
+		items = append(items, textItem{XMM: layout.Address.XMM, YMM: y, Size: addressSize, Text: text})
+		y += layout.Address.LineHeightMM
+	}
+
+	for _, field := range layout.Fields {
+		size := field.FontSize
+		if size <= 0 {
+			size = fontSize
+		}
+		text := utils.ExpandPlaceholders(field.Text, fields)
+		items = append(items, textItem{XMM: field.XMM, YMM: field.YMM, Size: size, Text: text})
+	}
+
+	if err := p.stampText(ctx, plans[0], font, items); err != nil {
+		return err
+	}
+
+	if err := api.WriteContextFile(ctx, outputFile); err != nil {
+		return fmt.Errorf("failed to write stamped template: %w", err)
+	}
+	return nil
+}
+
+// textItem is a line of text positioned in mm from the top left corner of
+// the output page; YMM is the top of the line
+type textItem struct {
+	XMM  float64
+	YMM  float64
+	Size float64
+	Text string
+}
+
+// stampText draws text items onto a source page. The items are given in
+// output page coordinates and mapped back through the inverse of the page
+// plan, so they end up at the requested position after conversion.
+func (p *PDFProcessor) stampText(ctx *model.Context, plan PagePlan, font *TrueTypeFont, items []textItem) error {
+	pageDict, _, inhPAttrs, err := ctx.PageDict(plan.Page, false)
+	if err != nil {
+		return fmt.Errorf("failed to get page dict: %w", err)
+	}
+
+	fontRef, err := font.Embed(ctx)
+	if err != nil {
+		return err
+	}
+	if err := p.addPageFont(ctx, pageDict, inhPAttrs, mergeFontName, *fontRef); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "q\n%s cm\n0 g\nBT\n", plan.transform().invert())
+	for _, item := range items {
+		baseline := plan.OutputHeight - item.YMM*PointsPerMM - float64(font.ascent)*item.Size/1000
+		fmt.Fprintf(&b, "/%s %.2f Tf\n1 0 0 1 %.2f %.2f Tm\n(%s) Tj\n", mergeFontName, item.Size, item.XMM*PointsPerMM, baseline, font.Encode(item.Text))
+	}
+	b.WriteString("ET\nQ\n")
+
+	return p.wrapPageContent(ctx, pageDict, "q\n", "\nQ\n"+b.String())
+}
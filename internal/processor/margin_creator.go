@@ -50,7 +50,7 @@ func (p *PDFProcessor) CreateMarginsWithPDFCPU(inputFile, outputFile string) err
 	// Write modified PDF
 	outputFileHandle, err := os.Create(outputFile)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return fmt.Errorf("%w: failed to create output file: %w", ErrOutputNotWritable, err)
 	}
 	defer outputFileHandle.Close()
 
@@ -277,7 +277,7 @@ func (p *PDFProcessor) CreateMarginsWithImageMagick(inputFile, outputFile string
 	_, err := exec.LookPath("convert")
 	if err != nil {
 		logrus.WithError(err).Error("ImageMagick not found")
-		return fmt.Errorf("imagemagick not available: %w", err)
+		return fmt.Errorf("%w: imagemagick not available: %w", ErrToolMissing, err)
 	}
 
 	// Determine total page count
@@ -317,7 +317,7 @@ func (p *PDFProcessor) CreateMarginsWithImageMagick(inputFile, outputFile string
 		if err != nil {
 			p.cleanupFiles(pageFiles)
 			logrus.WithError(err).WithField("output", string(output1)).Error("PDF to image conversion failed")
-			return fmt.Errorf("pdf to image conversion failed for page %d: %w", i, err)
+			return fmt.Errorf("%w: pdf to image conversion failed for page %d: %w", ErrEngineFailed, i, err)
 		}
 
 		// Step 2: Add margins to this page image
@@ -333,7 +333,7 @@ func (p *PDFProcessor) CreateMarginsWithImageMagick(inputFile, outputFile string
 		if err != nil {
 			p.cleanupFiles(pageFiles)
 			logrus.WithError(err).WithField("output", string(output2)).Error("Margin addition failed")
-			return fmt.Errorf("margin addition failed for page %d: %w", i, err)
+			return fmt.Errorf("%w: margin addition failed for page %d: %w", ErrEngineFailed, i, err)
 		}
 	}
 
@@ -349,7 +349,7 @@ func (p *PDFProcessor) CreateMarginsWithImageMagick(inputFile, outputFile string
 	p.cleanupFiles(pageFiles)
 	if err != nil {
 		logrus.WithError(err).WithField("output", string(output3)).Error("Image to PDF conversion failed")
-		return fmt.Errorf("image to pdf conversion failed: %w", err)
+		return fmt.Errorf("%w: image to pdf conversion failed: %w", ErrEngineFailed, err)
 	}
 
 	logrus.WithFields(logrus.Fields{
@@ -361,6 +361,11 @@ func (p *PDFProcessor) CreateMarginsWithImageMagick(inputFile, outputFile string
 
 // CreateMarginsForLetterXpress creates margins while maintaining exact DIN A4 format
 func (p *PDFProcessor) CreateMarginsForLetterXpress(inputFile, outputFile string) error {
+	return p.createMarginsForLetterXpress(inputFile, outputFile, p.opts.Raster)
+}
+
+// createMarginsForLetterXpress renders the pages with the given raster options
+func (p *PDFProcessor) createMarginsForLetterXpress(inputFile, outputFile string, raster RasterOptions) error {
 	logrus.WithFields(logrus.Fields{
 		"input":  inputFile,
 		"output": outputFile,
@@ -371,26 +376,26 @@ func (p *PDFProcessor) CreateMarginsForLetterXpress(inputFile, outputFile string
 	_, err := exec.LookPath("convert")
 	if err != nil {
 		logrus.WithError(err).Error("ImageMagick not found")
-		return fmt.Errorf("imagemagick not available: %w", err)
+		return fmt.Errorf("%w: imagemagick not available: %w", ErrToolMissing, err)
 	}
 
-	// Determine total page count
-	pageCount, err := api.PageCountFile(inputFile)
+	// Page count and the color mode of every page
+	ctx, err := p.readContextFile(inputFile)
 	if err != nil {
-		// Fallback: read context to get page count
-		f, openErr := os.Open(inputFile)
-		if openErr != nil {
-			return fmt.Errorf("failed to open input file: %w", openErr)
-		}
-		ctx, readErr := api.ReadContext(f, p.config)
-		f.Close()
-		if readErr != nil {
-			return fmt.Errorf("failed to determine page count: %w", readErr)
-		}
-		pageCount = ctx.PageCount
+		return err
 	}
+	pageCount := ctx.PageCount
 
-	logrus.WithField("pageCount", pageCount).Info("Processing all pages")
+	raster = raster.withDefaults()
+	modes, err := p.pageColorModes(ctx, raster)
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"pageCount": pageCount,
+		"colorMode": raster.ColorMode,
+	}).Info("Processing all pages")
 
 	// LetterXpress requires EXACT DIN A4: 210 × 297 mm
 	targetWidthMM := 210.0
@@ -400,10 +405,9 @@ func (p *PDFProcessor) CreateMarginsForLetterXpress(inputFile, outputFile string
 	contentWidthMM := targetWidthMM - (2 * MarginMM)   // 200mm
 	contentHeightMM := targetHeightMM - (2 * MarginMM) // 287mm
 
-	// Use 300 DPI for high quality
-	dpi := 300.0
+	dpi := float64(raster.DPI)
 
-	// Calculate final A4 dimensions in pixels at 300 DPI
+	// Calculate final A4 dimensions in pixels
 	finalWidthPx := int(targetWidthMM * dpi / 25.4)
 	finalHeightPx := int(targetHeightMM * dpi / 25.4)
 
@@ -425,16 +429,21 @@ func (p *PDFProcessor) CreateMarginsForLetterXpress(inputFile, outputFile string
 		"dpi":             dpi,
 	}).Info("Calculated exact DIN A4 pixel dimensions")
 
-	tempDir := filepath.Dir(outputFile)
+	// The page images are kept out of the output directory, which may be
+	// watched or shared
+	tempDir, err := os.MkdirTemp("", "pdf2letterexpress-raster-")
+	if err != nil {
+		return fmt.Errorf("cannot create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
 
-	// Collect A4 page image paths for final merge; track all temp files for cleanup
-	var pageA4Files []string
-	var allTempFiles []string
+	// Collect single page PDFs for the final merge
+	var pagePDFFiles []string
 
 	for i := 0; i < pageCount; i++ {
 		tempContentFile := filepath.Join(tempDir, fmt.Sprintf("temp_content_%d.png", i))
 		tempA4File := filepath.Join(tempDir, fmt.Sprintf("temp_a4_%d.png", i))
-		allTempFiles = append(allTempFiles, tempContentFile, tempA4File)
+		tempPDFFile := filepath.Join(tempDir, fmt.Sprintf("temp_a4_%d.pdf", i))
 
 		// Step 1: Convert this page to scaled content image
 		pageSelector := fmt.Sprintf("%s[%d]", inputFile, i)
@@ -453,9 +462,8 @@ func (p *PDFProcessor) CreateMarginsForLetterXpress(inputFile, outputFile string
 		}).Debug("Converting PDF page to scaled content")
 		output1, err := cmd1.CombinedOutput()
 		if err != nil {
-			p.cleanupFiles(allTempFiles)
 			logrus.WithError(err).WithField("output", string(output1)).Error("PDF content conversion failed")
-			return fmt.Errorf("pdf content conversion failed for page %d: %w", i, err)
+			return fmt.Errorf("%w: pdf content conversion failed for page %d: %w", ErrEngineFailed, i, err)
 		}
 
 		// Step 2: Create exact A4 white canvas and place content with margins
@@ -474,30 +482,40 @@ func (p *PDFProcessor) CreateMarginsForLetterXpress(inputFile, outputFile string
 		}).Debug("Creating A4 canvas with content")
 		output2, err := cmd2.CombinedOutput()
 		if err != nil {
-			p.cleanupFiles(allTempFiles)
 			logrus.WithError(err).WithField("output", string(output2)).Error("A4 canvas creation failed")
-			return fmt.Errorf("a4 canvas creation failed for page %d: %w", i, err)
+			return fmt.Errorf("%w: a4 canvas creation failed for page %d: %w", ErrEngineFailed, i, err)
 		}
 
-		pageA4Files = append(pageA4Files, tempA4File)
-	}
+		// Step 3: Convert the page to its color mode and compress it into a single page PDF
+		cmd3Args := []string{tempA4File}
+		cmd3Args = append(cmd3Args, rasterPageArgs(modes[i], raster)...)
+		cmd3Args = append(cmd3Args,
+			"-density", fmt.Sprintf("%.0f", dpi),
+			"-define", "pdf:page-size=a4",
+			tempPDFFile,
+		)
+		cmd3 := exec.Command("convert", cmd3Args...)
 
-	// Step 3: Combine all A4 page images into a single multi-page PDF
-	cmd3Args := append(pageA4Files,
-		"-density", fmt.Sprintf("%.0f", dpi),
-		"-compress", "jpeg",
-		"-quality", "95",
-		"-define", "pdf:page-size=a4",
-		outputFile,
-	)
-	cmd3 := exec.Command("convert", cmd3Args...)
+		logrus.WithFields(logrus.Fields{
+			"page":    i,
+			"mode":    modes[i],
+			"command": strings.Join(cmd3.Args, " "),
+		}).Debug("Compressing A4 page")
+		output3, err := cmd3.CombinedOutput()
+		if err != nil {
+			logrus.WithError(err).WithField("output", string(output3)).Error("Page PDF creation failed")
+			return fmt.Errorf("%w: pdf creation failed for page %d: %w", ErrEngineFailed, i, err)
+		}
 
-	logrus.WithField("command", strings.Join(cmd3.Args, " ")).Debug("Combining all pages into final A4 PDF")
-	output3, err := cmd3.CombinedOutput()
-	p.cleanupFiles(allTempFiles)
+		pagePDFFiles = append(pagePDFFiles, tempPDFFile)
+	}
+
+	// Step 4: Combine all pages into a single multi-page PDF
+	logrus.WithField("pages", len(pagePDFFiles)).Debug("Combining all pages into final A4 PDF")
+	err = api.MergeCreateFile(pagePDFFiles, outputFile, false, p.config)
 	if err != nil {
-		logrus.WithError(err).WithField("output", string(output3)).Error("Final PDF creation failed")
-		return fmt.Errorf("final pdf creation failed: %w", err)
+		logrus.WithError(err).Error("Final PDF creation failed")
+		return fmt.Errorf("%w: final pdf creation failed: %w", ErrEngineFailed, err)
 	}
 
 	logrus.WithFields(logrus.Fields{
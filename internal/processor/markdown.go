@@ -0,0 +1,291 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// textStyle selects one of the fonts of a composed letter
+type textStyle int
+
+const (
+	styleRegular textStyle = iota
+	styleBold
+	styleItalic
+	styleBoldItalic
+)
+
+// textSpan is a piece of text in one style. A newline is a line break.
+type textSpan struct {
+	text  string
+	style textStyle
+}
+
+// textBlock is a paragraph, heading or list item of a letter body
+type textBlock struct {
+	spans   []textSpan
+	heading bool
+	// marker is the bullet or number of a list item
+	marker string
+	quote  bool
+}
+
+var (
+	headingPattern  = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*$`)
+	listPattern     = regexp.MustCompile(`^([-*+]|\d{1,3}[.)])\s+(.*)$`)
+	rulePattern     = regexp.MustCompile(`^(-{3,}|\*{3,}|_{3,})$`)
+	linkPattern     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	frontMatterLine = []byte("---")
+)
+
+// splitFrontMatter returns the YAML front matter between two "---" lines
+// at the start of data, if any, and the rest of data
+func splitFrontMatter(data []byte) ([]byte, []byte) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines) == 0 || !bytes.Equal(bytes.TrimSpace(lines[0]), frontMatterLine) {
+		return nil, data
+	}
+	offset := len(lines[0])
+	for _, line := range lines[1:] {
+		if bytes.Equal(bytes.TrimSpace(line), frontMatterLine) {
+			return data[len(lines[0]):offset], data[offset+len(line):]
+		}
+		offset += len(line)
+	}
+	return nil, data
+}
+
+// parseFrontMatter fills in the fields of letter that the YAML front matter
+// sets and that are still empty
+func parseFrontMatter(front []byte, letter *Letter) error {
+	var fm struct {
+		Sender    addressLines `yaml:"sender"`
+		Recipient addressLines `yaml:"recipient"`
+		Subject   string       `yaml:"subject"`
+		Date      string       `yaml:"date"`
+	}
+	if err := yaml.Unmarshal(front, &fm); err != nil {
+		return fmt.Errorf("invalid front matter: %w", err)
+	}
+	if len(letter.Sender) == 0 {
+		letter.Sender = fm.Sender
+	}
+	if len(letter.Recipient) == 0 {
+		letter.Recipient = fm.Recipient
+	}
+	if letter.Subject == "" {
+		letter.Subject = fm.Subject
+	}
+	if letter.Date == "" {
+		letter.Date = fm.Date
+	}
+	return nil
+}
+
+// addressLines are the lines of an address, given in YAML as a list or as
+// a string with one line per line
+type addressLines []string
+
+func (a *addressLines) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*a = splitLines(node.Value)
+		return nil
+	}
+	var lines []string
+	if err := node.Decode(&lines); err != nil {
+		return err
+	}
+	*a = lines
+	return nil
+}
+
+// splitLines returns the non-empty lines of s without surrounding spaces
+func splitLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parseMarkdown returns the blocks of a Markdown body. It understands
+// paragraphs, headings, lists, block quotes, hard line breaks, emphasis and
+// links, which are printed with their address.
+func parseMarkdown(body string) []textBlock {
+	var blocks []textBlock
+	var current *textBlock
+	var lines []string
+
+	flush := func() {
+		if current != nil && len(lines) > 0 {
+			style := styleRegular
+			if current.heading {
+				style = styleBold
+			} else if current.quote {
+				style = styleItalic
+			}
+			current.spans = parseInline(joinMarkdownLines(lines), style)
+			blocks = append(blocks, *current)
+		}
+		current, lines = nil, nil
+	}
+
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "" || rulePattern.MatchString(trimmed):
+			flush()
+		case headingPattern.MatchString(trimmed):
+			flush()
+			current = &textBlock{heading: true}
+			lines = []string{headingPattern.FindStringSubmatch(trimmed)[1]}
+			flush()
+		case listPattern.MatchString(trimmed):
+			flush()
+			m := listPattern.FindStringSubmatch(trimmed)
+			marker := m[1]
+			if strings.ContainsAny(marker, "-*+") {
+				marker = "•"
+			}
+			current = &textBlock{marker: marker}
+			lines = []string{m[2]}
+		case strings.HasPrefix(trimmed, ">"):
+			if current == nil || !current.quote {
+				flush()
+				current = &textBlock{quote: true}
+			}
+			lines = append(lines, strings.TrimPrefix(strings.TrimPrefix(trimmed, ">"), " ")+trailingBreak(line))
+		default:
+			if current == nil {
+				current = &textBlock{}
+			}
+			lines = append(lines, trimmed+trailingBreak(line))
+		}
+	}
+	flush()
+	return blocks
+}
+
+// trailingBreak keeps the two trailing spaces that mark a hard line break
+func trailingBreak(line string) string {
+	if strings.HasSuffix(line, "  ") {
+		return "  "
+	}
+	return ""
+}
+
+// joinMarkdownLines joins the lines of a paragraph with spaces, or with a
+// line break after lines that end in two spaces or a backslash
+func joinMarkdownLines(lines []string) string {
+	var b strings.Builder
+	for i, line := range lines {
+		hard := strings.HasSuffix(line, "  ") || strings.HasSuffix(line, "\\")
+		b.WriteString(strings.TrimSuffix(strings.TrimSpace(line), "\\"))
+		if i < len(lines)-1 {
+			if hard {
+				b.WriteString("\n")
+			} else {
+				b.WriteString(" ")
+			}
+		}
+	}
+	return b.String()
+}
+
+// parseInline splits Markdown text into spans by emphasis. Code spans are
+// printed as text and links as "text (address)".
+func parseInline(s string, base textStyle) []textSpan {
+	s = linkPattern.ReplaceAllStringFunc(s, func(link string) string {
+		m := linkPattern.FindStringSubmatch(link)
+		if m[1] == m[2] {
+			return m[1]
+		}
+		return fmt.Sprintf("%s (%s)", m[1], m[2])
+	})
+
+	bold := base == styleBold || base == styleBoldItalic
+	italic := base == styleItalic || base == styleBoldItalic
+	var spans []textSpan
+	var text strings.Builder
+	emit := func() {
+		if text.Len() > 0 {
+			spans = append(spans, textSpan{text: text.String(), style: spanStyle(bold, italic)})
+			text.Reset()
+		}
+	}
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && i+1 < len(runes) && unicode.IsPunct(runes[i+1]):
+			i++
+			text.WriteRune(runes[i])
+		case r == '`':
+		case (r == '*' || r == '_') && i+1 < len(runes) && runes[i+1] == r:
+			emit()
+			bold = !bold
+			i++
+		case r == '*' || (r == '_' && emphasisBoundary(runes, i)):
+			emit()
+			italic = !italic
+		default:
+			text.WriteRune(r)
+		}
+	}
+	emit()
+	return spans
+}
+
+// emphasisBoundary reports whether the underscore at i starts or ends a
+// word, unlike one within a word such as a file name
+func emphasisBoundary(runes []rune, i int) bool {
+	before := i == 0 || !unicode.IsLetter(runes[i-1]) && !unicode.IsDigit(runes[i-1])
+	after := i == len(runes)-1 || !unicode.IsLetter(runes[i+1]) && !unicode.IsDigit(runes[i+1])
+	return before || after
+}
+
+func spanStyle(bold, italic bool) textStyle {
+	switch {
+	case bold && italic:
+		return styleBoldItalic
+	case bold:
+		return styleBold
+	case italic:
+		return styleItalic
+	}
+	return styleRegular
+}
+
+// parsePlainText returns the paragraphs of a text body, which are
+// separated by blank lines. Line breaks within a paragraph are kept.
+func parsePlainText(body string) []textBlock {
+	var blocks []textBlock
+	var lines []string
+	flush := func() {
+		if len(lines) > 0 {
+			blocks = append(blocks, textBlock{spans: []textSpan{{text: strings.Join(lines, "\n")}}})
+		}
+		lines = nil
+	}
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			flush()
+			continue
+		}
+		lines = append(lines, line)
+	}
+	flush()
+	return blocks
+}
@@ -0,0 +1,157 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
+)
+
+// DefaultOfficeTimeout is the time LibreOffice gets to convert a document
+const DefaultOfficeTimeout = 2 * time.Minute
+
+// officeCommands are the names LibreOffice is installed under
+var officeCommands = []string{"soffice", "libreoffice"}
+
+// openConverted converts image and office inputs into a PDF, see openImage
+// and openOffice. PDFs are returned unchanged unless pdfcpu cannot read
+// them, see openUnreadable.
+func (p *PDFProcessor) openConverted(inputFile, dir string, result *Result) (string, func(), error) {
+	switch {
+	case utils.IsImageFile(inputFile):
+		return p.openImage(inputFile, dir, result)
+	case utils.IsOfficeFile(inputFile):
+		return p.openOffice(inputFile)
+	}
+	return p.openUnreadable(inputFile, dir, result)
+}
+
+// openOffice converts a Word, OpenDocument, RTF or text document into a PDF
+// with a local LibreOffice. The PDF and LibreOffice's profile are kept in a
+// private temporary directory that the returned function removes.
+func (p *PDFProcessor) openOffice(inputFile string) (string, func(), error) {
+	noop := func() {}
+
+	command, err := officeCommand()
+	if err != nil {
+		return inputFile, noop, err
+	}
+
+	workDir, err := os.MkdirTemp("", "pdf2letterexpress-office-")
+	if err != nil {
+		return inputFile, noop, fmt.Errorf("cannot create temporary directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(workDir) }
+
+	timeout := p.opts.OfficeTimeout
+	if timeout <= 0 {
+		timeout = DefaultOfficeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// A profile of its own keeps LibreOffice from handing the document to a
+	// running instance of the user
+	profile := &url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(workDir, "profile"))}
+	args := []string{"--headless", "--norestore", "--nolockcheck",
+		"-env:UserInstallation=" + profile.String(),
+		"--convert-to", "pdf", "--outdir", workDir}
+	if strings.EqualFold(filepath.Ext(inputFile), ".txt") {
+		args = append(args, "--infilter=Text (encoded):UTF8,LF,,,")
+	}
+	args = append(args, inputFile)
+
+	logrus.WithFields(logrus.Fields{
+		"input":   inputFile,
+		"command": command,
+		"timeout": timeout,
+	}).Info("Converting office document with LibreOffice")
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.WaitDelay = 5 * time.Second
+	output, err := cmd.CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		cleanup()
+		return inputFile, noop, fmt.Errorf("%w: LibreOffice did not finish within %s", ErrEngineFailed, timeout)
+	}
+	if err != nil {
+		cleanup()
+		return inputFile, noop, fmt.Errorf("%w: LibreOffice failed: %w: %s", ErrEngineFailed, err, strings.TrimSpace(string(output)))
+	}
+
+	// LibreOffice exits successfully even if it could not read the document
+	name := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile)) + ".pdf"
+	pdfFile := filepath.Join(workDir, name)
+	if _, err := os.Stat(pdfFile); err != nil {
+		cleanup()
+		return inputFile, noop, fmt.Errorf("%w: LibreOffice could not convert the document: %s", ErrUnreadableInput, strings.TrimSpace(string(output)))
+	}
+
+	logrus.WithField("input", inputFile).Debug("Converted office document")
+	return pdfFile, cleanup, nil
+}
+
+// officeCommand returns the path of the LibreOffice executable
+func officeCommand() (string, error) {
+	for _, name := range officeCommands {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%w: LibreOffice not available, install it or put %s on the PATH", ErrToolMissing, officeCommands[0])
+}
+
+// Tool is an external program the conversion can use
+type Tool struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	Purpose string `json:"purpose"`
+	Path    string `json:"path,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// Found reports whether the tool is installed
+func (t Tool) Found() bool {
+	return t.Path != ""
+}
+
+// Tools looks up the external programs the conversion can use and their versions
+func Tools() []Tool {
+	return []Tool{
+		findTool("ImageMagick", "raster engine, preview, rasterizing fonts and transparency", []string{"convert"}, "-version"),
+		findTool("Ghostscript", "--force-grayscale", []string{"gs"}, "--version"),
+		findTool("pdftotext", "text extraction of split --pattern", []string{"pdftotext"}, "-v"),
+		findTool("LibreOffice", "DOCX, ODT, RTF and TXT inputs", officeCommands, "--version"),
+	}
+}
+
+// findTool looks up the first of commands that is installed
+func findTool(name, purpose string, commands []string, versionArgs ...string) Tool {
+	tool := Tool{Name: name, Command: commands[0], Purpose: purpose}
+	for _, command := range commands {
+		if path, err := exec.LookPath(command); err == nil {
+			tool.Path = path
+			tool.Version = toolVersion(path, versionArgs...)
+			break
+		}
+	}
+	return tool
+}
+
+// toolVersion returns the first line a tool prints for its version
+func toolVersion(path string, args ...string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	output, _ := exec.CommandContext(ctx, path, args...).CombinedOutput()
+	line, _, _ := bytes.Cut(bytes.TrimSpace(output), []byte("\n"))
+	return strings.TrimSpace(string(line))
+}
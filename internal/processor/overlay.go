@@ -0,0 +1,178 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/sirupsen/logrus"
+)
+
+// debugFontName is the resource name of the font used for overlay labels
+const debugFontName = "PDF2LXDebug"
+
+// WriteDebugOverlay writes an annotated copy of convertedFile to overlayFile.
+// Every page gets thin vector guides for the 5mm no-print border, the
+// DIN 5008 address window and the fold marks, plus a label with the applied
+// scale factor taken from pages. sourcePages maps the output pages to the
+// input pages of the plans, see Result.SourcePages; nil pairs them by
+// number. The copy is for inspection only and must never be sent.
+func (p *PDFProcessor) WriteDebugOverlay(convertedFile, overlayFile string, pages []PagePlan, sourcePages []int) error {
+	logrus.WithFields(logrus.Fields{
+		"input":  convertedFile,
+		"output": overlayFile,
+	}).Info("Writing debug overlay copy")
+
+	ctx, err := p.readContextFile(convertedFile)
+	if err != nil {
+		return err
+	}
+
+	plans := make(map[int]PagePlan, len(pages))
+	for _, plan := range pages {
+		plans[plan.Page] = plan
+	}
+
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		source := pageNr
+		if sourcePages != nil {
+			source = 0
+			if pageNr <= len(sourcePages) {
+				source = sourcePages[pageNr-1]
+			}
+		}
+
+		label := fmt.Sprintf("page %d", pageNr)
+		if source != pageNr {
+			label = fmt.Sprintf("page %d (input page %d)", pageNr, source)
+		}
+		if plan, ok := plans[source]; ok {
+			label = fmt.Sprintf("%s  scale %.4f x %.4f  offset %.2f/%.2f mm", label,
+				plan.ScaleX, plan.ScaleY, plan.OffsetX/PointsPerMM, plan.OffsetY/PointsPerMM)
+		} else if source == 0 {
+			label = fmt.Sprintf("page %d  blank page inserted for duplex", pageNr)
+		}
+
+		if err := p.addDebugOverlay(ctx, pageNr, label); err != nil {
+			return fmt.Errorf("failed to add debug overlay to page %d: %w", pageNr, err)
+		}
+	}
+
+	f, err := os.Create(overlayFile)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create debug overlay file: %w", ErrOutputNotWritable, err)
+	}
+	defer f.Close()
+
+	if err := api.WriteContext(ctx, f); err != nil {
+		return fmt.Errorf("failed to write debug overlay: %w", err)
+	}
+
+	logrus.WithField("output", overlayFile).Info("Successfully created debug overlay copy")
+	return nil
+}
+
+// addDebugOverlay draws the guides on top of the existing page content
+func (p *PDFProcessor) addDebugOverlay(ctx *model.Context, pageNr int, label string) error {
+	pageDict, _, inhPAttrs, err := ctx.PageDict(pageNr, false)
+	if err != nil {
+		return fmt.Errorf("failed to get page dict: %w", err)
+	}
+
+	helvetica := types.Dict{
+		"Type":     types.Name("Font"),
+		"Subtype":  types.Name("Type1"),
+		"BaseFont": types.Name("Helvetica"),
+		"Encoding": types.Name("WinAnsiEncoding"),
+	}
+	if err := p.addPageFont(ctx, pageDict, inhPAttrs, debugFontName, helvetica); err != nil {
+		return err
+	}
+
+	height := A4HeightPoints
+	if inhPAttrs != nil && inhPAttrs.MediaBox != nil {
+		height = inhPAttrs.MediaBox.Height()
+	}
+
+	// Isolate the existing content so its graphics state cannot affect the guides
+	return p.wrapPageContent(ctx, pageDict, "q\n", "\nQ\n"+debugOverlayContent(height, label))
+}
+
+// debugOverlayContent returns the content stream operators for the guides on
+// an A4 page of the given height
+func debugOverlayContent(height float64, label string) string {
+	mm := func(v float64) float64 { return v * PointsPerMM }
+	// Convert a distance from the top edge into PDF user space
+	fromTop := func(v float64) float64 { return height - mm(v) }
+
+	var b strings.Builder
+	b.WriteString("q\n0.3 w\n")
+
+	// 5mm no-print border
+	b.WriteString("1 0 0 RG\n")
+	fmt.Fprintf(&b, "%.2f %.2f %.2f %.2f re S\n", MarginPoints, MarginPoints, A4WidthPoints-2*MarginPoints, height-2*MarginPoints)
+
+	// DIN 5008 address window with the return address line
+	b.WriteString("0 0 1 RG\n")
+	fmt.Fprintf(&b, "%.2f %.2f %.2f %.2f re S\n",
+		mm(AddressWindowLeftMM), fromTop(AddressWindowTopMM+AddressWindowHeightMM),
+		mm(AddressWindowWidthMM), mm(AddressWindowHeightMM))
+	fmt.Fprintf(&b, "%.2f %.2f m %.2f %.2f l S\n",
+		mm(AddressWindowLeftMM), fromTop(AddressWindowTopMM+ReturnAddressHeightMM),
+		mm(AddressWindowLeftMM+AddressWindowWidthMM), fromTop(AddressWindowTopMM+ReturnAddressHeightMM))
+
+	// Fold marks and punch mark on the left edge
+	b.WriteString("0 0.6 0 RG\n")
+	for _, mark := range []float64{FoldMarkTopMM, PunchMarkMM, FoldMarkBottomMM} {
+		fmt.Fprintf(&b, "0 %.2f m %.2f %.2f l S\n", fromTop(mark), mm(10), fromTop(mark))
+	}
+
+	// Label with the applied transformation
+	b.WriteString("1 0 0 rg\n")
+	fmt.Fprintf(&b, "BT /%s 6 Tf %.2f %.2f Td (%s) Tj ET\n", debugFontName, mm(6), mm(1.5), escapePDFString(label))
+
+	b.WriteString("Q\n")
+	return b.String()
+}
+
+// addPageFont adds a font dictionary or a reference to one under name to the page resources
+func (p *PDFProcessor) addPageFont(ctx *model.Context, pageDict types.Dict, inhPAttrs *model.InheritedPageAttrs, name string, fontDict types.Object) error {
+	resources := types.Dict{}
+	if obj, found := pageDict.Find("Resources"); found && obj != nil {
+		d, err := ctx.DereferenceDict(obj)
+		if err != nil {
+			return fmt.Errorf("failed to resolve page resources: %w", err)
+		}
+		if d != nil {
+			resources = d.Clone().(types.Dict)
+		}
+	} else if inhPAttrs != nil && inhPAttrs.Resources != nil {
+		resources = inhPAttrs.Resources.Clone().(types.Dict)
+	}
+
+	fonts := types.Dict{}
+	if obj, found := resources.Find("Font"); found && obj != nil {
+		d, err := ctx.DereferenceDict(obj)
+		if err != nil {
+			return fmt.Errorf("failed to resolve font resources: %w", err)
+		}
+		if d != nil {
+			fonts = d.Clone().(types.Dict)
+		}
+	}
+
+	fonts.Update(name, fontDict)
+	resources.Update("Font", fonts)
+	pageDict.Update("Resources", resources)
+
+	return nil
+}
+
+// escapePDFString escapes characters with a special meaning in PDF literal strings
+func escapePDFString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`)
+	return r.Replace(s)
+}
@@ -1,14 +1,23 @@
 package processor
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
 	"github.com/sirupsen/logrus"
+
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
 )
 
 const (
@@ -19,27 +28,474 @@ const (
 
 type PDFProcessor struct {
 	config *model.Configuration
+	opts   Options
+}
+
+// Options controls optional processing steps
+type Options struct {
+	// Duplex pads the letter to an even page count and lets every section
+	// start on a front side
+	Duplex bool
+
+	// SectionStarts are 1-based input pages that must start on a front side
+	// in duplex mode, e.g. the first page of each attachment
+	SectionStarts []int
+
+	// RemoveBlankPages drops pages without visible content
+	RemoveBlankPages bool
+
+	// RasterBlankDetection additionally renders pages to find near-white
+	// pages, such as empty scans, that still have content
+	RasterBlankDetection bool
+
+	// ForceGrayscale converts the output so that it prints black-and-white
+	ForceGrayscale bool
+
+	// Raster controls color mode, resolution and compression of the raster engine
+	Raster RasterOptions
+
+	// MaxSize is the size limit of the output in bytes, 0 for none. The
+	// raster engine lowers quality step by step to stay within it.
+	MaxSize int64
+
+	// FixFonts is FixFontsEmbed or FixFontsRasterize to fix fonts that are
+	// not embedded and Type 3 fonts, empty to only report them
+	FixFonts string
+
+	// FontDir is searched for substitute fonts, DefaultFontDir if not set
+	FontDir string
+
+	// FlattenTransparency rasterizes the pages of the vector engine's output
+	// that use transparency, which printers may render differently
+	FlattenTransparency bool
+
+	// Sanitize removes JavaScript, actions, embedded files, links and
+	// metadata from the output
+	Sanitize bool
+
+	// AutoCrop removes dark scanner borders around image inputs
+	AutoCrop bool
+
+	// OfficeTimeout limits the conversion of office documents by
+	// LibreOffice, DefaultOfficeTimeout if not set
+	OfficeTimeout time.Duration
+
+	// ArchiveLevel is the conformance level of the archival copy written
+	// after conversion, if any. E-invoice XML needs ArchivePDFA3B.
+	ArchiveLevel string
+
+	// Password opens encrypted inputs. It is tried as user and as owner password.
+	Password string
+
+	// Postage describes paper and envelope for the postage estimate. Its
+	// Duplex setting is taken from Duplex.
+	Postage PostageOptions
 }
 
 func NewPDFProcessor() *PDFProcessor {
+	return NewPDFProcessorWithOptions(Options{})
+}
+
+// NewPDFProcessorWithOptions creates a processor with optional processing steps enabled
+func NewPDFProcessorWithOptions(opts Options) *PDFProcessor {
 	config := model.NewDefaultConfiguration()
 
 	config.ValidationMode = model.ValidationRelaxed
+	config.UserPW = opts.Password
+	config.OwnerPW = opts.Password
 
 	return &PDFProcessor{
 		config: config,
+		opts:   opts,
 	}
 }
 
 func (p *PDFProcessor) ProcessPDF(inputFile, outputFile string) error {
+	_, err := p.Process(inputFile, outputFile)
+	return err
+}
+
+// Process converts inputFile into outputFile and returns what was done
+func (p *PDFProcessor) Process(inputFile, outputFile string) (*Result, error) {
+	return p.process(inputFile, outputFile, p.opts)
+}
+
+func (p *PDFProcessor) process(inputFile, outputFile string, opts Options) (*Result, error) {
 	logrus.WithFields(logrus.Fields{
 		"input":  inputFile,
 		"output": outputFile,
 		"margin": fmt.Sprintf("%.1fmm", MarginMM),
 	}).Debug("Processing PDF file")
 
+	result := &Result{
+		InputFile:  inputFile,
+		OutputFile: outputFile,
+		StartedAt:  time.Now(),
+	}
+	defer func() { result.FinishedAt = time.Now() }()
+
+	inputFile, cleanup, err := p.openInput(inputFile, filepath.Dir(outputFile), result)
+	if err != nil {
+		return result, err
+	}
+	defer cleanup()
+
+	// Annotations and form fields are merged into the content before any engine runs
+	inputFile, cleanupFlattened, err := p.flattenInput(inputFile, filepath.Dir(outputFile), result)
+	if err != nil {
+		return result, err
+	}
+	defer cleanupFlattened()
+
+	ctx, err := p.readContextFile(inputFile)
+	if err != nil {
+		return result, err
+	}
+	result.PageCount = ctx.PageCount
+
+	if err := p.readInputInvoice(ctx, opts, result); err != nil {
+		return result, err
+	}
+
 	// Use a simpler approach: NUp with 1 page per sheet, scaled down to create margins
-	return p.addMarginsWithNUp(inputFile, outputFile)
+	if err := p.addMarginsWithNUp(inputFile, outputFile, ctx.PageCount, result); err != nil {
+		return result, err
+	}
+
+	result.MaxSize = opts.MaxSize
+	if err := p.fitOutputSize(inputFile, outputFile, opts.MaxSize, result); err != nil {
+		return result, discardOversized(outputFile, err)
+	}
+	if len(result.SizeAttempts) > 1 {
+		if err := p.verifyOutput(outputFile, ctx.PageCount); err != nil {
+			return result, err
+		}
+	}
+
+	if err := p.checkFonts(outputFile, opts, result); err != nil {
+		return result, err
+	}
+	if len(result.EmbeddedFonts) > 0 || len(result.RasterizedPages) > 0 {
+		if err := p.verifyOutput(outputFile, ctx.PageCount); err != nil {
+			return result, err
+		}
+	}
+
+	if err := p.checkTransparency(outputFile, opts, result); err != nil {
+		return result, err
+	}
+	if result.TransparencyFlattened {
+		if err := p.verifyOutput(outputFile, ctx.PageCount); err != nil {
+			return result, err
+		}
+	}
+
+	if opts.ForceGrayscale {
+		if err := p.convertToGrayscale(outputFile); err != nil {
+			return result, err
+		}
+		result.Grayscale = true
+		if err := p.verifyOutput(outputFile, ctx.PageCount); err != nil {
+			return result, err
+		}
+	}
+
+	pages, err := p.planPages(ctx, result.Engine)
+	if err != nil {
+		result.warn("could not determine page placement: %v", err)
+	}
+	p.planColorModes(ctx, pages, result)
+	result.Pages = pages
+
+	if err := p.applyDuplex(ctx, inputFile, outputFile, opts, result); err != nil {
+		return result, err
+	}
+	p.estimatePostage(opts, result)
+
+	if opts.Sanitize {
+		if err := p.sanitizeOutput(outputFile, result); err != nil {
+			return result, err
+		}
+	}
+	if result.Invoice != nil {
+		if err := p.attachInvoice(outputFile, result.Invoice); err != nil {
+			return result, err
+		}
+	}
+
+	outCtx, err := p.readContextFile(outputFile)
+	if err != nil {
+		return result, err
+	}
+	if result.ColorPages, err = p.findColorPages(outCtx, outputFile, true, result); err != nil {
+		result.warn("could not detect color pages: %v", err)
+	}
+
+	if err := ensureNotEncrypted(outputFile); err != nil {
+		return result, err
+	}
+
+	// The steps after fitOutputSize can grow the output, so the limit is
+	// checked on the finished letter
+	if err := checkOutputSize(outputFile, opts.MaxSize); err != nil {
+		return result, discardOversized(outputFile, err)
+	}
+
+	return result, nil
+}
+
+// verifyOutput checks that the output has all pages in exact DIN A4 format
+func (p *PDFProcessor) verifyOutput(outputFile string, pageCount int) error {
+	dims, err := api.PageDimsFile(outputFile)
+	if err != nil {
+		return fmt.Errorf("%w: cannot read output: %w", ErrNonCompliant, err)
+	}
+
+	if len(dims) != pageCount {
+		return fmt.Errorf("%w: output has %d pages, input has %d", ErrNonCompliant, len(dims), pageCount)
+	}
+
+	// Allow for rounding of raster page sizes to whole pixels
+	const tolerance = 1.0
+	for i, dim := range dims {
+		if math.Abs(dim.Width-A4WidthPoints) > tolerance || math.Abs(dim.Height-A4HeightPoints) > tolerance {
+			return fmt.Errorf("%w: page %d is %.1f × %.1f mm, expected %.0f × %.0f mm", ErrNonCompliant,
+				i+1, dim.Width/PointsPerMM, dim.Height/PointsPerMM, A4WidthMM, A4HeightMM)
+		}
+	}
+
+	return nil
+}
+
+// Plan computes what Process would do for inputFile without writing
+// anything or invoking external tools. Office documents and PDFs that only
+// ImageMagick can render are not converted, so their pages are not planned.
+func (p *PDFProcessor) Plan(inputFile, outputFile string) (*Result, error) {
+	return p.plan(inputFile, outputFile, p.opts)
+}
+
+func (p *PDFProcessor) plan(inputFile, outputFile string, opts Options) (*Result, error) {
+	result := &Result{
+		InputFile:  inputFile,
+		OutputFile: outputFile,
+		DryRun:     true,
+		StartedAt:  time.Now(),
+	}
+	defer func() { result.FinishedAt = time.Now() }()
+
+	ctx, err := p.planContext(inputFile, result)
+	if err != nil || ctx == nil {
+		return result, err
+	}
+	result.PageCount = ctx.PageCount
+	if _, err := p.flattenAnnotations(ctx, result); err != nil {
+		return result, err
+	}
+	if err := p.readInputInvoice(ctx, opts, result); err != nil {
+		return result, err
+	}
+	result.Engine = p.selectEngine(result)
+	if result.Engine == EngineImageMagickA4 {
+		result.Raster = p.opts.Raster.withDefaults()
+	}
+	result.MaxSize = opts.MaxSize
+
+	pages, err := p.planPages(ctx, result.Engine)
+	if err != nil {
+		return result, err
+	}
+	p.planColorModes(ctx, pages, result)
+	result.Pages = pages
+
+	for _, plan := range pages {
+		logrus.WithFields(logrus.Fields{
+			"page":       plan.Page,
+			"rotation":   plan.Rotation,
+			"scaleX":     plan.ScaleX,
+			"scaleY":     plan.ScaleY,
+			"translateX": plan.OffsetX,
+			"translateY": plan.OffsetY,
+		}).Debug("Planned page transformation")
+	}
+
+	// Only the vector engine passes fonts, layers and transparency of the input through
+	if result.Engine == EnginePDFCPU {
+		if result.FontIssues, err = p.findFontIssues(ctx); err != nil {
+			return result, err
+		}
+		for _, issue := range result.FontIssues {
+			result.warn("%s", issue)
+		}
+
+		_, layers, err := readOptionalContent(ctx)
+		if err != nil {
+			return result, err
+		}
+		recordLayers(layers, result)
+		recordTransparency(transparentPages(ctx), opts, result)
+	}
+
+	// What the input carries that would be removed from the output
+	if opts.Sanitize {
+		if result.Sanitized, err = sanitize(ctx); err != nil {
+			return result, err
+		}
+	}
+
+	// Raster blank page detection needs ImageMagick and is left out here
+	opts.RasterBlankDetection = false
+	if _, err := p.planDuplex(ctx, inputFile, opts, result); err != nil {
+		return result, err
+	}
+	p.estimatePostage(opts, result)
+
+	// Without rendering, pages with color images can only be guessed
+	if result.ColorPages, err = p.findColorPages(ctx, inputFile, false, result); err != nil {
+		result.warn("could not detect color pages: %v", err)
+	}
+	if opts.ForceGrayscale {
+		result.Grayscale = true
+		result.ColorPages = []int{}
+	}
+
+	return result, nil
+}
+
+// planContext returns the pdfcpu context a plan is made from. Image inputs
+// are planned from a PDF of them in memory. It returns nil without an error
+// for inputs that need an external tool to be converted, after selecting
+// the engine and warning that the pages are unknown.
+func (p *PDFProcessor) planContext(inputFile string, result *Result) (*model.Context, error) {
+	if utils.IsImageFile(inputFile) {
+		images, err := readImages(inputFile)
+		if err != nil {
+			return nil, err
+		}
+		return p.imagePDF(images, result)
+	}
+
+	unknownPages := func(reason string) (*model.Context, error) {
+		result.warn("the pages are only known after %s, they are not planned", reason)
+		result.Engine = p.selectEngine(result)
+		return nil, nil
+	}
+	if utils.IsOfficeFile(inputFile) {
+		return unknownPages("LibreOffice converted the document")
+	}
+
+	enc, err := p.inspectEncryption(inputFile)
+	if errors.Is(err, ErrInvalidPDF) && !errors.Is(err, ErrEncrypted) {
+		if _, lookErr := exec.LookPath("convert"); lookErr == nil {
+			result.warn("pdfcpu cannot read the input: %v", err)
+			return unknownPages("ImageMagick rendered it")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	recordEncryption(enc, result)
+
+	return p.readContextFile(inputFile)
+}
+
+// planColorModes records the color mode of every page rendered by the
+// imagemagick-a4 engine with result.Raster. The other engines ignore the
+// raster options.
+func (p *PDFProcessor) planColorModes(ctx *model.Context, pages []PagePlan, result *Result) {
+	if result.Engine != EngineImageMagickA4 {
+		if mode := p.opts.Raster.withDefaults().ColorMode; mode != ColorModeColor {
+			result.warn("color mode %s is not supported by engine %s", mode, result.Engine)
+		}
+		return
+	}
+
+	modes, err := p.pageColorModes(ctx, result.Raster)
+	if err != nil {
+		result.warn("could not determine color modes: %v", err)
+		return
+	}
+	for i := range pages {
+		if i < len(modes) {
+			pages[i].ColorMode = modes[i]
+		}
+	}
+}
+
+// selectEngine returns the first engine of the fallback chain whose tools are available
+func (p *PDFProcessor) selectEngine(result *Result) string {
+	if _, err := exec.LookPath("convert"); err == nil {
+		return EngineImageMagickA4
+	}
+	result.warn("engine %s unavailable: ImageMagick not found", EngineImageMagickA4)
+	result.warn("engine %s unavailable: ImageMagick not found", EngineImageMagickBorder)
+	return EnginePDFCPU
+}
+
+// readContextFile reads the pdfcpu context of a PDF file
+func (p *PDFProcessor) readContextFile(inputFile string) (*model.Context, error) {
+	f, err := os.Open(inputFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %w", utils.ErrInputNotFound, err)
+		}
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer f.Close()
+
+	ctx, err := api.ReadContext(f, p.config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read PDF context: %w", classifyReadError(err, p.opts.Password), err)
+	}
+
+	if err := ctx.EnsurePageCount(); err != nil {
+		return nil, fmt.Errorf("%w: failed to determine page count: %w", ErrInvalidPDF, err)
+	}
+	// Every context read here may be written again
+	if err := decodeObjectStreams(ctx); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPDF, err)
+	}
+
+	return ctx, nil
+}
+
+// openUnreadable renders a PDF that pdfcpu cannot read into a raster copy
+// in dir with ImageMagick, which still renders many damaged files, so that
+// the rest of the processing works on the copy. Readable and encrypted PDFs
+// are returned unchanged. The returned cleanup function removes the copy.
+func (p *PDFProcessor) openUnreadable(inputFile, dir string, result *Result) (string, func(), error) {
+	noop := func() {}
+
+	_, readErr := p.readContextFile(inputFile)
+	if !errors.Is(readErr, ErrInvalidPDF) || errors.Is(readErr, ErrEncrypted) {
+		return inputFile, noop, nil
+	}
+	if _, err := exec.LookPath("convert"); err != nil {
+		return inputFile, noop, readErr
+	}
+
+	f, err := os.CreateTemp(dir, ".pdf2letterexpress-rendered-*.pdf")
+	if err != nil {
+		return inputFile, noop, fmt.Errorf("%w: cannot create rendered copy: %w", ErrOutputNotWritable, err)
+	}
+	f.Close()
+	renderedFile := f.Name()
+	cleanup := func() { os.Remove(renderedFile) }
+
+	raster := p.opts.Raster.withDefaults()
+	cmd := exec.Command("convert", "-density", strconv.Itoa(raster.DPI), inputFile, renderedFile)
+	logrus.WithField("command", strings.Join(cmd.Args, " ")).Debug("Rendering unreadable input")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		cleanup()
+		logrus.WithError(err).WithField("output", string(output)).Debug("ImageMagick cannot render the input either")
+		return inputFile, noop, readErr
+	}
+	if _, err := p.readContextFile(renderedFile); err != nil {
+		cleanup()
+		return inputFile, noop, readErr
+	}
+
+	result.warn("pdfcpu cannot read the input, converting a copy rendered by ImageMagick: %v", readErr)
+	return renderedFile, cleanup, nil
 }
 
 func (p *PDFProcessor) addMarginsToPDF(input io.ReadSeeker, output io.Writer) error {
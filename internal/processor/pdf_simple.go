@@ -7,6 +7,7 @@ import (
 
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
 	"github.com/sirupsen/logrus"
 )
 
@@ -34,26 +35,44 @@ func (p *PDFProcessor) ProcessPDFSimple(inputFile, outputFile string) error {
 	return fmt.Errorf("failed to create margins with any available method")
 }
 
-func (p *PDFProcessor) addMarginsWithNUp(inputFile, outputFile string) error {
+func (p *PDFProcessor) addMarginsWithNUp(inputFile, outputFile string, pageCount int, result *Result) error {
 	logrus.WithFields(logrus.Fields{
 		"input":  inputFile,
 		"output": outputFile,
 		"margin": fmt.Sprintf("%.1fmm", MarginMM),
 	}).Info("Creating margins by scaling PDF content")
 
-	// Use the main CreateMargins function instead of old methods
-	if err := p.CreateMargins(inputFile, outputFile); err == nil {
+	// Use the main CreateMargins function instead of old methods. The output
+	// of an engine is only taken when it has every page in A4.
+	err := p.CreateMargins(inputFile, outputFile)
+	if err == nil {
+		err = p.verifyOutput(outputFile, pageCount)
+	}
+	if err == nil {
+		result.Engine = EngineImageMagickA4
+		result.Raster = p.opts.Raster.withDefaults()
 		return nil
 	}
+	result.warn("engine %s failed: %v", EngineImageMagickA4, err)
 
 	// Fallback approaches
 	logrus.Warn("Main method failed, trying fallbacks")
-	if err := p.CreateMarginsWithImageMagick(inputFile, outputFile); err == nil {
+	err = p.CreateMarginsWithImageMagick(inputFile, outputFile)
+	if err == nil {
+		err = p.verifyOutput(outputFile, pageCount)
+	}
+	if err == nil {
+		result.Engine = EngineImageMagickBorder
 		return nil
 	}
+	result.warn("engine %s failed: %v", EngineImageMagickBorder, err)
 
 	// Final fallback: pdfcpu method
-	return p.scaleContentWithImport(inputFile, outputFile)
+	if err := p.scaleContentWithImport(inputFile, outputFile, result); err != nil {
+		return fmt.Errorf("%w: all engines failed, last error: %w", ErrEngineFailed, err)
+	}
+	result.Engine = EnginePDFCPU
+	return p.verifyOutput(outputFile, pageCount)
 }
 
 func (p *PDFProcessor) copyFile(src, dst string) error {
@@ -73,32 +92,31 @@ func (p *PDFProcessor) copyFile(src, dst string) error {
 	return err
 }
 
-func (p *PDFProcessor) scaleContentWithImport(inputFile, outputFile string) error {
+func (p *PDFProcessor) scaleContentWithImport(inputFile, outputFile string, result *Result) error {
 	// Read the input PDF and manually scale each page's content
-	inputReader, err := os.Open(inputFile)
+	ctx, err := p.readContextFile(inputFile)
 	if err != nil {
-		return fmt.Errorf("failed to open input file: %w", err)
+		return err
 	}
-	defer inputReader.Close()
 
-	outputWriter, err := os.Create(outputFile)
+	// Print what a printer would print, not what a viewer shows
+	layers, err := p.flattenOptionalContent(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return fmt.Errorf("failed to flatten optional content: %w", err)
 	}
-	defer outputWriter.Close()
+	recordLayers(layers, result)
 
-	// Read the PDF context
-	ctx, err := api.ReadContext(inputReader, p.config)
+	outputWriter, err := os.Create(outputFile)
 	if err != nil {
-		return fmt.Errorf("failed to read PDF context: %w", err)
+		return fmt.Errorf("%w: failed to create output file: %w", ErrOutputNotWritable, err)
 	}
+	defer outputWriter.Close()
 
 	logrus.WithField("pages", ctx.PageCount).Info("Scaling content on each page")
 
 	// Scale content on each page to create margins
-	err = p.scaleAllPages(ctx)
-	if err != nil {
-		logrus.WithError(err).Warn("Content scaling failed, creating simple copy")
+	if err := p.scaleAllPages(ctx); err != nil {
+		return fmt.Errorf("failed to scale pages: %w", err)
 	}
 
 	// Write the modified PDF
@@ -111,61 +129,95 @@ func (p *PDFProcessor) scaleContentWithImport(inputFile, outputFile string) erro
 }
 
 func (p *PDFProcessor) scaleAllPages(ctx *model.Context) error {
-	// Get page dimensions
-	dims, err := ctx.PageDims()
+	plans, err := p.planPages(ctx, EnginePDFCPU)
 	if err != nil {
-		return fmt.Errorf("failed to get page dimensions: %w", err)
+		return err
 	}
 
 	// Process each page
-	for i := 1; i <= ctx.PageCount; i++ {
-		if i > len(dims) {
-			continue
-		}
-
-		pageDim := dims[i-1]
-
+	for _, plan := range plans {
 		logrus.WithFields(logrus.Fields{
-			"page":         i,
-			"width":        pageDim.Width,
-			"height":       pageDim.Height,
+			"page":         plan.Page,
+			"width":        plan.Width,
+			"height":       plan.Height,
+			"rotation":     plan.Rotation,
 			"marginPoints": MarginPoints,
 		}).Debug("Scaling page content")
 
-		// Calculate scale factor to leave margins
-		availableWidth := pageDim.Width - (2 * MarginPoints)
-		availableHeight := pageDim.Height - (2 * MarginPoints)
-
-		scaleX := availableWidth / pageDim.Width
-		scaleY := availableHeight / pageDim.Height
+		logrus.WithFields(logrus.Fields{
+			"page":       plan.Page,
+			"scale":      plan.ScaleX,
+			"translateX": plan.OffsetX,
+			"translateY": plan.OffsetY,
+		}).Debug("Calculated transformation parameters")
 
-		// Use uniform scaling (smaller scale to maintain aspect ratio)
-		scale := scaleX
-		if scaleY < scaleX {
-			scale = scaleY
+		if err := p.applyPagePlan(ctx, plan); err != nil {
+			return fmt.Errorf("failed to scale page %d: %w", plan.Page, err)
 		}
+	}
 
-		// Calculate translation to center the scaled content
-		scaledWidth := pageDim.Width * scale
-		scaledHeight := pageDim.Height * scale
-		translateX := (pageDim.Width - scaledWidth) / 2
-		translateY := (pageDim.Height - scaledHeight) / 2
+	return nil
+}
 
-		logrus.WithFields(logrus.Fields{
-			"page":       i,
-			"scale":      scale,
-			"translateX": translateX,
-			"translateY": translateY,
-		}).Debug("Calculated transformation parameters")
+// applyPagePlan wraps the page content in the plan's transformation and
+// replaces the page boxes with the output page size
+func (p *PDFProcessor) applyPagePlan(ctx *model.Context, plan PagePlan) error {
+	pageDict, _, _, err := ctx.PageDict(plan.Page, false)
+	if err != nil {
+		return fmt.Errorf("failed to get page dict: %w", err)
+	}
 
-		// Apply the transformation matrix to the page content
-		// This is where we would modify the PDF content stream
-		// For now, we log the transformation that would be applied
-		logrus.WithFields(logrus.Fields{
-			"page":           i,
-			"transformation": fmt.Sprintf("%.6f 0 0 %.6f %.6f %.6f cm", scale, scale, translateX, translateY),
-		}).Info("Transformation matrix calculated (content stream modification needed)")
+	if err := p.wrapPageContent(ctx, pageDict, "q\n"+plan.contentMatrix(), "\nQ\n"); err != nil {
+		return err
+	}
+
+	pageDict.Update("MediaBox", types.RectForDim(plan.OutputWidth, plan.OutputHeight).Array())
+	pageDict.Update("Rotate", types.Integer(0))
+	for _, box := range []string{"CropBox", "TrimBox", "BleedBox", "ArtBox"} {
+		pageDict.Delete(box)
+	}
+
+	return nil
+}
+
+// wrapPageContent surrounds the existing content streams of a page with a
+// prefix and a suffix stream
+func (p *PDFProcessor) wrapPageContent(ctx *model.Context, pageDict types.Dict, prefix, suffix string) error {
+	prefixRef, err := p.newContentStream(ctx, prefix)
+	if err != nil {
+		return err
 	}
+	suffixRef, err := p.newContentStream(ctx, suffix)
+	if err != nil {
+		return err
+	}
+
+	contents := types.Array{*prefixRef}
+	if obj, found := pageDict.Find("Contents"); found && obj != nil {
+		obj, err = ctx.Dereference(obj)
+		if err != nil {
+			return fmt.Errorf("failed to resolve page content: %w", err)
+		}
+		if arr, ok := obj.(types.Array); ok {
+			contents = append(contents, arr...)
+		} else {
+			contents = append(contents, pageDict["Contents"])
+		}
+	}
+	contents = append(contents, *suffixRef)
 
+	pageDict.Update("Contents", contents)
 	return nil
 }
+
+// newContentStream adds a flate encoded content stream to ctx
+func (p *PDFProcessor) newContentStream(ctx *model.Context, content string) (*types.IndirectRef, error) {
+	sd, err := ctx.NewStreamDictForBuf([]byte(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create content stream: %w", err)
+	}
+	if err := sd.Encode(); err != nil {
+		return nil, fmt.Errorf("failed to encode content stream: %w", err)
+	}
+	return ctx.IndRefForNewObject(*sd)
+}
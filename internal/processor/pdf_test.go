@@ -1,9 +1,26 @@
 package processor
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
 	"os"
-	"testing"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/image/font/gofont/goregular"
 )
 
 func TestNewPDFProcessor(t *testing.T) {
@@ -43,6 +60,10 @@ func TestProcessPDF_NonExistentFile(t *testing.T) {
 	if err == nil {
 		t.Fatal("Expected error for non-existent input file")
 	}
+
+	if code := ErrorCode(err); code != CodeInputNotFound {
+		t.Errorf("ErrorCode() = %s, want %s", code, CodeInputNotFound)
+	}
 }
 
 func TestProcessPDF_InvalidOutputPath(t *testing.T) {
@@ -102,4 +123,1786 @@ startxref
 %%EOF`
 
 	return os.WriteFile(filename, []byte(content), 0644)
-}
\ No newline at end of file
+}
+
+func TestPlan_DoesNotWriteOutput(t *testing.T) {
+	processor := NewPDFProcessor()
+
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "input.pdf")
+	outputFile := filepath.Join(tempDir, "output.pdf")
+
+	if err := createMinimalPDF(inputFile); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	result, err := processor.Plan(inputFile, outputFile)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	if _, err := os.Stat(outputFile); !os.IsNotExist(err) {
+		t.Error("Plan must not create the output file")
+	}
+
+	if !result.DryRun || result.Engine == "" || len(result.Pages) != 1 {
+		t.Fatalf("unexpected plan: %+v", result)
+	}
+
+	page := result.Pages[0]
+	if page.Width != 612 || page.Height != 792 {
+		t.Errorf("page size = %.1fx%.1f, want 612x792", page.Width, page.Height)
+	}
+	if page.OutputWidth != A4WidthPoints || page.OutputHeight != A4HeightPoints {
+		t.Errorf("output size = %.1fx%.1f, want A4", page.OutputWidth, page.OutputHeight)
+	}
+	if page.OffsetX < MarginPoints-1e-6 || page.OffsetY < MarginPoints-1e-6 {
+		t.Errorf("content placed inside margin: offset %.2f/%.2f", page.OffsetX, page.OffsetY)
+	}
+}
+
+func TestWritePreviewHTML(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "index.html")
+	pages := []string{"/tmp/preview/page_001.png", "/tmp/preview/page_002.png"}
+
+	if err := writePreviewHTML(filename, "/docs/letter.pdf", pages); err != nil {
+		t.Fatalf("writePreviewHTML failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("cannot read HTML: %v", err)
+	}
+
+	for _, want := range []string{"letter.pdf", `src="page_001.png"`, `src="page_002.png"`} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("HTML does not contain %q", want)
+		}
+	}
+}
+
+func TestPreview_SourcePages(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ImageMagick is a shell script")
+	}
+	tempDir := t.TempDir()
+	originalFile := filepath.Join(tempDir, "original.pdf")
+	convertedFile := filepath.Join(tempDir, "converted.pdf")
+	if err := createTextPDF(originalFile, []string{"one", "two"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := createTextPDF(convertedFile, []string{"one", "", "two"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake convert logs the source it renders
+	binDir := filepath.Join(tempDir, "bin")
+	if err := os.Mkdir(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir)
+	logFile := filepath.Join(tempDir, "convert.log")
+	script := "#!/bin/sh\ncase \"$1\" in -density) echo \"$3\" ;; -size) echo \"$3\" ;; esac >> \"" + logFile + "\"\n" +
+		"for last; do :; done\n: > \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(binDir, "convert"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultPreviewOptions(filepath.Join(tempDir, "preview"))
+	opts.SourcePages = []int{1, 0, 2}
+	if _, err := NewPDFProcessor().Preview(originalFile, convertedFile, opts); err != nil {
+		t.Fatalf("Preview failed: %v", err)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		originalFile + "[0]", convertedFile + "[0]",
+		"xc:white", convertedFile + "[1]",
+		originalFile + "[1]", convertedFile + "[2]",
+	}
+	if got := strings.Fields(string(data)); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("rendered %v, want %v", got, want)
+	}
+}
+
+func TestWriteDebugOverlay_KeepsSendFile(t *testing.T) {
+	processor := NewPDFProcessor()
+
+	tempDir := t.TempDir()
+	convertedFile := filepath.Join(tempDir, "converted.pdf")
+	overlayFile := filepath.Join(tempDir, "converted - debug.pdf")
+
+	if err := createMinimalPDF(convertedFile); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+	before, _ := os.ReadFile(convertedFile)
+
+	if err := processor.WriteDebugOverlay(convertedFile, overlayFile, nil, nil); err != nil {
+		t.Fatalf("WriteDebugOverlay failed: %v", err)
+	}
+
+	after, _ := os.ReadFile(convertedFile)
+	if string(before) != string(after) {
+		t.Error("WriteDebugOverlay must not modify the send file")
+	}
+
+	if _, err := os.Stat(overlayFile); err != nil {
+		t.Errorf("overlay file not written: %v", err)
+	}
+}
+
+func TestLoadMergeData(t *testing.T) {
+	tempDir := t.TempDir()
+
+	csvFile := filepath.Join(tempDir, "data.csv")
+	os.WriteFile(csvFile, []byte("\ufeffname;city\nJürgen Müller;Köln\nAnna;Berlin\n"), 0644)
+
+	jsonFile := filepath.Join(tempDir, "data.json")
+	os.WriteFile(jsonFile, []byte(`[{"name": "Jürgen Müller", "city": "Köln"}, {"name": "Anna", "city": "Berlin"}]`), 0644)
+
+	for _, file := range []string{csvFile, jsonFile} {
+		rows, err := LoadMergeData(file)
+		if err != nil {
+			t.Fatalf("LoadMergeData(%s) failed: %v", filepath.Base(file), err)
+		}
+		if len(rows) != 2 || rows[0]["name"] != "Jürgen Müller" || rows[1]["city"] != "Berlin" {
+			t.Errorf("LoadMergeData(%s) = %v", filepath.Base(file), rows)
+		}
+	}
+}
+
+func TestMailMerge(t *testing.T) {
+	processor := NewPDFProcessor()
+
+	tempDir := t.TempDir()
+	templateFile := filepath.Join(tempDir, "template.pdf")
+	if err := createMinimalPDF(templateFile); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	rows := []map[string]string{
+		{"name": "Jürgen Müller", "street": "Hauptstraße 1", "zip": "10115", "city": "Berlin"},
+		{"name": "Anna Weiß", "street": "Am Ring 5", "zip": "50667", "city": "Köln"},
+	}
+	opts := MergeOptions{
+		Layout:       DefaultMergeLayout(),
+		OutputDir:    tempDir,
+		NameTemplate: "letter {{row}}",
+	}
+
+	result, err := processor.MailMerge(templateFile, rows, opts)
+	if err != nil {
+		t.Fatalf("MailMerge failed: %v", err)
+	}
+
+	if len(result.Letters) != 2 {
+		t.Fatalf("got %d letters, want 2", len(result.Letters))
+	}
+	for _, name := range []string{"letter 001.pdf", "letter 002.pdf", "manifest.json"} {
+		if _, err := os.Stat(filepath.Join(tempDir, name)); err != nil {
+			t.Errorf("%s not written: %v", name, err)
+		}
+	}
+
+	// The embedded font of the address survives the conversion
+	for _, letter := range result.Letters {
+		check, err := processor.Check(letter.File)
+		if err != nil {
+			t.Fatalf("Check failed: %v", err)
+		}
+		if !check.Compliant {
+			t.Errorf("%s: findings %v", filepath.Base(letter.File), check.Findings)
+		}
+	}
+}
+
+func TestMailMerge_EngineFallback(t *testing.T) {
+	tempDir := t.TempDir()
+	templateFile := filepath.Join(tempDir, "template.pdf")
+	if err := createMinimalPDF(templateFile); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	// ImageMagick is expected to run but writes US Letter pages, so the
+	// vector engine produces the letter
+	installFakeConvert(t, templateFile)
+
+	processor := NewPDFProcessor()
+	opts := MergeOptions{Layout: DefaultMergeLayout(), OutputDir: tempDir}
+	result, err := processor.MailMerge(templateFile, []map[string]string{{"name": "Anna Weiß"}}, opts)
+	if err != nil {
+		t.Fatalf("MailMerge failed: %v", err)
+	}
+	letter := result.Letters[0]
+	if letter.Result.Engine != EnginePDFCPU {
+		t.Fatalf("Engine = %s, want %s", letter.Result.Engine, EnginePDFCPU)
+	}
+
+	// The text is placed through the inverse of the vector engine's transformation
+	ctx, err := processor.readContextFile(templateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans, err := processor.planPages(ctx, EnginePDFCPU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rasterPlans, err := processor.planPages(ctx, EngineImageMagickA4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := plans[0].transform().invert().String() + " cm"
+	if want == rasterPlans[0].transform().invert().String()+" cm" {
+		t.Fatal("the engines place the template alike, the test cannot tell them apart")
+	}
+
+	outCtx, err := processor.readContextFile(letter.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageDict, _, _, err := outCtx.PageDict(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := outCtx.PageContent(pageDict, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), want) {
+		t.Errorf("address not placed for %s, content %q", EnginePDFCPU, content)
+	}
+}
+
+func TestMatrixInvert(t *testing.T) {
+	m := matrix{0, -0.8, 0.8, 0, 14.17, 700}
+	id := m.then(m.invert())
+
+	for i, want := range []float64{1, 0, 0, 1, 0, 0} {
+		if diff := id[i] - want; diff > 1e-9 || diff < -1e-9 {
+			t.Fatalf("m * m^-1 = %v, want identity", id)
+		}
+	}
+}
+
+// createTextPDF writes a PDF with one page per entry of pages showing that
+// text. Empty entries produce blank pages.
+func createTextPDF(filename string, pages []string) error {
+	contents := make([]string, len(pages))
+	for i, text := range pages {
+		if text != "" {
+			contents[i] = fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text)
+		}
+	}
+	return createContentPDF(filename, contents)
+}
+
+// createContentPDF writes an A4 PDF with one page per raw content stream
+func createContentPDF(filename string, contents []string) error {
+	var objects []string
+	kids := ""
+	for i, content := range contents {
+		pageObj := 4 + 2*i
+		kids += fmt.Sprintf("%d 0 R ", pageObj)
+		objects = append(objects, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", pageObj+1))
+
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content)+1, content))
+	}
+	objects = append([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids, len(contents)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}, objects...)
+
+	return writeObjectsPDF(filename, objects)
+}
+
+func TestFlattenAnnotations(t *testing.T) {
+	field := "BT /F1 10 Tf 2 4 Td (Filled) Tj ET"
+	stamp := "BT /F1 10 Tf 2 4 Td (Approved) Tj ET"
+	note := "BT /F1 10 Tf 2 4 Td (NoteText) Tj ET"
+	stream := func(content string) string {
+		return fmt.Sprintf("<< /Subtype /Form /BBox [0 0 100 20] /Resources << /Font << /F1 3 0 R >> >> /Length %d >>\nstream\n%s\nendstream", len(content)+1, content)
+	}
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R /AcroForm << /Fields [6 0 R] >> >>",
+		"<< /Type /Pages /Kids [4 0 R] /Count 1 >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents 5 0 R " +
+			"/Resources << /Font << /F1 3 0 R >> >> /Annots [6 0 R 8 0 R 10 0 R] >>",
+		"<< /Length 36 >>\nstream\nBT /F1 12 Tf 72 720 Td (Page) Tj ET\nendstream",
+		"<< /Type /Annot /Subtype /Widget /FT /Tx /T (name) /V (Filled) /F 4 /Rect [72 600 272 640] /AP << /N 7 0 R >> >>",
+		stream(field),
+		"<< /Type /Annot /Subtype /Stamp /F 4 /Rect [300 600 400 620] /AS /On /AP << /N << /On 9 0 R >> >> >>",
+		stream(stamp),
+		"<< /Type /Annot /Subtype /Text /Rect [72 500 92 520] /AP << /N 11 0 R >> >>",
+		stream(note),
+	}
+
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "form.pdf")
+	if err := writeObjectsPDF(inputFile, objects); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	processor := NewPDFProcessor()
+	ctx, err := processor.readContextFile(inputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &Result{}
+	changed, err := processor.flattenAnnotations(ctx, result)
+	if err != nil || !changed {
+		t.Fatalf("flattenAnnotations = %v, %v", changed, err)
+	}
+	if result.FlattenedAnnotations != 2 || result.DroppedAnnotations != 1 {
+		t.Errorf("flattened %d and dropped %d annotations, want 2 and 1", result.FlattenedAnnotations, result.DroppedAnnotations)
+	}
+
+	page, err := processor.analyzePage(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(page.Text, "Page") || !strings.Contains(page.Text, "Filled") ||
+		!strings.Contains(page.Text, "Approved") || strings.Contains(page.Text, "NoteText") {
+		t.Errorf("unexpected print content %q", page.Text)
+	}
+
+	pageDict, _, _, _ := ctx.PageDict(1, false)
+	if _, found := pageDict.Find("Annots"); found {
+		t.Error("Expected annotations to be removed")
+	}
+	catalog, _ := ctx.Catalog()
+	if _, found := catalog.Find("AcroForm"); found {
+		t.Error("Expected the form to be removed")
+	}
+
+	// The field appearance is scaled from its bounding box onto the rectangle
+	sd, _, _ := ctx.DereferenceStreamDict(*types.NewIndirectRef(7, 0))
+	annot, _ := ctx.DereferenceDict(*types.NewIndirectRef(6, 0))
+	if m, ok := appearanceMatrix(ctx, annot, sd); !ok || m != (matrix{2, 0, 0, 2, 72, 600}) {
+		t.Errorf("appearanceMatrix = %v, %v", m, ok)
+	}
+
+	result, err = processor.Process(inputFile, filepath.Join(tempDir, "converted.pdf"))
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if result.FlattenedAnnotations != 2 {
+		t.Errorf("Process flattened %d annotations, want 2", result.FlattenedAnnotations)
+	}
+
+	// Forms that only exist as XFA cannot be printed
+	objects[0] = "<< /Type /Catalog /Pages 2 0 R /AcroForm << /Fields [] /XFA 5 0 R >> >>"
+	xfaFile := filepath.Join(tempDir, "xfa.pdf")
+	if err := writeObjectsPDF(xfaFile, objects); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+	_, err = processor.Process(xfaFile, filepath.Join(tempDir, "xfa - converted.pdf"))
+	if !errors.Is(err, ErrXFAForm) || ErrorCode(err) != CodeInvalidPDF {
+		t.Errorf("expected ErrXFAForm, got %v", err)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R /OpenAction 6 0 R /Metadata 9 0 R " +
+			"/Names << /JavaScript << /Names [(init) 6 0 R] >> /EmbeddedFiles << /Names [(draft.docx) 7 0 R] >> >> >>",
+		"<< /Type /Pages /Kids [4 0 R] /Count 1 >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents 5 0 R " +
+			"/Resources << /Font << /F1 3 0 R >> >> /Annots [8 0 R] >>",
+		"<< /Length 36 >>\nstream\nBT /F1 12 Tf 72 720 Td (Page) Tj ET\nendstream",
+		"<< /S /JavaScript /JS (app.alert\\(1\\)) >>",
+		"<< /Type /Filespec /F (draft.docx) /EF << /F 10 0 R >> >>",
+		"<< /Type /Annot /Subtype /Link /Rect [72 700 200 720] /A << /S /URI /URI (https://intranet.example) >> >>",
+		"<< /Type /Metadata /Subtype /XML /Length 5 >>\nstream\n<x/>\nendstream",
+		"<< /Type /EmbeddedFile /Length 5 >>\nstream\ndraft\nendstream",
+		"<< /Unused true >>",
+	}
+
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "draft.pdf")
+	if err := writeObjectsPDF(inputFile, objects); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	processor := NewPDFProcessor()
+	ctx, err := processor.readContextFile(inputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.Info, _ = ctx.IndRefForNewObject(types.Dict{"Author": types.StringLiteral("Jane Doe")})
+
+	removed, err := sanitize(ctx)
+	if err != nil {
+		t.Fatalf("sanitize failed: %v", err)
+	}
+	want := []string{
+		"1 link annotations", "1 unused objects", `JavaScript "init"`, "XMP metadata",
+		"document information Author", `embedded file "draft.docx"`, "open action",
+	}
+	if fmt.Sprint(removed) != fmt.Sprint(want) {
+		t.Errorf("removed = %q, want %q", removed, want)
+	}
+
+	// The written copy keeps the page but none of the removed content
+	outputFile := filepath.Join(tempDir, "draft - sanitized.pdf")
+	result, err := processor.Sanitize(inputFile, outputFile)
+	if err != nil {
+		t.Fatalf("Sanitize failed: %v", err)
+	}
+	if len(result.Removed) != len(want)-1 {
+		t.Errorf("Sanitize removed %q", result.Removed)
+	}
+	out, err := processor.readContextFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageDict, _, _, _ := out.PageDict(1, false)
+	if _, found := pageDict.Find("Annots"); found {
+		t.Error("sanitized copy still has annotations")
+	}
+	if removed, _ := sanitize(out); len(removed) != 0 {
+		t.Errorf("sanitized copy still has %q", removed)
+	}
+	if out.PageCount != 1 {
+		t.Errorf("sanitized copy has %d pages, want 1", out.PageCount)
+	}
+}
+
+func TestInvoice(t *testing.T) {
+	invoiceXML := "<rsm:CrossIndustryInvoice><ram:GuidelineSpecifiedDocumentContextParameter>" +
+		"<ram:ID>urn:factur-x.eu:1p0:basic</ram:ID></ram:GuidelineSpecifiedDocumentContextParameter></rsm:CrossIndustryInvoice>"
+	xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description rdf:about="" xmlns:fx="urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#">` +
+		`<fx:DocumentType>INVOICE</fx:DocumentType><fx:ConformanceLevel>BASIC</fx:ConformanceLevel>` +
+		`</rdf:Description></rdf:RDF></x:xmpmeta>`
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R /Metadata 8 0 R /AF [6 0 R] " +
+			"/Names << /EmbeddedFiles << /Names [(factur-x.xml) 6 0 R (notes.txt) 9 0 R] >> >> >>",
+		"<< /Type /Pages /Kids [4 0 R] /Count 1 >>",
+		"<< >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents 5 0 R >>",
+		"<< /Length 18 >>\nstream\n72 72 m 300 300 l S\nendstream",
+		"<< /Type /Filespec /F (factur-x.xml) /UF (factur-x.xml) /AFRelationship /Data /EF << /F 7 0 R >> >>",
+		fmt.Sprintf("<< /Type /EmbeddedFile /Subtype /text#2Fxml /Length %d >>\nstream\n%s\nendstream", len(invoiceXML), invoiceXML),
+		fmt.Sprintf("<< /Type /Metadata /Subtype /XML /Length %d >>\nstream\n%s\nendstream", len(xmp), xmp),
+		"<< /Type /Filespec /F (notes.txt) /EF << /F 10 0 R >> >>",
+		"<< /Type /EmbeddedFile /Length 5 >>\nstream\nnotes\nendstream",
+	}
+
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "invoice.pdf")
+	if err := writeObjectsPDF(inputFile, objects); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	// The invoice survives conversion and sanitizing, the other attachment does not
+	processor := NewPDFProcessorWithOptions(Options{Sanitize: true, ArchiveLevel: ArchivePDFA3B})
+	outputFile := filepath.Join(tempDir, "invoice - converted.pdf")
+	result, err := processor.Process(inputFile, outputFile)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if result.Invoice == nil || result.Invoice.Name != "factur-x.xml" || result.Invoice.ConformanceLevel != "BASIC" ||
+		result.Invoice.Relationship != "Data" || result.Invoice.Size != len(invoiceXML) {
+		t.Fatalf("unexpected invoice %+v", result.Invoice)
+	}
+	for _, warning := range result.Warnings {
+		if strings.Contains(warning, "e-invoice") {
+			t.Errorf("unexpected warning %q", warning)
+		}
+	}
+
+	ctx, err := processor.readContextFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoice, err := readInvoice(ctx)
+	if err != nil || invoice == nil || string(invoice.data) != invoiceXML || invoice.ConformanceLevel != "BASIC" {
+		t.Fatalf("output invoice = %+v, %v", invoice, err)
+	}
+	catalog, _ := ctx.Catalog()
+	names, _ := ctx.DereferenceDict(catalog["Names"])
+	if entries := nameTreeEntries(ctx, names["EmbeddedFiles"], 0); len(entries) != 1 {
+		t.Errorf("output has %d embedded files, want 1", len(entries))
+	}
+	if af, _ := ctx.DereferenceArray(catalog["AF"]); len(af) != 1 {
+		t.Errorf("output has %d associated files, want 1", len(af))
+	}
+
+	archiveFile := filepath.Join(tempDir, "invoice - archive.pdf")
+	if err := processor.WriteArchive(outputFile, archiveFile, ArchivePDFA3B); err != nil {
+		t.Fatalf("WriteArchive failed: %v", err)
+	}
+	archive, err := processor.readContextFile(archiveFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalog, _ = archive.Catalog()
+	if metadata, ok := pdfaMetadata(archive, catalog, 3); !ok || !strings.Contains(metadata, "<fx:ConformanceLevel>BASIC</fx:ConformanceLevel>") {
+		t.Errorf("archive metadata does not describe the invoice: %s", metadata)
+	}
+
+	// PDF/A-2b does not permit the invoice
+	err = processor.WriteArchive(outputFile, filepath.Join(tempDir, "invoice - 2b.pdf"), ArchivePDFA2B)
+	if !errors.Is(err, ErrNonCompliant) {
+		t.Errorf("expected ErrNonCompliant for PDF/A-2b, got %v", err)
+	}
+	_, err = NewPDFProcessorWithOptions(Options{ArchiveLevel: ArchivePDFA2B}).Process(inputFile, filepath.Join(tempDir, "2b.pdf"))
+	if !errors.Is(err, ErrNonCompliant) {
+		t.Errorf("expected Process to refuse PDF/A-2b, got %v", err)
+	}
+}
+
+func TestImageInput(t *testing.T) {
+	// Page 1 is 300 × 150 px at 150 dpi, page 2 a 100 × 200 px scan at 72 dpi
+	// that is stored sideways and has a black border
+	page1 := image.NewGray(image.Rect(0, 0, 300, 150))
+	for i := range page1.Pix {
+		page1.Pix[i] = 0xff
+	}
+	page2 := image.NewGray(image.Rect(0, 0, 100, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 100; x++ {
+			if x >= 10 && x < 90 && y >= 10 && y < 190 {
+				page2.SetGray(x, y, color.Gray{Y: 0xf0})
+			}
+		}
+	}
+
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "scan.tif")
+	if err := writeGrayTIFF(inputFile, []*image.Gray{page1, page2}, []int{1, 6}, []int{150, 72}); err != nil {
+		t.Fatalf("Failed to create test TIFF: %v", err)
+	}
+
+	processor := NewPDFProcessorWithOptions(Options{AutoCrop: true})
+	outputFile := filepath.Join(tempDir, "scan - converted.pdf")
+	result, err := processor.Process(inputFile, outputFile)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if result.PageCount != 2 || len(result.Images) != 2 {
+		t.Fatalf("Got %d pages and %d images, want 2 of each", result.PageCount, len(result.Images))
+	}
+
+	first, second := result.Images[0], result.Images[1]
+	if first.DPI != 150 || first.SourceDPI != 150 || first.Cropped || first.Orientation != 1 {
+		t.Errorf("Page 1 = %+v, want 150 dpi, upright and not cropped", first)
+	}
+	if second.DPI != 72 || !second.Cropped || second.Orientation != 6 {
+		t.Errorf("Page 2 = %+v, want 72 dpi, orientation 6 and cropped", second)
+	}
+	found := false
+	for _, warning := range result.Warnings {
+		found = found || strings.Contains(warning, "page 2: image prints at 72 dpi")
+	}
+	if !found {
+		t.Errorf("Expected a resolution warning for page 2, got %v", result.Warnings)
+	}
+
+	// The image page fills the content area, so the vector engine does not scale it
+	for _, page := range result.Pages {
+		if math.Abs(page.ScaleX-1) > 0.001 || math.Abs(page.OffsetX-MarginPoints) > 0.01 {
+			t.Errorf("Page %d is placed with scale %.4f at %.2f, want 1 at the margin", page.Page, page.ScaleX, page.OffsetX)
+		}
+	}
+
+	// Orientation 6 turns the top left stored pixel to the top right
+	src := sourceImage{width: 200, height: 100, xDPI: 72, yDPI: 72, orientation: 6}
+	m, _, dpi := src.placement(image.Rect(0, 0, 200, 100), 100, 200)
+	if x, y := m[2]+m[4], m[3]+m[5]; math.Abs(x-100) > 0.001 || math.Abs(y-200) > 0.001 || dpi != 72 {
+		t.Errorf("Top left pixel placed at %.1f, %.1f with %.0f dpi, want 100, 200 with 72 dpi", x, y, dpi)
+	}
+
+	// Images without a resolution fill the page
+	src = sourceImage{width: 100, height: 50}
+	if _, clip, _ := src.placement(image.Rect(0, 0, 100, 50), 400, 400); math.Abs(clip.Width()-400) > 0.001 {
+		t.Errorf("Image without resolution is %.1f wide, want 400", clip.Width())
+	}
+
+	planned, err := processor.Plan(inputFile, outputFile)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if planned.PageCount != 2 || len(planned.Images) != 2 {
+		t.Errorf("Plan has %d pages and %d images, want 2 of each", planned.PageCount, len(planned.Images))
+	}
+}
+
+func TestImageInput_Unreadable(t *testing.T) {
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "scan.tif")
+	if err := os.WriteFile(inputFile, []byte("II*\x00 cut off"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A broken image is not reported as a broken PDF
+	_, err := NewPDFProcessor().Process(inputFile, filepath.Join(tempDir, "scan - converted.pdf"))
+	if !errors.Is(err, ErrUnreadableInput) || ErrorCode(err) != CodeUnreadableInput {
+		t.Errorf("Process returned %v, want ErrUnreadableInput", err)
+	}
+}
+
+func TestOfficeInput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake LibreOffice is a shell script")
+	}
+
+	tempDir := t.TempDir()
+	binDir := filepath.Join(tempDir, "bin")
+	if err := os.Mkdir(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir)
+
+	inputFile := filepath.Join(tempDir, "letter.docx")
+	if err := os.WriteFile(inputFile, []byte("PK\x03\x04"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputFile := filepath.Join(tempDir, "letter - converted.pdf")
+
+	_, err := NewPDFProcessor().Process(inputFile, outputFile)
+	if !errors.Is(err, ErrToolMissing) {
+		t.Fatalf("Process without LibreOffice returned %v, want ErrToolMissing", err)
+	}
+
+	// A dry run does not start LibreOffice and leaves the pages unknown
+	planned, err := NewPDFProcessor().Plan(inputFile, outputFile)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if planned.PageCount != 0 || len(planned.Pages) != 0 || len(planned.Warnings) == 0 {
+		t.Errorf("Plan has %d pages and warnings %v, want unknown pages", planned.PageCount, planned.Warnings)
+	}
+
+	// The fake LibreOffice converts every document into the same two pages
+	fixture := filepath.Join(tempDir, "fixture.pdf")
+	if err := createTextPDF(fixture, []string{"Sehr geehrte Damen und Herren", "Anlage"}); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+	script := "#!/bin/sh\nwhile [ $# -gt 1 ]; do [ \"$1\" = --outdir ] && out=$2; shift; done\n" +
+		"name=${1##*/}\nexec /bin/cp \"" + fixture + "\" \"$out/${name%.*}.pdf\"\n"
+	soffice := filepath.Join(binDir, "soffice")
+	if err := os.WriteFile(soffice, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := NewPDFProcessor().Process(inputFile, outputFile)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if result.PageCount != 2 {
+		t.Errorf("PageCount = %d, want 2", result.PageCount)
+	}
+
+	// LibreOffice exits successfully without a PDF for documents it cannot read
+	if err := os.WriteFile(soffice, []byte("#!/bin/sh\necho 'Error: source file could not be loaded'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewPDFProcessor().Process(inputFile, outputFile)
+	if !errors.Is(err, ErrUnreadableInput) || errors.Is(err, ErrInvalidPDF) {
+		t.Errorf("Process with an unreadable document returned %v, want ErrUnreadableInput", err)
+	}
+
+	if err := os.WriteFile(soffice, []byte("#!/bin/sh\nexec /bin/sleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewPDFProcessorWithOptions(Options{OfficeTimeout: 100 * time.Millisecond}).Process(inputFile, outputFile)
+	if !errors.Is(err, ErrEngineFailed) || !strings.Contains(err.Error(), "did not finish") {
+		t.Errorf("Process with a hanging LibreOffice returned %v, want a timeout", err)
+	}
+}
+
+// installFakeConvert puts an ImageMagick convert on PATH that copies
+// fixture to its last argument, so every render yields the fixture's pages
+func installFakeConvert(t *testing.T, fixture string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ImageMagick is a shell script")
+	}
+
+	binDir := filepath.Join(t.TempDir(), "bin")
+	if err := os.Mkdir(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir)
+
+	script := "#!/bin/sh\nfor last; do :; done\nexec /bin/cp \"" + fixture + "\" \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(binDir, "convert"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcess_EngineFallback(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// The fake ImageMagick writes a US Letter page to every output
+	fixture := filepath.Join(tempDir, "letter-size.pdf")
+	if err := createMinimalPDF(fixture); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+	installFakeConvert(t, fixture)
+
+	inputFile := filepath.Join(tempDir, "input.pdf")
+	outputFile := filepath.Join(tempDir, "output.pdf")
+	if err := createTextPDF(inputFile, []string{"Anschreiben"}); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	result, err := NewPDFProcessor().Process(inputFile, outputFile)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if result.Engine != EnginePDFCPU {
+		t.Errorf("engine = %s, want %s after the ImageMagick engines wrote no A4 pages", result.Engine, EnginePDFCPU)
+	}
+	if err := NewPDFProcessor().verifyOutput(outputFile, 1); err != nil {
+		t.Errorf("output is not A4: %v", err)
+	}
+}
+
+func TestScaleContentWithImport(t *testing.T) {
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "letter-size.pdf")
+	outputFile := filepath.Join(tempDir, "output.pdf")
+	if err := createMinimalPDF(inputFile); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	p := NewPDFProcessor()
+	if err := p.scaleContentWithImport(inputFile, outputFile, &Result{}); err != nil {
+		t.Fatalf("scaleContentWithImport failed: %v", err)
+	}
+	if err := p.verifyOutput(outputFile, 1); err != nil {
+		t.Errorf("output is not A4: %v", err)
+	}
+
+	// The content is wrapped in the transformation of the page plan
+	inCtx, err := p.readContextFile(inputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plans, err := p.planPages(inCtx, EnginePDFCPU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outCtx, err := p.readContextFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageDict, _, _, err := outCtx.PageDict(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := outCtx.PageContent(pageDict, 1)
+	if err != nil {
+		t.Fatalf("Failed to read page content: %v", err)
+	}
+	if want := "q\n" + plans[0].contentMatrix(); !strings.HasPrefix(string(content), want) {
+		t.Errorf("content starts with %q, want %q", content, want)
+	}
+}
+
+func TestProcess_UnreadableInput(t *testing.T) {
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "damaged.pdf")
+	outputFile := filepath.Join(tempDir, "output.pdf")
+	if err := os.WriteFile(inputFile, []byte("%PDF-1.4\ngarbage\n%%EOF\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if _, err := NewPDFProcessor().Process(inputFile, outputFile); !errors.Is(err, ErrInvalidPDF) {
+		t.Fatalf("Process without ImageMagick returned %v, want ErrInvalidPDF", err)
+	}
+
+	// ImageMagick renders what pdfcpu cannot read
+	fixture := filepath.Join(tempDir, "rendered.pdf")
+	if err := createTextPDF(fixture, []string{"Anschreiben", "Anlage"}); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+	installFakeConvert(t, fixture)
+
+	// A dry run does not render the input and leaves the pages unknown
+	planned, err := NewPDFProcessor().Plan(inputFile, outputFile)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if planned.PageCount != 0 || len(planned.Warnings) == 0 {
+		t.Errorf("Plan has %d pages and warnings %v, want unknown pages", planned.PageCount, planned.Warnings)
+	}
+
+	result, err := NewPDFProcessor().Process(inputFile, outputFile)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if result.PageCount != 2 {
+		t.Errorf("PageCount = %d, want 2", result.PageCount)
+	}
+	if len(result.Warnings) == 0 || !strings.Contains(result.Warnings[0], "pdfcpu cannot read the input") {
+		t.Errorf("warnings = %v, want the rendered copy reported", result.Warnings)
+	}
+}
+
+func TestProcess_RasterTempFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	fixture := filepath.Join(tempDir, "rendered.pdf")
+	if err := createTextPDF(fixture, []string{"Anschreiben"}); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+	installFakeConvert(t, fixture)
+
+	// The fake ImageMagick also records every file it writes
+	written := filepath.Join(tempDir, "written.txt")
+	script := "#!/bin/sh\nfor last; do :; done\necho \"$last\" >> \"" + written + "\"\n" +
+		"exec /bin/cp \"" + fixture + "\" \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(os.Getenv("PATH"), "convert"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	inputFile := filepath.Join(tempDir, "input.pdf")
+	if err := createTextPDF(inputFile, []string{"Anschreiben"}); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+	outputDir := filepath.Join(tempDir, "out")
+	if err := os.Mkdir(outputDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := NewPDFProcessor().Process(inputFile, filepath.Join(outputDir, "output.pdf"))
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if result.Engine != EngineImageMagickA4 {
+		t.Fatalf("Engine = %s, want %s", result.Engine, EngineImageMagickA4)
+	}
+
+	data, err := os.ReadFile(written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range strings.Fields(string(data)) {
+		if filepath.Dir(file) == outputDir {
+			t.Errorf("raster engine wrote %s into the output directory", file)
+		}
+	}
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("output directory has %d files, want only the letter", len(entries))
+	}
+}
+
+func TestComposeLetter(t *testing.T) {
+	tempDir := t.TempDir()
+	bodyFile := filepath.Join(tempDir, "reminder.md")
+	body := "---\n" +
+		"sender: [Muster GmbH, Hauptstraße 1, 10115 Berlin]\n" +
+		"recipient: |\n  Erika Mustermann\n  Beispielweg 12\n  50667 Köln\n" +
+		"subject: Zahlungserinnerung\n" +
+		"date: 2026-10-18\n" +
+		"---\n" +
+		"Sehr geehrte Frau Mustermann,\n\n" +
+		"bitte **überweisen** Sie den _offenen_ Betrag.\n\n" +
+		"- erste Rechnung\n- zweite Rechnung\n\n" +
+		strings.Repeat("Ein langer Absatz, der den Brief auf eine zweite Seite bringt. ", 150)
+	if err := os.WriteFile(bodyFile, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flags take precedence over the front matter
+	letter := Letter{Subject: "Mahnung"}
+	if err := LoadLetter(bodyFile, &letter); err != nil {
+		t.Fatalf("LoadLetter failed: %v", err)
+	}
+	if !letter.Markdown || letter.Subject != "Mahnung" || letter.Date != "2026-10-18" ||
+		fmt.Sprint(letter.Recipient) != "[Erika Mustermann Beispielweg 12 50667 Köln]" || len(letter.Sender) != 3 {
+		t.Errorf("unexpected letter %+v", letter)
+	}
+	if strings.Contains(letter.Body, "subject:") {
+		t.Error("Expected the front matter to be removed from the body")
+	}
+
+	processor := NewPDFProcessor()
+	outputFile := filepath.Join(tempDir, "reminder.pdf")
+	result, err := processor.Compose(letter, outputFile)
+	if err != nil {
+		t.Fatalf("Compose failed: %v", err)
+	}
+	if result.PageCount < 2 {
+		t.Errorf("PageCount = %d, want a page break", result.PageCount)
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("unexpected warnings %v", result.Warnings)
+	}
+	if result.Check == nil || !result.Check.Compliant {
+		t.Errorf("Expected the letter to pass the check, got %+v", result.Check)
+	}
+
+	ctx, err := processor.readContextFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := processor.analyzePage(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"Erika Mustermann", "18.10.2026", "Mahnung", "Seite 1 von"} {
+		if !strings.Contains(page.Text, text) {
+			t.Errorf("Expected %q on page 1, got %q", text, page.Text)
+		}
+	}
+
+	if _, err := processor.Compose(Letter{Body: "Text"}, outputFile); err == nil {
+		t.Error("Expected an error for a letter without recipient")
+	}
+}
+
+func TestComposeLetter_Convert(t *testing.T) {
+	tempDir := t.TempDir()
+	letterFile := filepath.Join(tempDir, "letter.pdf")
+	processor := NewPDFProcessor()
+	letter := Letter{Recipient: []string{"Erika Mustermann", "Beispielweg 12", "50667 Köln"}, Body: "Sehr **geehrte** Frau Mustermann"}
+	if _, err := processor.Compose(letter, letterFile); err != nil {
+		t.Fatalf("Compose failed: %v", err)
+	}
+	attachmentFile := filepath.Join(tempDir, "attachment.pdf")
+	if err := createMinimalPDF(attachmentFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// The embedded fonts survive the conversion, alone and combined
+	convertedFile := filepath.Join(tempDir, "letter - converted.pdf")
+	if _, err := processor.Process(letterFile, convertedFile); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	combinedFile := filepath.Join(tempDir, "combined.pdf")
+	if _, err := processor.Combine([]string{attachmentFile, letterFile}, combinedFile, CombineOptions{}); err != nil {
+		t.Fatalf("Combine failed: %v", err)
+	}
+	for _, file := range []string{convertedFile, combinedFile} {
+		check, err := processor.Check(file)
+		if err != nil {
+			t.Fatalf("Check failed: %v", err)
+		}
+		if !check.Compliant || len(check.FontIssues) != 0 {
+			t.Errorf("%s: findings %v, font issues %v", filepath.Base(file), check.Findings, check.FontIssues)
+		}
+	}
+}
+
+func TestParseMarkdown(t *testing.T) {
+	blocks := parseMarkdown("# Title\n\nSome **bold** and *italic* text\nwith a [link](https://example.com)  \nbreak\n\n1. one\n2. two\n> quoted")
+
+	if len(blocks) != 5 {
+		t.Fatalf("got %d blocks, want 5: %+v", len(blocks), blocks)
+	}
+	if !blocks[0].heading || fmt.Sprint(blocks[0].spans) != "[{Title 1}]" {
+		t.Errorf("unexpected heading %+v", blocks[0])
+	}
+	want := "[{Some  0} {bold 1} { and  0} {italic 2} { text with a link (https://example.com)\nbreak 0}]"
+	if fmt.Sprint(blocks[1].spans) != want {
+		t.Errorf("spans = %q, want %q", fmt.Sprint(blocks[1].spans), want)
+	}
+	if blocks[2].marker != "1." || blocks[3].marker != "2." || !blocks[4].quote {
+		t.Errorf("unexpected list and quote %+v", blocks[2:])
+	}
+	if spans := parseInline("file_name_v2 and _this_", styleRegular); fmt.Sprint(spans) != "[{file_name_v2 and  0} {this 2}]" {
+		t.Errorf("unexpected spans %v", spans)
+	}
+}
+
+// writeObjectsPDF writes a PDF of raw objects numbered from 1, the first
+// one being the catalog
+func writeObjectsPDF(filename string, objects []string) error {
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return os.WriteFile(filename, []byte(b.String()), 0644)
+}
+
+// writeGrayTIFF writes an uncompressed multi-page gray TIFF with the given
+// orientation and resolution per page
+func writeGrayTIFF(filename string, pages []*image.Gray, orientations, dpis []int) error {
+	var b bytes.Buffer
+	b.WriteString("II*\x00\x08\x00\x00\x00")
+	for i, page := range pages {
+		w, h := page.Bounds().Dx(), page.Bounds().Dy()
+		const entries = 12
+		ifd := b.Len()
+		resolution := ifd + 2 + 12*entries + 4
+		pixels := resolution + 8
+
+		binary.Write(&b, binary.LittleEndian, uint16(entries))
+		for _, tag := range [][4]uint32{
+			{256, 4, 1, uint32(w)}, {257, 4, 1, uint32(h)}, {258, 3, 1, 8}, {259, 3, 1, 1},
+			{262, 3, 1, 1}, {273, 4, 1, uint32(pixels)}, {274, 3, 1, uint32(orientations[i])}, {277, 3, 1, 1},
+			{278, 4, 1, uint32(h)}, {279, 4, 1, uint32(w * h)}, {282, 5, 1, uint32(resolution)}, {283, 5, 1, uint32(resolution)},
+		} {
+			binary.Write(&b, binary.LittleEndian, uint16(tag[0]))
+			binary.Write(&b, binary.LittleEndian, uint16(tag[1]))
+			binary.Write(&b, binary.LittleEndian, tag[2])
+			binary.Write(&b, binary.LittleEndian, tag[3])
+		}
+		next := uint32(0)
+		if i < len(pages)-1 {
+			next = uint32(pixels + w*h)
+		}
+		binary.Write(&b, binary.LittleEndian, next)
+		binary.Write(&b, binary.LittleEndian, [2]uint32{uint32(dpis[i]), 1})
+		for y := 0; y < h; y++ {
+			b.Write(page.Pix[page.PixOffset(0, y) : page.PixOffset(0, y)+w])
+		}
+	}
+	return os.WriteFile(filename, b.Bytes(), 0644)
+}
+
+func TestSplit(t *testing.T) {
+	processor := NewPDFProcessor()
+
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "batch.pdf")
+	pages := []string{"Rechnung Nr. 1001", "Seite 2", "", "Rechnung Nr. 1002", "", "", "Rechnung Nr. 1003"}
+	if err := createTextPDF(inputFile, pages); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     SplitOptions
+		expected [][2]int
+	}{
+		{"pages", SplitOptions{Mode: SplitByPages, Pages: 3}, [][2]int{{1, 3}, {4, 6}, {7, 7}}},
+		{"text", SplitOptions{Mode: SplitByText, Pattern: regexp.MustCompile(`Rechnung Nr\. (\d+)`), NameTemplate: "{{match}}"}, [][2]int{{1, 3}, {4, 6}, {7, 7}}},
+		{"blank", SplitOptions{Mode: SplitByBlank}, [][2]int{{1, 2}, {4, 4}, {7, 7}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.OutputDir = filepath.Join(tempDir, tt.name)
+			os.MkdirAll(tt.opts.OutputDir, 0755)
+
+			result, err := processor.Split(inputFile, tt.opts)
+			if err != nil {
+				t.Fatalf("Split failed: %v", err)
+			}
+
+			if len(result.Parts) != len(tt.expected) {
+				t.Fatalf("got %d parts, want %d", len(result.Parts), len(tt.expected))
+			}
+			for i, part := range result.Parts {
+				if part.FirstPage != tt.expected[i][0] || part.LastPage != tt.expected[i][1] {
+					t.Errorf("part %d = pages %d-%d, want %d-%d", i+1, part.FirstPage, part.LastPage, tt.expected[i][0], tt.expected[i][1])
+				}
+				if _, err := os.Stat(part.File); err != nil {
+					t.Errorf("part %d not written: %v", i+1, err)
+				}
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "text", "1002.pdf")); err != nil {
+		t.Errorf("letter not named after the match: %v", err)
+	}
+
+	bookmarked := filepath.Join(tempDir, "bookmarked.pdf")
+	bookmarks := []pdfcpu.Bookmark{{Title: "Müller", PageFrom: 2}, {Title: "Schmidt", PageFrom: 5}}
+	if err := api.AddBookmarksFile(inputFile, bookmarked, bookmarks, true, processor.config); err != nil {
+		t.Fatalf("Failed to add bookmarks: %v", err)
+	}
+
+	result, err := processor.Split(bookmarked, SplitOptions{Mode: SplitByBookmarks, OutputDir: tempDir, NameTemplate: "{{title}}"})
+	if err != nil {
+		t.Fatalf("Split by bookmarks failed: %v", err)
+	}
+	if len(result.Parts) != 2 || result.Parts[0].FirstPage != 1 || result.Parts[0].LastPage != 4 || result.Parts[1].Title != "Schmidt" {
+		t.Errorf("unexpected bookmark split: %+v", result.Parts)
+	}
+}
+
+func TestTokenizeContent(t *testing.T) {
+	content := []byte(`BT /F1 12 Tf (a\(b\) \344) Tj [<4142> -250 (C)] TJ ET BI /W 1 ID xx EI Q`)
+
+	var operators []string
+	var strs []string
+	for _, tok := range tokenizeContent(content) {
+		switch tok.kind {
+		case tokenOperator:
+			operators = append(operators, tok.value)
+		case tokenString:
+			strs = append(strs, tok.value)
+		}
+	}
+
+	if got := strings.Join(operators, " "); got != "BT Tf Tj TJ ET BI ID Q" {
+		t.Errorf("operators = %q", got)
+	}
+	if got := strings.Join(strs, "|"); got != "a(b) \xe4|AB|C" {
+		t.Errorf("strings = %q", got)
+	}
+}
+
+func TestCombine_SheetAligned(t *testing.T) {
+	processor := NewPDFProcessor()
+
+	tempDir := t.TempDir()
+	cover := filepath.Join(tempDir, "cover.pdf")
+	attachment := filepath.Join(tempDir, "attachment.pdf")
+	outputFile := filepath.Join(tempDir, "letter.pdf")
+	if err := createTextPDF(cover, []string{"Anschreiben"}); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+	if err := createTextPDF(attachment, []string{"Anlage 1", "Anlage 2", "Anlage 3"}); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	result, err := processor.Combine([]string{cover, attachment, cover}, outputFile, CombineOptions{SheetAligned: true})
+	if err != nil {
+		t.Fatalf("Combine failed: %v", err)
+	}
+
+	// cover + blank, 3 attachment pages + blank, cover
+	if result.PageCount != 7 {
+		t.Errorf("page count = %d, want 7", result.PageCount)
+	}
+	for i, want := range []int{1, 3, 7} {
+		if result.Sections[i].FirstPage != want {
+			t.Errorf("section %d starts on page %d, want %d", i+1, result.Sections[i].FirstPage, want)
+		}
+	}
+	if result.Sections[2].BlankPagesAdded != 0 {
+		t.Error("no blank page must be added after the last file")
+	}
+}
+
+func TestProcess_Duplex(t *testing.T) {
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "input.pdf")
+	if err := createTextPDF(inputFile, []string{"Anschreiben", "", "Anlage 1", "Anlage 2", ""}); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     Options
+		pages    int
+		removed  []int
+		inserted []int
+		sources  []int
+	}{
+		{"pad to even", Options{Duplex: true}, 6, nil, []int{6}, []int{1, 2, 3, 4, 5, 0}},
+		{"remove blank", Options{RemoveBlankPages: true}, 3, []int{2, 5}, nil, []int{1, 3, 4}},
+		{"section on front side", Options{Duplex: true, RemoveBlankPages: true, SectionStarts: []int{3}}, 4, []int{2, 5}, []int{2}, []int{1, 0, 3, 4}},
+		{"remove blank and pad to even", Options{Duplex: true, RemoveBlankPages: true}, 4, []int{2, 5}, []int{4}, []int{1, 3, 4, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputFile := filepath.Join(tempDir, tt.name+".pdf")
+			result, err := NewPDFProcessorWithOptions(tt.opts).Process(inputFile, outputFile)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+
+			if fmt.Sprint(result.BlankPages) != "[2 5]" {
+				t.Errorf("blank pages = %v, want [2 5]", result.BlankPages)
+			}
+			if fmt.Sprint(result.RemovedPages) != fmt.Sprint(tt.removed) || fmt.Sprint(result.InsertedPages) != fmt.Sprint(tt.inserted) {
+				t.Errorf("removed %v, inserted %v, want %v, %v", result.RemovedPages, result.InsertedPages, tt.removed, tt.inserted)
+			}
+
+			count, err := api.PageCountFile(outputFile)
+			if err != nil || count != tt.pages || result.PageCount != tt.pages {
+				t.Errorf("output has %d pages (result %d), want %d: %v", count, result.PageCount, tt.pages, err)
+			}
+
+			// The blank pages are inserted where the result says
+			p := NewPDFProcessor()
+			ctx, err := p.readContextFile(outputFile)
+			if err != nil {
+				t.Fatalf("Failed to read output: %v", err)
+			}
+			blank, err := p.findBlankPages(ctx, outputFile, false, &Result{})
+			if err != nil {
+				t.Fatalf("Failed to find blank pages: %v", err)
+			}
+			isBlank := make(map[int]bool, len(blank))
+			for _, page := range blank {
+				isBlank[page] = true
+			}
+			for _, page := range tt.inserted {
+				if !isBlank[page] {
+					t.Errorf("inserted page %d is not blank, blank pages are %v", page, blank)
+				}
+			}
+
+			sources := result.SourcePages()
+			if fmt.Sprint(sources) != fmt.Sprint(tt.sources) {
+				t.Fatalf("source pages = %v, want %v", sources, tt.sources)
+			}
+
+			// The overlay labels every page with the plan of its input page
+			overlayFile := filepath.Join(tempDir, tt.name+" - overlay.pdf")
+			if err := p.WriteDebugOverlay(outputFile, overlayFile, result.Pages, sources); err != nil {
+				t.Fatalf("WriteDebugOverlay failed: %v", err)
+			}
+			overlayCtx, err := p.readContextFile(overlayFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, source := range sources {
+				pageDict, _, _, err := overlayCtx.PageDict(i+1, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, err := overlayCtx.PageContent(pageDict, i+1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := fmt.Sprintf("(page %d  scale", i+1)
+				if source == 0 {
+					want = "blank page inserted for duplex"
+				} else if source != i+1 {
+					want = fmt.Sprintf("(page %d \\(input page %d\\)  scale", i+1, source)
+				}
+				if !strings.Contains(string(content), want) {
+					t.Errorf("label of page %d does not contain %q", i+1, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEstimatePostage(t *testing.T) {
+	tests := []struct {
+		pages    int
+		opts     PostageOptions
+		class    string
+		sheets   int
+		hasError bool
+	}{
+		{1, PostageOptions{}, PostageStandard, 1, false},
+		{3, PostageOptions{}, PostageStandard, 3, false},
+		{4, PostageOptions{}, PostageKompakt, 4, false},
+		{8, PostageOptions{Duplex: true}, PostageKompakt, 4, false},
+		{6, PostageOptions{Duplex: true}, PostageStandard, 3, false},
+		{12, PostageOptions{}, PostageGross, 12, false},
+		{1, PostageOptions{Envelope: EnvelopeC4}, PostageGross, 1, false},
+		{120, PostageOptions{Envelope: EnvelopeC4}, PostageMaxi, 120, false},
+		{400, PostageOptions{Envelope: EnvelopeC4}, "", 400, true},
+	}
+
+	for _, tt := range tests {
+		estimate, err := EstimatePostage(tt.pages, tt.opts)
+		if (err != nil) != tt.hasError {
+			t.Errorf("EstimatePostage(%d, %+v) error = %v, wantErr %v", tt.pages, tt.opts, err, tt.hasError)
+			continue
+		}
+		if estimate.Class != tt.class || estimate.Sheets != tt.sheets {
+			t.Errorf("EstimatePostage(%d, %+v) = %s with %d sheets, want %s with %d", tt.pages, tt.opts,
+				estimate.Class, estimate.Sheets, tt.class, tt.sheets)
+		}
+	}
+
+	if _, err := EstimatePostage(1, PostageOptions{Envelope: "B4"}); err == nil {
+		t.Error("expected error for unknown envelope")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	processor := NewPDFProcessor()
+
+	tempDir := t.TempDir()
+	letterFile := filepath.Join(tempDir, "letter.pdf")
+	convertedFile := filepath.Join(tempDir, "converted.pdf")
+	if err := createMinimalPDF(letterFile); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	result, err := processor.Check(letterFile)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if result.Compliant || len(result.Findings) != 1 {
+		t.Errorf("US Letter page must not be compliant: %+v", result)
+	}
+
+	if _, err := processor.Process(letterFile, convertedFile); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	result, err = processor.Check(convertedFile)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if !result.Compliant || result.Postage == nil || result.Postage.Class != PostageStandard {
+		t.Errorf("converted letter must be compliant: %+v", result)
+	}
+}
+
+func TestFindColorPages(t *testing.T) {
+	processor := NewPDFProcessor()
+
+	inputFile := filepath.Join(t.TempDir(), "color.pdf")
+	contents := []string{
+		"0 0 0 rg 72 72 100 100 re f",
+		"1 0 0 rg 72 72 100 100 re f",
+		"0.5 0.5 0.5 RG 0 0 1 rg 72 72 100 100 re S",
+		"0 0 0 1 k 0.2 0.2 0.2 0 k 72 72 100 100 re f",
+		"0 0.5 1 0 K 72 72 100 100 re S",
+		"/Sh1 sh",
+		"1 0 0 rg",
+	}
+	if err := createContentPDF(inputFile, contents); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	ctx, err := processor.readContextFile(inputFile)
+	if err != nil {
+		t.Fatalf("readContextFile failed: %v", err)
+	}
+
+	result := &Result{}
+	colorPages, err := processor.findColorPages(ctx, inputFile, false, result)
+	if err != nil {
+		t.Fatalf("findColorPages failed: %v", err)
+	}
+
+	if got, want := fmt.Sprint(colorPages), "[2 5 6]"; got != want {
+		t.Errorf("Expected color pages %s, got %s", want, got)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "pages 6") {
+		t.Errorf("Expected a warning about page 6, got %v", result.Warnings)
+	}
+}
+
+func TestPageColorModes(t *testing.T) {
+	processor := NewPDFProcessor()
+
+	inputFile := filepath.Join(t.TempDir(), "modes.pdf")
+	contents := []string{
+		"BT /F1 12 Tf 72 720 Td (Sehr geehrte Damen und Herren) Tj ET",
+		"0 0 1 rg BT /F1 12 Tf 72 720 Td (Logo) Tj ET",
+		"q 100 0 0 100 72 72 cm BI /W 1 /H 1 /CS /G /BPC 8 ID \x80 EI Q",
+		"q 100 0 0 100 72 72 cm BI /W 1 /H 1 /CS /RGB /BPC 8 ID \x80\x40\x20 EI Q",
+		"",
+	}
+	if err := createContentPDF(inputFile, contents); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	ctx, err := processor.readContextFile(inputFile)
+	if err != nil {
+		t.Fatalf("readContextFile failed: %v", err)
+	}
+
+	modes, err := processor.pageColorModes(ctx, RasterOptions{ColorMode: ColorModeAuto})
+	if err != nil {
+		t.Fatalf("pageColorModes failed: %v", err)
+	}
+	expected := []string{ColorModeBitonal, ColorModeColor, ColorModeGrayscale, ColorModeColor, ColorModeBitonal}
+	if fmt.Sprint(modes) != fmt.Sprint(expected) {
+		t.Errorf("Expected modes %v, got %v", expected, modes)
+	}
+
+	modes, err = processor.pageColorModes(ctx, RasterOptions{ColorMode: ColorModeGrayscale})
+	if err != nil {
+		t.Fatalf("pageColorModes failed: %v", err)
+	}
+	for i, mode := range modes {
+		if mode != ColorModeGrayscale {
+			t.Errorf("Page %d: expected %s, got %s", i+1, ColorModeGrayscale, mode)
+		}
+	}
+}
+
+func TestRasterOptions_Validate(t *testing.T) {
+	tests := []struct {
+		opts  RasterOptions
+		valid bool
+	}{
+		{RasterOptions{}, true},
+		{RasterOptions{ColorMode: ColorModeBitonal, DPI: 600}, true},
+		{RasterOptions{ColorMode: "sepia"}, false},
+		{RasterOptions{DPI: 10}, false},
+		{RasterOptions{JPEGQuality: 101}, false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.opts.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v) = %v, expected valid %v", tt.opts, err, tt.valid)
+		}
+	}
+}
+
+func TestSizeSteps(t *testing.T) {
+	steps := sizeSteps(RasterOptions{})
+
+	var got []string
+	for _, step := range steps {
+		got = append(got, fmt.Sprintf("%d/%d/%v", step.DPI, step.JPEGQuality, step.NoColor))
+	}
+	expected := "300/85/false 300/75/false 300/60/false 300/50/false 200/50/false 150/50/false 150/50/true"
+	if strings.Join(got, " ") != expected {
+		t.Errorf("Expected steps %s, got %s", expected, strings.Join(got, " "))
+	}
+
+	// Nothing left to reduce at the quality floor
+	if steps := sizeSteps(RasterOptions{ColorMode: ColorModeBitonal, DPI: MinRasterDPI, JPEGQuality: MinJPEGQuality}); len(steps) != 0 {
+		t.Errorf("Expected no steps at the quality floor, got %v", steps)
+	}
+}
+
+func TestProcess_MaxSize(t *testing.T) {
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "letter.pdf")
+	if err := createTextPDF(inputFile, []string{"Seite 1"}); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	processor := NewPDFProcessorWithOptions(Options{MaxSize: 10 << 20})
+	result, err := processor.Process(inputFile, filepath.Join(tempDir, "fits.pdf"))
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(result.SizeAttempts) != 1 {
+		t.Errorf("Expected 1 size attempt, got %d", len(result.SizeAttempts))
+	}
+
+	processor = NewPDFProcessorWithOptions(Options{MaxSize: 100})
+	_, err = processor.Process(inputFile, filepath.Join(tempDir, "too-large.pdf"))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "too-large.pdf")); !os.IsNotExist(err) {
+		t.Error("Expected the oversized output to be removed")
+	}
+
+	// The blank back side added for duplex printing counts against the limit
+	info, err := os.Stat(filepath.Join(tempDir, "fits.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor = NewPDFProcessorWithOptions(Options{MaxSize: info.Size(), Duplex: true})
+	result, err = processor.Process(inputFile, filepath.Join(tempDir, "duplex.pdf"))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge after padding for duplex, got %v", err)
+	}
+	if len(result.SizeAttempts) != 1 || result.SizeAttempts[0].Size > info.Size() {
+		t.Errorf("Expected the output to fit before padding, got attempts %+v", result.SizeAttempts)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "duplex.pdf")); !os.IsNotExist(err) {
+		t.Error("Expected the output grown past the limit to be removed")
+	}
+}
+
+func TestSplitFontName(t *testing.T) {
+	tests := []struct {
+		name, family, style string
+	}{
+		{"Helvetica", "helvetica", ""},
+		{"Helvetica-BoldOblique", "helvetica", "bolditalic"},
+		{"ABCDEF+Arial,Bold", "arial", "bold"},
+		{"ArialMT", "arial", ""},
+		{"TimesNewRomanPS-BoldMT", "timesnewroman", "bold"},
+		{"Times-Roman", "times", ""},
+		{"LiberationSans-Italic", "liberationsans", "italic"},
+	}
+
+	for _, tt := range tests {
+		family, style := splitFontName(tt.name)
+		if family != tt.family || style != tt.style {
+			t.Errorf("splitFontName(%q) = %q, %q, want %q, %q", tt.name, family, style, tt.family, tt.style)
+		}
+	}
+
+	index := fontIndex{"liberationsans/bold": "LiberationSans-Bold.ttf"}
+	if got := index.lookup("Helvetica-Bold"); got != "LiberationSans-Bold.ttf" {
+		t.Errorf("Expected Helvetica-Bold to be substituted by LiberationSans-Bold, got %q", got)
+	}
+	if got := index.lookup("Helvetica"); got != "" {
+		t.Errorf("Expected no substitute for Helvetica, got %q", got)
+	}
+}
+
+func TestFontIssues(t *testing.T) {
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "letter.pdf")
+	if err := createTextPDF(inputFile, []string{"Sehr geehrte Damen und Herren"}); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	// Use the name of the built-in Go font, which has the same length
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = []byte(strings.Replace(string(data), "/Helvetica", "/GoRegular", 1))
+	if err := os.WriteFile(inputFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := NewPDFProcessor().Check(inputFile)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(result.FontIssues) != 1 || result.FontIssues[0].Problem != FontNotEmbedded || result.Compliant {
+		t.Errorf("Expected a non-embedded font finding, got %+v", result)
+	}
+
+	fontDir := filepath.Join(tempDir, "fonts")
+	os.MkdirAll(fontDir, 0755)
+	if err := os.WriteFile(filepath.Join(fontDir, "Go-Regular.ttf"), goregular.TTF, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	processor := NewPDFProcessorWithOptions(Options{FixFonts: FixFontsEmbed, FontDir: fontDir})
+	converted, err := processor.Process(inputFile, filepath.Join(tempDir, "converted.pdf"))
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(converted.FontIssues) != 0 {
+		t.Errorf("Expected no font issues after embedding, got %v", converted.FontIssues)
+	}
+	if converted.Engine == EnginePDFCPU && len(converted.EmbeddedFonts) != 1 {
+		t.Errorf("Expected the font to be embedded, got %v", converted.EmbeddedFonts)
+	}
+
+	result, err = processor.Check(converted.OutputFile)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(result.FontIssues) != 0 {
+		t.Errorf("Expected no font issues in the output, got %v", result.FontIssues)
+	}
+}
+
+func TestProcess_Encrypted(t *testing.T) {
+	tempDir := t.TempDir()
+	plainFile := filepath.Join(tempDir, "plain.pdf")
+	encryptedFile := filepath.Join(tempDir, "encrypted.pdf")
+	if err := createTextPDF(plainFile, []string{"Vertraulich"}); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+	conf := model.NewAESConfiguration("geheim", "owner", 256)
+	conf.Permissions = model.PermissionsPrint
+	if err := api.EncryptFile(plainFile, encryptedFile, conf); err != nil {
+		t.Fatalf("Failed to encrypt test PDF: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  error
+	}{
+		{"no password", "", ErrPasswordRequired},
+		{"wrong password", "falsch", ErrWrongPassword},
+		{"user password", "geheim", nil},
+		{"owner password", "owner", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputFile := filepath.Join(tempDir, tt.name+".pdf")
+			processor := NewPDFProcessorWithOptions(Options{Password: tt.password})
+			result, err := processor.Process(encryptedFile, outputFile)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !errors.Is(err, ErrEncrypted) {
+					t.Errorf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if !result.Encrypted || !strings.Contains(strings.Join(result.Permissions, " "), "print") {
+				t.Errorf("Expected encryption with print permission to be recorded, got %v %v", result.Encrypted, result.Permissions)
+			}
+
+			// The output opens without a password
+			if err := ensureNotEncrypted(outputFile); err != nil {
+				t.Errorf("Output must not be encrypted: %v", err)
+			}
+		})
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "letter.pdf")
+	if err := createTextPDF(inputFile, []string{"Rechnung 2024-001"}); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	// Use the built-in Go font as the substitute, see TestFontIssues
+	data, err := os.ReadFile(inputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = []byte(strings.Replace(string(data), "/Helvetica", "/GoRegular", 1))
+	if err := os.WriteFile(inputFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fontDir := filepath.Join(tempDir, "fonts")
+	os.MkdirAll(fontDir, 0755)
+	if err := os.WriteFile(filepath.Join(fontDir, "Go-Regular.ttf"), goregular.TTF, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	processor := NewPDFProcessorWithOptions(Options{FontDir: fontDir})
+	outputFile := filepath.Join(tempDir, "converted.pdf")
+	if _, err := processor.Process(inputFile, outputFile); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	for _, level := range []string{ArchivePDFA2B, ArchivePDFA3B} {
+		archiveFile := filepath.Join(tempDir, level+".pdf")
+		if err := processor.WriteArchive(outputFile, archiveFile, level); err != nil {
+			t.Fatalf("WriteArchive(%s) failed: %v", level, err)
+		}
+		if problems := processor.validateArchive(archiveFile, archiveParts[level]); len(problems) != 0 {
+			t.Errorf("Expected a valid %s copy, got %v", level, problems)
+		}
+	}
+
+	// The send file itself is left alone
+	if problems := processor.validateArchive(outputFile, 2); len(problems) == 0 {
+		t.Error("Expected the converted file not to be PDF/A")
+	}
+
+	if err := processor.WriteArchive(outputFile, filepath.Join(tempDir, "x.pdf"), "pdfa-1b"); err == nil {
+		t.Error("Expected an error for an unknown archive level")
+	}
+}
+
+func TestTransparentGraphicsState(t *testing.T) {
+	tests := []struct {
+		name string
+		gs   types.Dict
+		want bool
+	}{
+		{"opaque", types.Dict{"CA": types.Float(1), "ca": types.Integer(1)}, false},
+		{"fill alpha", types.Dict{"ca": types.Float(0.5)}, true},
+		{"no soft mask", types.Dict{"SMask": types.Name("None")}, false},
+		{"soft mask", types.Dict{"SMask": types.Dict{"S": types.Name("Luminosity")}}, true},
+		{"normal blend", types.Dict{"BM": types.Name("Normal")}, false},
+		{"multiply", types.Dict{"BM": types.Array{types.Name("Multiply")}}, true},
+	}
+
+	ctx, err := model.NewContext(bytes.NewReader(nil), model.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := transparentGraphicsState(ctx, tt.gs); got != tt.want {
+				t.Errorf("transparentGraphicsState() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSRGBProfile(t *testing.T) {
+	profile := srgbProfile()
+	if len(profile) < 128 || string(profile[36:40]) != "acsp" {
+		t.Fatal("Expected an ICC profile header")
+	}
+	if size := binary.BigEndian.Uint32(profile); int(size) != len(profile) {
+		t.Errorf("Header size %d does not match profile length %d", size, len(profile))
+	}
+	if string(profile[12:24]) != "mntrRGB XYZ " {
+		t.Errorf("Expected an RGB display profile, got %q", profile[12:24])
+	}
+}
+
+func TestFlattenOptionalContent(t *testing.T) {
+	content := "BT /F1 12 Tf 72 720 Td (Always) Tj ET\n" +
+		"/OC /MC0 BDC BT /F1 12 Tf 72 700 Td (ScreenOnly) Tj ET EMC\n" +
+		"/OC /MC1 BDC BT /F1 12 Tf 72 680 Td (PrintOnly) Tj ET EMC\n" +
+		"/Span << /ActualText (x) >> BDC /OC /MC0 BDC (Nested) Tj EMC EMC"
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R /OCProperties << /OCGs [6 0 R 7 0 R] /D << /OFF [7 0 R] " +
+			"/AS [<< /Event /Print /OCGs [6 0 R 7 0 R] /Category [/Print] >>] >> >> >>",
+		"<< /Type /Pages /Kids [4 0 R] /Count 1 >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents 5 0 R " +
+			"/Resources << /Font << /F1 3 0 R >> /Properties << /MC0 6 0 R /MC1 7 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content)+1, content),
+		"<< /Type /OCG /Name (Screen) /Usage << /Print << /PrintState /OFF >> >> >>",
+		"<< /Type /OCG /Name (Watermark) /Usage << /Print << /PrintState /ON >> >> >>",
+	}
+
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "layers.pdf")
+	if err := writeObjectsPDF(inputFile, objects); err != nil {
+		t.Fatalf("Failed to create test PDF: %v", err)
+	}
+
+	processor := NewPDFProcessor()
+	ctx, err := processor.readContextFile(inputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := processor.flattenOptionalContent(ctx)
+	if err != nil {
+		t.Fatalf("flattenOptionalContent failed: %v", err)
+	}
+
+	want := []Layer{{Name: "Screen", Printed: false}, {Name: "Watermark", Printed: true}}
+	if fmt.Sprint(layers) != fmt.Sprint(want) {
+		t.Errorf("layers = %v, want %v", layers, want)
+	}
+
+	page, err := processor.analyzePage(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(page.Text, "Always") || !strings.Contains(page.Text, "PrintOnly") ||
+		strings.Contains(page.Text, "ScreenOnly") || strings.Contains(page.Text, "Nested") {
+		t.Errorf("unexpected print content %q", page.Text)
+	}
+
+	catalog, _ := ctx.Catalog()
+	if _, found := catalog.Find("OCProperties"); found {
+		t.Error("Expected optional content properties to be removed")
+	}
+
+	pageDict, _, _, _ := ctx.PageDict(1, false)
+	flattened, _ := ctx.PageContent(pageDict, 1)
+	ops := map[string]int{}
+	for _, tok := range tokenizeContent(flattened) {
+		if tok.kind == tokenOperator {
+			ops[tok.value]++
+		}
+	}
+	if ops["BDC"]+ops["BMC"] != ops["EMC"] || ops["BDC"] != 1 {
+		t.Errorf("unbalanced marked content in %q", flattened)
+	}
+
+	// The vector engine prints the same
+	result, err := processor.Process(inputFile, filepath.Join(tempDir, "converted.pdf"))
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if result.Engine == EnginePDFCPU && fmt.Sprint(result.Layers) != fmt.Sprint(want) {
+		t.Errorf("result layers = %v, want %v", result.Layers, want)
+	}
+}
@@ -0,0 +1,177 @@
+package processor
+
+import (
+	"fmt"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// DIN A4 dimensions as required by LetterXpress
+const (
+	A4WidthMM      = 210.0
+	A4HeightMM     = 297.0
+	A4WidthPoints  = A4WidthMM * PointsPerMM
+	A4HeightPoints = A4HeightMM * PointsPerMM
+)
+
+// PagePlan describes how a single source page is placed on the output page
+type PagePlan struct {
+	Page int
+
+	// Visible size of the source page in points, after rotation
+	Width  float64
+	Height float64
+
+	// Effective /Rotate of the source page in degrees
+	Rotation int
+
+	// Applied scale and offset of the content on the output page, in points
+	ScaleX  float64
+	ScaleY  float64
+	OffsetX float64
+	OffsetY float64
+
+	// Size of the output page in points
+	OutputWidth  float64
+	OutputHeight float64
+
+	// ColorMode the page was rendered in by the raster engine, empty for vector output
+	ColorMode string
+
+	// Visible box of the source page in its own user space
+	box *types.Rectangle
+}
+
+// planPages computes the placement of every page of ctx for the given engine
+func (p *PDFProcessor) planPages(ctx *model.Context, engine string) ([]PagePlan, error) {
+	boundaries, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get page boundaries: %w", err)
+	}
+
+	plans := make([]PagePlan, 0, len(boundaries))
+	for i, pb := range boundaries {
+		box := pb.CropBox()
+		if box == nil {
+			return nil, fmt.Errorf("no MediaBox found on page %d", i+1)
+		}
+
+		rotation := ((pb.Rot % 360) + 360) % 360
+		width, height := box.Width(), box.Height()
+		if rotation%180 != 0 {
+			width, height = height, width
+		}
+
+		plan := PagePlan{
+			Page:     i + 1,
+			Width:    width,
+			Height:   height,
+			Rotation: rotation,
+			box:      box,
+		}
+		planPlacement(&plan, engine)
+		plans = append(plans, plan)
+	}
+
+	return plans, nil
+}
+
+// planPlacement fills in scale, offset and output size for the given engine
+func planPlacement(plan *PagePlan, engine string) {
+	switch engine {
+	case EngineImageMagickA4:
+		// The raster engine stretches each page to exactly fill the A4 content area
+		plan.OutputWidth = A4WidthPoints
+		plan.OutputHeight = A4HeightPoints
+		plan.ScaleX = (A4WidthPoints - 2*MarginPoints) / plan.Width
+		plan.ScaleY = (A4HeightPoints - 2*MarginPoints) / plan.Height
+		plan.OffsetX = MarginPoints
+		plan.OffsetY = MarginPoints
+
+	case EngineImageMagickBorder:
+		// The border engine keeps the content size and grows the page by a percentage border
+		border := MarginMM * 2 / 100
+		plan.OutputWidth = plan.Width * (1 + 2*border)
+		plan.OutputHeight = plan.Height * (1 + 2*border)
+		plan.ScaleX = 1
+		plan.ScaleY = 1
+		plan.OffsetX = plan.Width * border
+		plan.OffsetY = plan.Height * border
+
+	default:
+		// The vector engine scales uniformly into the A4 content area and centers the result
+		scaleX := (A4WidthPoints - 2*MarginPoints) / plan.Width
+		scaleY := (A4HeightPoints - 2*MarginPoints) / plan.Height
+
+		scale := scaleX
+		if scaleY < scaleX {
+			scale = scaleY
+		}
+
+		plan.OutputWidth = A4WidthPoints
+		plan.OutputHeight = A4HeightPoints
+		plan.ScaleX = scale
+		plan.ScaleY = scale
+		plan.OffsetX = (A4WidthPoints - plan.Width*scale) / 2
+		plan.OffsetY = (A4HeightPoints - plan.Height*scale) / 2
+	}
+}
+
+// contentMatrix returns the content stream operators that map the source
+// page's user space onto the output page according to the plan and clip to
+// the visible box of the source page
+func (plan PagePlan) contentMatrix() string {
+	return fmt.Sprintf("%s cm\n%.4f %.4f %.4f %.4f re W n\n", plan.transform(),
+		plan.box.LL.X, plan.box.LL.Y, plan.box.Width(), plan.box.Height())
+}
+
+// matrix is a PDF transformation matrix [a b c d e f]
+type matrix [6]float64
+
+// then returns the matrix that applies m first and n afterwards
+func (m matrix) then(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// invert returns the inverse of m
+func (m matrix) invert() matrix {
+	det := m[0]*m[3] - m[1]*m[2]
+	return matrix{
+		m[3] / det,
+		-m[1] / det,
+		-m[2] / det,
+		m[0] / det,
+		(m[2]*m[5] - m[3]*m[4]) / det,
+		(m[1]*m[4] - m[0]*m[5]) / det,
+	}
+}
+
+func (m matrix) String() string {
+	return fmt.Sprintf("%.6f %.6f %.6f %.6f %.4f %.4f", m[0], m[1], m[2], m[3], m[4], m[5])
+}
+
+// transform returns the matrix that maps the source page's user space onto
+// the output page, the same mapping contentMatrix applies
+func (plan PagePlan) transform() matrix {
+	w := plan.box.Width()
+	h := plan.box.Height()
+
+	m := matrix{1, 0, 0, 1, -plan.box.LL.X, -plan.box.LL.Y}
+	switch plan.Rotation {
+	case 90:
+		m = m.then(matrix{0, -1, 1, 0, 0, w})
+	case 180:
+		m = m.then(matrix{-1, 0, 0, -1, w, h})
+	case 270:
+		m = m.then(matrix{0, 1, -1, 0, h, 0})
+	}
+	return m.then(matrix{plan.ScaleX, 0, 0, plan.ScaleY, plan.OffsetX, plan.OffsetY})
+}
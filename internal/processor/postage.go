@@ -0,0 +1,182 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Envelope formats used by LetterXpress
+const (
+	EnvelopeDL = "DL"
+	EnvelopeC4 = "C4"
+)
+
+// Deutsche Post letter products
+const (
+	PostageStandard = "Standardbrief"
+	PostageKompakt  = "Kompaktbrief"
+	PostageGross    = "Großbrief"
+	PostageMaxi     = "Maxibrief"
+)
+
+// PriceTable maps postage classes to prices in EUR
+type PriceTable map[string]float64
+
+// DefaultPriceTable returns the Deutsche Post prices for domestic letters as
+// of 2025. Use LoadPriceTable for other prices.
+func DefaultPriceTable() PriceTable {
+	return PriceTable{
+		PostageStandard: 0.95,
+		PostageKompakt:  1.10,
+		PostageGross:    1.80,
+		PostageMaxi:     2.90,
+	}
+}
+
+// LoadPriceTable reads a JSON object mapping postage classes to prices.
+// Classes that are not listed keep their default price.
+func LoadPriceTable(path string) (PriceTable, error) {
+	table := DefaultPriceTable()
+	if path == "" {
+		return table, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read price table: %w", err)
+	}
+
+	var prices PriceTable
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return nil, fmt.Errorf("invalid price table %s: %w", path, err)
+	}
+	for class, price := range prices {
+		if _, ok := table[class]; !ok {
+			return nil, fmt.Errorf("invalid price table %s: unknown postage class %q", path, class)
+		}
+		table[class] = price
+	}
+
+	return table, nil
+}
+
+// PostageOptions describes how a letter is printed and sent
+type PostageOptions struct {
+	Duplex bool
+
+	// Grammage of the paper in g/m², 80 if not set
+	Grammage float64
+
+	// Envelope is EnvelopeDL (folded) or EnvelopeC4 (flat), DL if not set
+	Envelope string
+
+	// Prices to use, DefaultPriceTable if not set
+	Prices PriceTable
+}
+
+// PostageEstimate is the result of EstimatePostage
+type PostageEstimate struct {
+	Pages       int     `json:"pages"`
+	Sheets      int     `json:"sheets"`
+	Duplex      bool    `json:"duplex"`
+	Grammage    float64 `json:"grammage"`
+	Envelope    string  `json:"envelope"`
+	WeightG     float64 `json:"weight_g"`
+	ThicknessMM float64 `json:"thickness_mm"`
+	Class       string  `json:"class"`
+	PriceEUR    float64 `json:"price_eur"`
+}
+
+// Deutsche Post limits per product: maximum weight in g and thickness in mm.
+// Kompaktbrief and Standardbrief are limited to 235 × 125 mm, so a flat C4
+// envelope is always at least a Großbrief.
+var postageClasses = []struct {
+	class      string
+	maxWeight  float64
+	maxThick   float64
+	fitsC4Flat bool
+}{
+	{PostageStandard, 20, 5, false},
+	{PostageKompakt, 50, 10, false},
+	{PostageGross, 500, 20, true},
+	{PostageMaxi, 1000, 50, true},
+}
+
+// Envelope weights in g and the number of paper layers a sheet is folded into
+var envelopes = map[string]struct {
+	weight float64
+	folds  int
+}{
+	EnvelopeDL: {5, 3},
+	EnvelopeC4: {16, 1},
+}
+
+// a4AreaM2 is the area of an A4 sheet in m²
+const a4AreaM2 = A4WidthMM * A4HeightMM / 1e6
+
+// EstimatePostage computes sheets, weight and postage class of a letter with
+// the given number of A4 pages
+func EstimatePostage(pages int, opts PostageOptions) (*PostageEstimate, error) {
+	if opts.Grammage == 0 {
+		opts.Grammage = 80
+	}
+	if opts.Envelope == "" {
+		opts.Envelope = EnvelopeDL
+	}
+	if opts.Prices == nil {
+		opts.Prices = DefaultPriceTable()
+	}
+
+	envelope, ok := envelopes[opts.Envelope]
+	if !ok {
+		return nil, fmt.Errorf("unknown envelope %q, expected %s or %s", opts.Envelope, EnvelopeDL, EnvelopeC4)
+	}
+	if opts.Grammage < 0 || pages < 0 {
+		return nil, fmt.Errorf("invalid letter: %d pages on %.0f g/m² paper", pages, opts.Grammage)
+	}
+
+	sheets := pages
+	if opts.Duplex {
+		sheets = (pages + 1) / 2
+	}
+
+	// Office paper is about 0.1 mm thick at 80 g/m²
+	thickness := float64(sheets*envelope.folds) * opts.Grammage / 800
+
+	estimate := &PostageEstimate{
+		Pages:       pages,
+		Sheets:      sheets,
+		Duplex:      opts.Duplex,
+		Grammage:    opts.Grammage,
+		Envelope:    opts.Envelope,
+		WeightG:     math.Round((float64(sheets)*opts.Grammage*a4AreaM2+envelope.weight)*10) / 10,
+		ThicknessMM: math.Round(thickness*10) / 10,
+	}
+
+	for _, c := range postageClasses {
+		if opts.Envelope == EnvelopeC4 && !c.fitsC4Flat {
+			continue
+		}
+		if estimate.WeightG <= c.maxWeight && estimate.ThicknessMM <= c.maxThick {
+			estimate.Class = c.class
+			estimate.PriceEUR = opts.Prices[c.class]
+			return estimate, nil
+		}
+	}
+
+	return estimate, fmt.Errorf("letter with %d sheets (%.0f g) exceeds the limits of a %s", sheets, estimate.WeightG, PostageMaxi)
+}
+
+// estimatePostage records the postage estimate of the converted letter on result
+func (p *PDFProcessor) estimatePostage(opts Options, result *Result) {
+	postageOpts := opts.Postage
+	postageOpts.Duplex = opts.Duplex
+
+	estimate, err := EstimatePostage(result.PageCount, postageOpts)
+	if err != nil {
+		result.warn("postage: %v", err)
+	}
+	result.Postage = estimate
+}
@@ -0,0 +1,241 @@
+package processor
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PreviewOptions controls how previews are rendered
+type PreviewOptions struct {
+	// DPI of the rendered pages, kept low so previews stay small
+	DPI int
+
+	// OutputDir receives the rendered PNG files
+	OutputDir string
+
+	// ContactSheet additionally combines all pages into a single image
+	ContactSheet bool
+
+	// HTML additionally writes an index.html showing all pages
+	HTML bool
+
+	// SourcePages is the original page shown next to every converted page,
+	// 0 for a blank page inserted for duplex printing, as returned by
+	// Result.SourcePages. Nil pairs the pages by number.
+	SourcePages []int
+}
+
+// DefaultPreviewOptions returns the options used when none are given
+func DefaultPreviewOptions(outputDir string) PreviewOptions {
+	return PreviewOptions{
+		DPI:       50,
+		OutputDir: outputDir,
+	}
+}
+
+// PreviewResult lists the files written by Preview
+type PreviewResult struct {
+	Pages        []string
+	ContactSheet string
+	HTML         string
+}
+
+// Preview renders every page of the converted PDF next to the original page
+// it was made from. The converted page is outlined with the 5mm safe area and
+// the DIN 5008 address window. Inserted blank pages are shown next to an
+// empty page.
+func (p *PDFProcessor) Preview(originalFile, convertedFile string, opts PreviewOptions) (*PreviewResult, error) {
+	logrus.WithFields(logrus.Fields{
+		"original":  originalFile,
+		"converted": convertedFile,
+		"outputDir": opts.OutputDir,
+		"dpi":       opts.DPI,
+	}).Info("Rendering preview")
+
+	if _, err := exec.LookPath("convert"); err != nil {
+		logrus.WithError(err).Error("ImageMagick not found")
+		return nil, fmt.Errorf("%w: imagemagick not available: %w", ErrToolMissing, err)
+	}
+
+	if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+		return nil, fmt.Errorf("%w: cannot create preview directory: %w", ErrOutputNotWritable, err)
+	}
+
+	ctx, err := p.readContextFile(convertedFile)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PreviewResult{}
+	var tempFiles []string
+	defer func() { p.cleanupFiles(tempFiles) }()
+
+	for i := 0; i < ctx.PageCount; i++ {
+		originalPNG := filepath.Join(opts.OutputDir, fmt.Sprintf("temp_original_%d.png", i))
+		convertedPNG := filepath.Join(opts.OutputDir, fmt.Sprintf("temp_converted_%d.png", i))
+		pagePNG := filepath.Join(opts.OutputDir, fmt.Sprintf("page_%03d.png", i+1))
+		tempFiles = append(tempFiles, originalPNG, convertedPNG)
+
+		if source := previewSource(opts.SourcePages, i+1); source == 0 {
+			if err := p.renderBlankPage(opts.DPI, originalPNG); err != nil {
+				return nil, err
+			}
+		} else if err := p.renderPage(originalFile, source-1, opts.DPI, originalPNG); err != nil {
+			return nil, err
+		}
+		if err := p.renderPage(convertedFile, i, opts.DPI, convertedPNG); err != nil {
+			return nil, err
+		}
+
+		// Draw the guides onto the converted page and put both pages next to each other
+		args := []string{originalPNG, "(", convertedPNG}
+		args = append(args, guideDrawArgs(opts.DPI)...)
+		args = append(args, ")", "-background", "lightgray", "-gravity", "west", "-splice", "10x0", "+append", pagePNG)
+		if err := p.runImageMagick("Combining preview page", args...); err != nil {
+			return nil, fmt.Errorf("%w: preview of page %d failed: %w", ErrEngineFailed, i+1, err)
+		}
+
+		result.Pages = append(result.Pages, pagePNG)
+	}
+
+	if opts.ContactSheet && len(result.Pages) > 0 {
+		result.ContactSheet = filepath.Join(opts.OutputDir, "contact_sheet.png")
+		args := append([]string{}, result.Pages...)
+		args = append(args, "-background", "white", "-gravity", "north", "-splice", "0x20", "-append", result.ContactSheet)
+		if err := p.runImageMagick("Creating contact sheet", args...); err != nil {
+			return nil, fmt.Errorf("%w: contact sheet failed: %w", ErrEngineFailed, err)
+		}
+	}
+
+	if opts.HTML {
+		result.HTML = filepath.Join(opts.OutputDir, "index.html")
+		if err := writePreviewHTML(result.HTML, originalFile, result.Pages); err != nil {
+			return nil, fmt.Errorf("%w: cannot write preview page: %w", ErrOutputNotWritable, err)
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"outputDir": opts.OutputDir,
+		"pages":     len(result.Pages),
+	}).Info("Successfully rendered preview")
+
+	return result, nil
+}
+
+// renderPage rasterizes a single page of a PDF to PNG
+func (p *PDFProcessor) renderPage(inputFile string, page, dpi int, outputFile string) error {
+	err := p.runImageMagick("Rendering page",
+		"-density", fmt.Sprintf("%d", dpi),
+		fmt.Sprintf("%s[%d]", inputFile, page),
+		"-background", "white",
+		"-flatten",
+		outputFile,
+	)
+	if err != nil {
+		return fmt.Errorf("%w: rendering page %d of %s failed: %w", ErrEngineFailed, page+1, inputFile, err)
+	}
+	return nil
+}
+
+// renderBlankPage writes an empty A4 page at dpi, shown in place of the
+// original of an inserted blank page
+func (p *PDFProcessor) renderBlankPage(dpi int, outputFile string) error {
+	size := fmt.Sprintf("%dx%d", int(A4WidthMM*float64(dpi)/25.4), int(A4HeightMM*float64(dpi)/25.4))
+	if err := p.runImageMagick("Rendering blank page", "-size", size, "xc:white", outputFile); err != nil {
+		return fmt.Errorf("%w: rendering blank page failed: %w", ErrEngineFailed, err)
+	}
+	return nil
+}
+
+// previewSource returns the original page of the converted page, 0 for an
+// inserted blank page
+func previewSource(sourcePages []int, page int) int {
+	if sourcePages == nil || page > len(sourcePages) {
+		return page
+	}
+	return sourcePages[page-1]
+}
+
+// guideDrawArgs returns ImageMagick arguments that outline the safe area and
+// the address window on an A4 page rendered at dpi
+func guideDrawArgs(dpi int) []string {
+	px := func(mm float64) int {
+		return int(mm * float64(dpi) / 25.4)
+	}
+
+	safeArea := fmt.Sprintf("rectangle %d,%d %d,%d",
+		px(MarginMM), px(MarginMM), px(A4WidthMM-MarginMM), px(A4HeightMM-MarginMM))
+	addressWindow := fmt.Sprintf("rectangle %d,%d %d,%d",
+		px(AddressWindowLeftMM), px(AddressWindowTopMM),
+		px(AddressWindowLeftMM+AddressWindowWidthMM), px(AddressWindowTopMM+AddressWindowHeightMM))
+
+	return []string{
+		"-fill", "none",
+		"-strokewidth", "1",
+		"-stroke", "red", "-draw", safeArea,
+		"-stroke", "blue", "-draw", addressWindow,
+	}
+}
+
+// runImageMagick runs ImageMagick's convert with the given arguments
+func (p *PDFProcessor) runImageMagick(description string, args ...string) error {
+	cmd := exec.Command("convert", args...)
+	logrus.WithField("command", strings.Join(cmd.Args, " ")).Debug(description)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		logrus.WithError(err).WithField("output", string(output)).Error(description + " failed")
+		return err
+	}
+	return nil
+}
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Preview: {{.Title}}</title>
+<style>
+body { font-family: sans-serif; background: #eee; }
+figure { margin: 1em 0; }
+img { border: 1px solid #999; background: white; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Left: original, right: converted. Red: 5 mm safe area, blue: DIN 5008 address window.</p>
+{{range $i, $page := .Pages}}<figure>
+<img src="{{$page}}" alt="Page {{$i}}">
+<figcaption>Page {{$i}}</figcaption>
+</figure>
+{{end}}</body>
+</html>
+`))
+
+// writePreviewHTML writes an HTML page that shows all rendered preview pages
+func writePreviewHTML(filename, title string, pages []string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data := struct {
+		Title string
+		Pages map[int]string
+	}{
+		Title: filepath.Base(title),
+		Pages: make(map[int]string),
+	}
+	for i, page := range pages {
+		data.Pages[i+1] = filepath.Base(page)
+	}
+
+	return previewTemplate.Execute(f, data)
+}
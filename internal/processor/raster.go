@@ -0,0 +1,136 @@
+package processor
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// Color modes of the raster engine
+const (
+	ColorModeColor     = "color"
+	ColorModeGrayscale = "grayscale"
+	ColorModeBitonal   = "bitonal"
+
+	// ColorModeAuto picks one of the other modes per page
+	ColorModeAuto = "auto"
+)
+
+// Raster defaults, matching the output of earlier versions
+const (
+	DefaultRasterDPI   = 300
+	DefaultJPEGQuality = 95
+)
+
+// RasterOptions controls how the raster engine renders and compresses pages
+type RasterOptions struct {
+	// ColorMode is one of the ColorMode constants, ColorModeColor if not set
+	ColorMode string
+
+	// DPI is the rendering resolution, DefaultRasterDPI if not set
+	DPI int
+
+	// JPEGQuality applies to color and grayscale pages, DefaultJPEGQuality if not set
+	JPEGQuality int
+
+	// NoColor renders pages that would be color in grayscale. It is set
+	// when fitting the output into a size limit.
+	NoColor bool
+}
+
+// withDefaults fills in the defaults of unset fields
+func (o RasterOptions) withDefaults() RasterOptions {
+	if o.ColorMode == "" {
+		o.ColorMode = ColorModeColor
+	}
+	if o.DPI == 0 {
+		o.DPI = DefaultRasterDPI
+	}
+	if o.JPEGQuality == 0 {
+		o.JPEGQuality = DefaultJPEGQuality
+	}
+	return o
+}
+
+// Validate checks color mode, resolution and quality
+func (o RasterOptions) Validate() error {
+	o = o.withDefaults()
+
+	switch o.ColorMode {
+	case ColorModeColor, ColorModeGrayscale, ColorModeBitonal, ColorModeAuto:
+	default:
+		return fmt.Errorf("unknown color mode %q, expected %s, %s, %s or %s",
+			o.ColorMode, ColorModeColor, ColorModeGrayscale, ColorModeBitonal, ColorModeAuto)
+	}
+	if o.DPI < 72 || o.DPI > 1200 {
+		return fmt.Errorf("invalid resolution %d dpi, expected 72 to 1200", o.DPI)
+	}
+	if o.JPEGQuality < 1 || o.JPEGQuality > 100 {
+		return fmt.Errorf("invalid JPEG quality %d, expected 1 to 100", o.JPEGQuality)
+	}
+	return nil
+}
+
+// pageColorModes returns the color mode of every page of ctx. In auto mode
+// pages with images or shadings stay color, or grayscale if their images are
+// gray, and everything else, e.g. plain text, goes bitonal. Pages with
+// colored text or graphics stay color.
+func (p *PDFProcessor) pageColorModes(ctx *model.Context, opts RasterOptions) ([]string, error) {
+	opts = opts.withDefaults()
+
+	modes := make([]string, ctx.PageCount)
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		mode, err := p.pageColorMode(ctx, pageNr, opts.ColorMode)
+		if err != nil {
+			return nil, err
+		}
+		if opts.NoColor && mode == ColorModeColor {
+			mode = ColorModeGrayscale
+		}
+		modes[pageNr-1] = mode
+	}
+
+	return modes, nil
+}
+
+// pageColorMode returns the color mode of a single page
+func (p *PDFProcessor) pageColorMode(ctx *model.Context, pageNr int, colorMode string) (string, error) {
+	if colorMode != ColorModeAuto {
+		return colorMode, nil
+	}
+
+	content, err := p.analyzePage(ctx, pageNr)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case content.Color || (content.Images && content.ColorUncertain):
+		return ColorModeColor, nil
+	case content.Images:
+		return ColorModeGrayscale, nil
+	case content.ColorUncertain:
+		// ICC based or spot colors without images
+		return ColorModeColor, nil
+	default:
+		return ColorModeBitonal, nil
+	}
+}
+
+// rasterPageArgs returns the ImageMagick arguments that convert a rendered
+// page to the given color mode and set its PDF compression
+func rasterPageArgs(mode string, opts RasterOptions) []string {
+	opts = opts.withDefaults()
+	quality := strconv.Itoa(opts.JPEGQuality)
+
+	switch mode {
+	case ColorModeGrayscale:
+		return []string{"-colorspace", "Gray", "-compress", "jpeg", "-quality", quality}
+	case ColorModeBitonal:
+		// CCITT Group 4 is the bilevel compression ImageMagick writes to PDF
+		return []string{"-colorspace", "Gray", "-threshold", "50%", "-type", "bilevel", "-compress", "Group4"}
+	default:
+		return []string{"-compress", "jpeg", "-quality", quality}
+	}
+}
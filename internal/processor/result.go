@@ -0,0 +1,146 @@
+package processor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Engine names as reported in results and reports
+const (
+	EngineImageMagickA4     = "imagemagick-a4"
+	EngineImageMagickBorder = "imagemagick-border"
+	EnginePDFCPU            = "pdfcpu"
+)
+
+// Result describes the outcome of a single conversion
+type Result struct {
+	InputFile  string
+	OutputFile string
+
+	// Encrypted is set when the input was encrypted, Permissions lists the
+	// user access permissions it granted
+	Encrypted   bool
+	Permissions []string
+
+	// Engine is the engine of the fallback chain that produced the output
+	Engine    string
+	PageCount int
+	Pages     []PagePlan
+	Warnings  []string
+
+	// Sections lists the documents of a combined letter
+	Sections []Section
+
+	// Duplex is set when the letter was prepared for duplex printing
+	Duplex bool
+
+	// BlankPages are the input pages found to be blank, RemovedPages those
+	// of them that were dropped
+	BlankPages   []int
+	RemovedPages []int
+
+	// InsertedPages are the output pages added as blank back sides
+	InsertedPages []int
+
+	// ColorPages are the output pages that print in color
+	ColorPages []int
+
+	// Grayscale is set when the output was converted to black-and-white
+	Grayscale bool
+
+	// Postage is the estimated postage of the converted letter
+	Postage *PostageEstimate
+
+	// Raster are the final settings of the raster engine, zero for other engines
+	Raster RasterOptions
+
+	// MaxSize is the size limit of the output, SizeAttempts the passes made
+	// to stay within it
+	MaxSize      int64
+	SizeAttempts []SizeAttempt
+
+	// FontIssues are the font problems left in the output. EmbeddedFonts
+	// and RasterizedPages record how others were fixed.
+	FontIssues      []FontIssue
+	EmbeddedFonts   []string
+	RasterizedPages []int
+
+	// Images are the pages made from an image input
+	Images []ImagePage
+
+	// Invoice is the e-invoice XML of the input carried into the output
+	Invoice *Invoice
+
+	// Sanitized lists the active and hidden content removed from the output
+	Sanitized []string
+
+	// ArchiveFile is the PDF/A copy written by WriteArchive, if any, and
+	// ArchiveLevel its conformance level
+	ArchiveFile  string
+	ArchiveLevel string
+
+	// FlattenedAnnotations is the number of annotations and form fields
+	// drawn into the page content, DroppedAnnotations of those removed
+	// because they do not print
+	FlattenedAnnotations int
+	DroppedAnnotations   int
+
+	// Layers are the optional content groups of the input, flattened to
+	// their print state by the vector engine
+	Layers []Layer
+
+	// TransparentPages are the pages of the vector engine's output that use
+	// transparency, TransparencyFlattened is set when they were rasterized
+	TransparentPages      []int
+	TransparencyFlattened bool
+
+	// DebugOverlayFile is the annotated copy written by WriteDebugOverlay, if any
+	DebugOverlayFile string
+
+	// DryRun is set when the result is only a plan and nothing was written
+	DryRun bool
+
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Duration returns the wall time the conversion took
+func (r *Result) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// SourcePages returns the input page of every output page, 0 for the blank
+// pages inserted for duplex printing
+func (r *Result) SourcePages() []int {
+	inserted := make(map[int]bool, len(r.InsertedPages))
+	for _, page := range r.InsertedPages {
+		inserted[page] = true
+	}
+	removed := make(map[int]bool, len(r.RemovedPages))
+	for _, page := range r.RemovedPages {
+		removed[page] = true
+	}
+
+	sources := make([]int, r.PageCount)
+	input := 0
+	for i := range sources {
+		if inserted[i+1] {
+			continue
+		}
+		input++
+		for removed[input] {
+			input++
+		}
+		sources[i] = input
+	}
+	return sources
+}
+
+// warn records a warning on the result and logs it
+func (r *Result) warn(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	r.Warnings = append(r.Warnings, msg)
+	logrus.Warn(msg)
+}
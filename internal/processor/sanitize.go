@@ -0,0 +1,373 @@
+package processor
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"github.com/sirupsen/logrus"
+)
+
+// SanitizeResult lists what Sanitize removed from a PDF
+type SanitizeResult struct {
+	File       string   `json:"file"`
+	OutputFile string   `json:"output_file"`
+	Removed    []string `json:"removed"`
+}
+
+// Sanitize writes a copy of inputFile to outputFile without active and
+// hidden content, see sanitize
+func (p *PDFProcessor) Sanitize(inputFile, outputFile string) (*SanitizeResult, error) {
+	logrus.WithField("input", inputFile).Info("Sanitizing PDF")
+
+	result := &SanitizeResult{File: inputFile, OutputFile: outputFile, Removed: []string{}}
+
+	// Encrypted inputs are sanitized from a decrypted copy
+	sourceFile, cleanup, err := p.openInput(inputFile, filepath.Dir(outputFile), &Result{})
+	if err != nil {
+		return result, err
+	}
+	defer cleanup()
+
+	ctx, err := p.readContextFile(sourceFile)
+	if err != nil {
+		return result, err
+	}
+	invoice, err := readInvoice(ctx)
+	if err != nil {
+		return result, err
+	}
+	removed, err := sanitize(ctx)
+	if err != nil {
+		return result, err
+	}
+	// The invoice keeps its description in the XMP metadata
+	if invoice != nil {
+		if err := embedInvoice(ctx, invoice); err != nil {
+			return result, fmt.Errorf("failed to embed %s: %w", invoice.Name, err)
+		}
+	}
+	if err := writeContextInPlace(ctx, outputFile); err != nil {
+		return result, err
+	}
+	result.Removed = append(result.Removed, removed...)
+	return result, nil
+}
+
+// sanitizeOutput sanitizes the output in place and records what was removed
+func (p *PDFProcessor) sanitizeOutput(outputFile string, result *Result) error {
+	ctx, err := p.readContextFile(outputFile)
+	if err != nil {
+		return err
+	}
+	removed, err := sanitize(ctx)
+	if err != nil {
+		return err
+	}
+	result.Sanitized = removed
+	if len(removed) == 0 {
+		return nil
+	}
+	return writeContextInPlace(ctx, outputFile)
+}
+
+// sanitize removes JavaScript, actions, embedded files other than e-invoice
+// XML, link and file attachment annotations, the document information and
+// XMP metadata from ctx. Objects left unused are not written. It returns what was removed,
+// e.g. `embedded file "draft.docx"`.
+func sanitize(ctx *model.Context) ([]string, error) {
+	if err := decodeObjectStreams(ctx); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPDF, err)
+	}
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get catalog: %w", err)
+	}
+
+	// Only count what was unused before, not what the removals leave behind
+	unused := unusedObjects(ctx)
+
+	var removed []string
+	if names, err := ctx.DereferenceDict(catalog["Names"]); err == nil && names != nil {
+		var scripts []string
+		for _, entry := range nameTreeEntries(ctx, names["JavaScript"], 0) {
+			scripts = append(scripts, entry.key)
+		}
+		if len(scripts) > 0 {
+			removed = append(removed, fmt.Sprintf("JavaScript %s", quoteAll(scripts)))
+		}
+		names.Delete("JavaScript")
+
+		// E-invoice XML is part of the letter, not hidden content
+		invoices := types.Array{}
+		for _, entry := range nameTreeEntries(ctx, names["EmbeddedFiles"], 0) {
+			if isInvoiceFile(entry.key) {
+				invoices = append(invoices, types.StringLiteral(entry.key), entry.value)
+				continue
+			}
+			removed = append(removed, fmt.Sprintf("embedded file %q", entry.key))
+		}
+		names.Delete("EmbeddedFiles")
+		if len(invoices) > 0 {
+			names.Update("EmbeddedFiles", types.Dict{"Names": invoices})
+		}
+	}
+
+	if af, err := ctx.DereferenceArray(catalog["AF"]); err == nil && af != nil {
+		invoices := types.Array{}
+		for _, obj := range af {
+			if filespec, err := ctx.DereferenceDict(obj); err == nil && filespec != nil && isInvoiceFile(filespecName(ctx, filespec)) {
+				invoices = append(invoices, obj)
+			}
+		}
+		catalog.Delete("AF")
+		if len(invoices) > 0 {
+			catalog.Update("AF", invoices)
+		}
+		if len(invoices) < len(af) {
+			removed = append(removed, "associated files")
+		}
+	}
+
+	for key, what := range map[string]string{
+		"OpenAction": "open action",
+		"AA":         "document actions",
+		"Metadata":   "XMP metadata",
+		"PieceInfo":  "application data",
+	} {
+		if _, found := catalog.Find(key); found {
+			catalog.Delete(key)
+			removed = append(removed, what)
+		}
+	}
+
+	if fields := infoFields(ctx); len(fields) > 0 {
+		removed = append(removed, "document information "+strings.Join(fields, ", "))
+	}
+	ctx.Info = nil
+
+	if n := sanitizeOutlines(ctx, catalog); n > 0 {
+		removed = append(removed, fmt.Sprintf("%d bookmark actions", n))
+	}
+
+	counts := make(map[string]int)
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		if err := sanitizePage(ctx, pageNr, counts); err != nil {
+			return nil, err
+		}
+	}
+	for _, what := range []string{"link annotations", "file attachments", "annotation actions", "page actions", "page metadata"} {
+		if counts[what] > 0 {
+			removed = append(removed, fmt.Sprintf("%d %s", counts[what], what))
+		}
+	}
+
+	if unused > 0 {
+		removed = append(removed, fmt.Sprintf("%d unused objects", unused))
+	}
+
+	sort.Strings(removed)
+	logrus.WithField("removed", len(removed)).Info("Sanitized PDF")
+	return removed, nil
+}
+
+// sanitizePage removes the actions, metadata, links and file attachments
+// of a page and counts them
+func sanitizePage(ctx *model.Context, pageNr int, counts map[string]int) error {
+	pageDict, _, _, err := ctx.PageDict(pageNr, false)
+	if err != nil {
+		return fmt.Errorf("failed to get page dict: %w", err)
+	}
+	if _, found := pageDict.Find("AA"); found {
+		pageDict.Delete("AA")
+		counts["page actions"]++
+	}
+	for _, key := range []string{"Metadata", "PieceInfo"} {
+		if _, found := pageDict.Find(key); found {
+			pageDict.Delete(key)
+			counts["page metadata"]++
+		}
+	}
+
+	annots, err := ctx.DereferenceArray(pageDict["Annots"])
+	if err != nil || annots == nil {
+		return nil
+	}
+	kept := types.Array{}
+	for _, obj := range annots {
+		annot, err := ctx.DereferenceDict(obj)
+		if err != nil || annot == nil {
+			continue
+		}
+		switch subtype := annot.Subtype(); {
+		case subtype != nil && *subtype == "Link":
+			counts["link annotations"]++
+			continue
+		case subtype != nil && *subtype == "FileAttachment":
+			counts["file attachments"]++
+			continue
+		}
+		for _, key := range []string{"A", "AA"} {
+			if _, found := annot.Find(key); found {
+				annot.Delete(key)
+				counts["annotation actions"]++
+			}
+		}
+		kept = append(kept, obj)
+	}
+	if len(kept) == 0 {
+		pageDict.Delete("Annots")
+	} else {
+		pageDict.Update("Annots", kept)
+	}
+	return nil
+}
+
+// sanitizeOutlines removes the actions of bookmarks other than go-to
+// actions within the document and returns their number
+func sanitizeOutlines(ctx *model.Context, catalog types.Dict) int {
+	outlines, err := ctx.DereferenceDict(catalog["Outlines"])
+	if err != nil || outlines == nil {
+		return 0
+	}
+
+	removed := 0
+	var walk func(obj types.Object, depth int)
+	walk = func(obj types.Object, depth int) {
+		// Siblings are followed iteratively, only children recurse
+		for i := 0; obj != nil && i < 10000; i++ {
+			item, err := ctx.DereferenceDict(obj)
+			if err != nil || item == nil {
+				return
+			}
+			if action, err := ctx.DereferenceDict(item["A"]); err == nil && action != nil {
+				if s := action.NameEntry("S"); s == nil || *s != "GoTo" {
+					item.Delete("A")
+					removed++
+				}
+			}
+			if depth < maxFormDepth {
+				walk(item["First"], depth+1)
+			}
+			obj = item["Next"]
+		}
+	}
+	walk(outlines["First"], 0)
+	return removed
+}
+
+// nameTreeEntry is a key and its value in a name tree
+type nameTreeEntry struct {
+	key   string
+	value types.Object
+}
+
+// nameTreeEntries returns the entries of a name tree in key order
+func nameTreeEntries(ctx *model.Context, obj types.Object, depth int) []nameTreeEntry {
+	node, err := ctx.DereferenceDict(obj)
+	if err != nil || node == nil || depth >= maxFormDepth {
+		return nil
+	}
+
+	var entries []nameTreeEntry
+	if names, err := ctx.DereferenceArray(node["Names"]); err == nil {
+		for i := 0; i+1 < len(names); i += 2 {
+			key, err := ctx.Dereference(names[i])
+			if err != nil {
+				continue
+			}
+			if s, err := types.StringOrHexLiteral(key); err == nil && s != nil {
+				entries = append(entries, nameTreeEntry{key: *s, value: names[i+1]})
+			}
+		}
+	}
+	if kids, err := ctx.DereferenceArray(node["Kids"]); err == nil {
+		for _, kid := range kids {
+			entries = append(entries, nameTreeEntries(ctx, kid, depth+1)...)
+		}
+	}
+	return entries
+}
+
+// infoFields returns the non-empty entries of the document information
+// other than those pdfcpu writes anyway
+func infoFields(ctx *model.Context) []string {
+	var fields []string
+	for key := range documentInfo(ctx) {
+		fields = append(fields, key)
+	}
+	if ctx.Info != nil {
+		if d, err := ctx.DereferenceDict(*ctx.Info); err == nil && d != nil {
+			for key := range d {
+				switch key {
+				case "Title", "Author", "Subject", "Keywords", "Creator", "Producer", "CreationDate", "ModDate":
+				default:
+					fields = append(fields, key)
+				}
+			}
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// unusedObjects returns the number of objects of ctx that cannot be reached
+// from the trailer. pdfcpu does not write them.
+func unusedObjects(ctx *model.Context) int {
+	reached := make(map[int]bool)
+	var visit func(obj types.Object)
+	visit = func(obj types.Object) {
+		switch obj := obj.(type) {
+		case types.IndirectRef:
+			objNr := obj.ObjectNumber.Value()
+			if reached[objNr] {
+				return
+			}
+			reached[objNr] = true
+			if entry, found := ctx.FindTableEntryForIndRef(&obj); found && entry != nil {
+				visit(entry.Object)
+			}
+		case types.Dict:
+			for _, v := range obj {
+				visit(v)
+			}
+		case types.StreamDict:
+			visit(obj.Dict)
+		case types.Array:
+			for _, v := range obj {
+				visit(v)
+			}
+		}
+	}
+	for _, ref := range []*types.IndirectRef{ctx.Root, ctx.Info, ctx.Encrypt} {
+		if ref != nil {
+			visit(*ref)
+		}
+	}
+
+	unused := 0
+	for objNr, entry := range ctx.Table {
+		if objNr == 0 || reached[objNr] || entry == nil || entry.Free || entry.Object == nil {
+			continue
+		}
+		// Object and cross-reference streams are rebuilt on writing
+		switch entry.Object.(type) {
+		case types.ObjectStreamDict, types.XRefStreamDict:
+			continue
+		}
+		unused++
+	}
+	return unused
+}
+
+// quoteAll returns the quoted strings separated by commas
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,132 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
+)
+
+// Quality floor when fitting the output into a size limit
+const (
+	MinJPEGQuality = 50
+	MinRasterDPI   = 150
+)
+
+// SizeAttempt records the raster settings and output size of one pass of
+// the raster engine
+type SizeAttempt struct {
+	Raster RasterOptions
+	Size   int64
+}
+
+// sizeSteps returns the raster settings to try, in order, when the output
+// produced with raster is too large: first lower JPEG quality, then lower
+// resolution and finally grayscale instead of color
+func sizeSteps(raster RasterOptions) []RasterOptions {
+	raster = raster.withDefaults()
+
+	var steps []RasterOptions
+	for _, quality := range []int{85, 75, 60, MinJPEGQuality} {
+		if quality < raster.JPEGQuality {
+			raster.JPEGQuality = quality
+			steps = append(steps, raster)
+		}
+	}
+	for _, dpi := range []int{200, MinRasterDPI} {
+		if dpi < raster.DPI {
+			raster.DPI = dpi
+			steps = append(steps, raster)
+		}
+	}
+	if !raster.NoColor && raster.ColorMode != ColorModeGrayscale && raster.ColorMode != ColorModeBitonal {
+		raster.NoColor = true
+		steps = append(steps, raster)
+	}
+	return steps
+}
+
+// fitOutputSize renders the output again with lower quality settings until
+// it fits into maxSize or the quality floor is reached
+func (p *PDFProcessor) fitOutputSize(inputFile, outputFile string, maxSize int64, result *Result) error {
+	if maxSize <= 0 {
+		return nil
+	}
+
+	size, err := utils.GetFileSize(outputFile)
+	if err != nil {
+		return fmt.Errorf("%w: cannot read output: %w", ErrOutputNotWritable, err)
+	}
+	p.recordSizeAttempt(result, result.Raster, size, maxSize)
+	if size <= maxSize {
+		return nil
+	}
+
+	if result.Engine != EngineImageMagickA4 {
+		return fmt.Errorf("%w: output is %s, limit is %s, and engine %s cannot reduce its quality",
+			ErrTooLarge, utils.FormatSize(size), utils.FormatSize(maxSize), result.Engine)
+	}
+
+	for _, raster := range sizeSteps(result.Raster) {
+		if err := p.createMarginsForLetterXpress(inputFile, outputFile, raster); err != nil {
+			return fmt.Errorf("%w: retry with lower quality failed: %w", ErrEngineFailed, err)
+		}
+		result.Raster = raster
+
+		if size, err = utils.GetFileSize(outputFile); err != nil {
+			return fmt.Errorf("%w: cannot read output: %w", ErrOutputNotWritable, err)
+		}
+		p.recordSizeAttempt(result, raster, size, maxSize)
+		if size <= maxSize {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: output is %s at the quality floor, limit is %s",
+		ErrTooLarge, utils.FormatSize(size), utils.FormatSize(maxSize))
+}
+
+// recordSizeAttempt logs one pass of the size fitting and records it on result
+func (p *PDFProcessor) recordSizeAttempt(result *Result, raster RasterOptions, size, maxSize int64) {
+	result.SizeAttempts = append(result.SizeAttempts, SizeAttempt{Raster: raster, Size: size})
+
+	logrus.WithFields(logrus.Fields{
+		"attempt":     len(result.SizeAttempts),
+		"colorMode":   raster.ColorMode,
+		"noColor":     raster.NoColor,
+		"dpi":         raster.DPI,
+		"jpegQuality": raster.JPEGQuality,
+		"size":        utils.FormatSize(size),
+		"maxSize":     utils.FormatSize(maxSize),
+		"fits":        size <= maxSize,
+	}).Info("Checked output size")
+}
+
+// checkOutputSize fails if the final output exceeds maxSize
+func checkOutputSize(outputFile string, maxSize int64) error {
+	if maxSize <= 0 {
+		return nil
+	}
+	size, err := utils.GetFileSize(outputFile)
+	if err != nil {
+		return fmt.Errorf("%w: cannot read output: %w", ErrOutputNotWritable, err)
+	}
+	if size > maxSize {
+		return fmt.Errorf("%w: output is %s, limit is %s", ErrTooLarge, utils.FormatSize(size), utils.FormatSize(maxSize))
+	}
+	return nil
+}
+
+// discardOversized removes the output if err says that it does not fit the
+// size limit, so that it cannot be sent by mistake
+func discardOversized(outputFile string, err error) error {
+	if errors.Is(err, ErrTooLarge) {
+		if removeErr := os.Remove(outputFile); removeErr != nil && !os.IsNotExist(removeErr) {
+			logrus.WithError(removeErr).WithField("output", outputFile).Warn("Cannot remove oversized output")
+		}
+	}
+	return err
+}
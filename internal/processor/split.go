@@ -0,0 +1,300 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/sirupsen/logrus"
+)
+
+// Ways of finding the letter boundaries in a combined PDF
+const (
+	SplitByPages     = "pages"
+	SplitByBookmarks = "bookmarks"
+	SplitByText      = "text"
+	SplitByBlank     = "blank"
+)
+
+// SplitOptions controls Split
+type SplitOptions struct {
+	// Mode is one of the SplitBy constants
+	Mode string
+
+	// Pages is the fixed page count per letter for SplitByPages
+	Pages int
+
+	// Pattern marks the first page of each letter for SplitByText. The
+	// first capture group, or the whole match, is available as {{match}}.
+	Pattern *regexp.Regexp
+
+	// OutputDir receives the converted letters
+	OutputDir string
+
+	// NameTemplate names the letters using {{name}}, {{part}}, {{first_page}},
+	// {{last_page}}, {{title}} and {{match}}
+	NameTemplate string
+}
+
+// SplitPart describes one letter cut out of the input
+type SplitPart struct {
+	Part      int    `json:"part"`
+	FirstPage int    `json:"first_page"`
+	LastPage  int    `json:"last_page"`
+	Title     string `json:"title,omitempty"`
+	Match     string `json:"match,omitempty"`
+	File      string `json:"file"`
+
+	Result *Result `json:"-"`
+}
+
+// SplitResult describes the output of Split
+type SplitResult struct {
+	InputFile string      `json:"input_file"`
+	Parts     []SplitPart `json:"parts"`
+}
+
+// Split cuts inputFile into individual letters and converts each of them
+func (p *PDFProcessor) Split(inputFile string, opts SplitOptions) (*SplitResult, error) {
+	logrus.WithFields(logrus.Fields{
+		"input": inputFile,
+		"mode":  opts.Mode,
+	}).Info("Splitting PDF into letters")
+
+	tempDir, err := os.MkdirTemp("", "pdf2letterexpress-split-")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Encrypted inputs are split from a decrypted copy in tempDir
+	sourceFile, _, err := p.openInput(inputFile, tempDir, &Result{})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := p.readContextFile(sourceFile)
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := p.findSplitParts(ctx, sourceFile, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no letters found in %s", inputFile)
+	}
+
+	base := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
+	if opts.NameTemplate == "" {
+		opts.NameTemplate = "{{name}} - {{part}}"
+	}
+
+	result := &SplitResult{InputFile: inputFile}
+	usedNames := make(map[string]bool)
+
+	for i := range parts {
+		part := &parts[i]
+		part.Part = i + 1
+
+		fields := map[string]string{
+			"name":       base,
+			"part":       fmt.Sprintf("%03d", part.Part),
+			"first_page": strconv.Itoa(part.FirstPage),
+			"last_page":  strconv.Itoa(part.LastPage),
+			"title":      part.Title,
+			"match":      part.Match,
+		}
+		part.File = letterFilename(opts.OutputDir, opts.NameTemplate, fields, part.Part, usedNames)
+
+		pageRange := fmt.Sprintf("%d-%d", part.FirstPage, part.LastPage)
+		extractedFile := filepath.Join(tempDir, fmt.Sprintf("part_%d.pdf", part.Part))
+		if err := api.TrimFile(sourceFile, extractedFile, []string{pageRange}, p.config); err != nil {
+			return result, fmt.Errorf("failed to extract pages %s: %w", pageRange, err)
+		}
+
+		partResult, err := p.Process(extractedFile, part.File)
+		if err != nil {
+			return result, fmt.Errorf("letter %d (pages %s): %w", part.Part, pageRange, err)
+		}
+		partResult.InputFile = inputFile
+		part.Result = partResult
+
+		logrus.WithFields(logrus.Fields{
+			"part":   part.Part,
+			"pages":  pageRange,
+			"output": part.File,
+		}).Info("Created letter")
+
+		result.Parts = append(result.Parts, *part)
+	}
+
+	return result, nil
+}
+
+// findSplitParts returns the page ranges of the letters in ctx
+func (p *PDFProcessor) findSplitParts(ctx *model.Context, inputFile string, opts SplitOptions) ([]SplitPart, error) {
+	switch opts.Mode {
+	case SplitByPages:
+		if opts.Pages < 1 {
+			return nil, fmt.Errorf("page count per letter must be at least 1, got %d", opts.Pages)
+		}
+		var parts []SplitPart
+		for first := 1; first <= ctx.PageCount; first += opts.Pages {
+			parts = append(parts, SplitPart{FirstPage: first, LastPage: min(first+opts.Pages-1, ctx.PageCount)})
+		}
+		return parts, nil
+
+	case SplitByBookmarks:
+		return p.splitByBookmarks(ctx, inputFile)
+
+	case SplitByText:
+		if opts.Pattern == nil {
+			return nil, fmt.Errorf("split by text needs a pattern")
+		}
+		return p.splitByText(ctx, inputFile, opts.Pattern)
+
+	case SplitByBlank:
+		return p.splitByBlankPages(ctx)
+
+	default:
+		return nil, fmt.Errorf("unknown split mode %q", opts.Mode)
+	}
+}
+
+// splitByBookmarks starts a new letter at every top level bookmark
+func (p *PDFProcessor) splitByBookmarks(ctx *model.Context, inputFile string) ([]SplitPart, error) {
+	f, err := os.Open(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// api.Bookmarks changes the command of the configuration it is given
+	conf := *p.config
+	bookmarks, err := api.Bookmarks(f, &conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bookmarks: %w", err)
+	}
+	if len(bookmarks) == 0 {
+		return nil, fmt.Errorf("document has no bookmarks")
+	}
+
+	sort.SliceStable(bookmarks, func(i, j int) bool { return bookmarks[i].PageFrom < bookmarks[j].PageFrom })
+
+	var parts []SplitPart
+	for _, bm := range bookmarks {
+		if bm.PageFrom < 1 || bm.PageFrom > ctx.PageCount {
+			continue
+		}
+		if len(parts) > 0 && parts[len(parts)-1].FirstPage == bm.PageFrom {
+			continue
+		}
+		parts = append(parts, SplitPart{FirstPage: bm.PageFrom, Title: bm.Title})
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no bookmark points to a page")
+	}
+
+	// Pages before the first bookmark, e.g. a cover page, stay with the first letter
+	parts[0].FirstPage = 1
+	return closeSplitParts(parts, ctx.PageCount), nil
+}
+
+// splitByText starts a new letter at every page whose text matches pattern
+func (p *PDFProcessor) splitByText(ctx *model.Context, inputFile string, pattern *regexp.Regexp) ([]SplitPart, error) {
+	var parts []SplitPart
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		text, err := p.pageText(ctx, inputFile, pageNr)
+		if err != nil {
+			return nil, err
+		}
+
+		match := pattern.FindStringSubmatch(text)
+		if match == nil {
+			continue
+		}
+
+		part := SplitPart{FirstPage: pageNr, Match: strings.TrimSpace(match[0])}
+		if len(match) > 1 {
+			part.Match = strings.TrimSpace(match[1])
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("pattern %q matched no page", pattern.String())
+	}
+
+	if parts[0].FirstPage > 1 {
+		logrus.WithField("pages", parts[0].FirstPage-1).Warn("Pages before the first match are added to the first letter")
+		parts[0].FirstPage = 1
+	}
+	return closeSplitParts(parts, ctx.PageCount), nil
+}
+
+// splitByBlankPages uses blank pages as separators and drops them
+func (p *PDFProcessor) splitByBlankPages(ctx *model.Context) ([]SplitPart, error) {
+	var parts []SplitPart
+	var current *SplitPart
+
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		content, err := p.analyzePage(ctx, pageNr)
+		if err != nil {
+			return nil, err
+		}
+
+		if !content.Painted {
+			logrus.WithField("page", pageNr).Debug("Blank separator page")
+			current = nil
+			continue
+		}
+
+		if current == nil {
+			parts = append(parts, SplitPart{FirstPage: pageNr})
+			current = &parts[len(parts)-1]
+		}
+		current.LastPage = pageNr
+	}
+
+	return parts, nil
+}
+
+// closeSplitParts lets every letter end before the next one starts
+func closeSplitParts(parts []SplitPart, pageCount int) []SplitPart {
+	for i := range parts {
+		if i+1 < len(parts) {
+			parts[i].LastPage = parts[i+1].FirstPage - 1
+		} else {
+			parts[i].LastPage = pageCount
+		}
+	}
+	return parts
+}
+
+// pageText returns the text of a page. pdftotext is used when it is
+// installed since it understands all font encodings, otherwise the text is
+// taken from the content stream.
+func (p *PDFProcessor) pageText(ctx *model.Context, inputFile string, pageNr int) (string, error) {
+	if _, err := exec.LookPath("pdftotext"); err == nil {
+		page := strconv.Itoa(pageNr)
+		output, err := exec.Command("pdftotext", "-q", "-f", page, "-l", page, "-layout", inputFile, "-").Output()
+		if err == nil {
+			return string(output), nil
+		}
+		logrus.WithError(err).WithField("page", pageNr).Debug("pdftotext failed, using content stream text")
+	}
+
+	content, err := p.analyzePage(ctx, pageNr)
+	if err != nil {
+		return "", err
+	}
+	return content.Text, nil
+}
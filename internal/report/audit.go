@@ -0,0 +1,230 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/yourorg/pdf2letterexpress/internal/processor"
+)
+
+// AuditConversion is the event of an audit entry for a conversion
+const AuditConversion = "conversion"
+
+// auditHashField ends every line of an audit log. The hash covers the line
+// without it, including the hash of the previous entry.
+const auditHashField = `,"hash":"`
+
+// AuditEntry is a line of the append-only audit log
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Event is AuditConversion, or "submission" for letters sent to LetterXpress
+	Event   string            `json:"event"`
+	User    string            `json:"user"`
+	Host    string            `json:"host"`
+	Tool    Tool              `json:"tool"`
+	Inputs  []File            `json:"inputs,omitempty"`
+	Output  *File             `json:"output,omitempty"`
+	Pages   int               `json:"page_count"`
+	Engine  string            `json:"engine,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+	// Outcome is the error code, "ok" on success
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+	// JobID is the remote job ID of a submission
+	JobID string `json:"job_id,omitempty"`
+	// Prev is the hash of the previous entry, empty for the first one
+	Prev string `json:"prev"`
+}
+
+// NewAuditEntry returns the audit entry of a conversion report. Options are
+// the flags given on the command line.
+func NewAuditEntry(r *Report, options map[string]string) AuditEntry {
+	entry := AuditEntry{
+		Time:    time.Now().UTC(),
+		Event:   AuditConversion,
+		User:    currentUser(),
+		Tool:    r.Tool,
+		Output:  r.Output,
+		Pages:   r.PageCount,
+		Engine:  r.Engine,
+		Options: options,
+		Outcome: processor.CodeOK,
+	}
+	entry.Host, _ = os.Hostname()
+
+	if len(r.Sections) > 0 {
+		for _, section := range r.Sections {
+			entry.Inputs = append(entry.Inputs, section.File)
+		}
+	} else {
+		entry.Inputs = []File{r.Input}
+	}
+	if r.Error != nil {
+		entry.Outcome = r.Error.Code
+		entry.Error = r.Error.Message
+	}
+	return entry
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// AppendAudit appends an entry to the audit log at path, creating it if
+// necessary, and chains it to the last entry. The log is locked while the
+// entry is added, so that runs writing to it at the same time do not chain
+// to the same entry.
+func AppendAudit(path string, entry AuditEntry) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("cannot open audit log: %w", err)
+	}
+	defer f.Close()
+
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("cannot lock audit log: %w", err)
+	}
+
+	entry.Prev, err = lastAuditHash(f)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("cannot encode audit entry: %w", err)
+	}
+	line := fmt.Sprintf("%s%s%s\"}\n", body[:len(body)-1], auditHashField, auditHash(body))
+	if _, err := f.WriteString(line); err != nil {
+		return fmt.Errorf("cannot write audit log: %w", err)
+	}
+	return f.Close()
+}
+
+// lastAuditHash returns the hash of the last entry of an audit log, reading
+// it backwards so that long logs are not read completely
+func lastAuditHash(f *os.File) (string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("cannot read audit log: %w", err)
+	}
+
+	const blockSize = 4096
+	var tail []byte
+	for offset := info.Size(); offset > 0; {
+		n := min(blockSize, offset)
+		offset -= n
+		block := make([]byte, n)
+		if _, err := f.ReadAt(block, offset); err != nil && err != io.EOF {
+			return "", fmt.Errorf("cannot read audit log: %w", err)
+		}
+		tail = append(block, tail...)
+
+		trimmed := bytes.TrimRight(tail, "\r\n")
+		start := bytes.LastIndexByte(trimmed, '\n')
+		if start < 0 && offset > 0 {
+			continue
+		}
+		if len(trimmed) == 0 {
+			return "", nil
+		}
+		_, hash, ok := splitAuditLine(trimmed[start+1:])
+		if !ok {
+			return "", fmt.Errorf("%w: the last entry has no hash, check it with verify-audit", processor.ErrAuditDamaged)
+		}
+		return hash, nil
+	}
+	return "", nil
+}
+
+// splitAuditLine returns the hashed part of an audit log line and its hash
+func splitAuditLine(line []byte) ([]byte, string, bool) {
+	i := bytes.LastIndex(line, []byte(auditHashField))
+	if i < 0 || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return nil, "", false
+	}
+	hash := string(line[i+len(auditHashField) : len(line)-2])
+	body := append(bytes.Clone(line[:i]), '}')
+	return body, hash, true
+}
+
+func auditHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditVerification is the result of checking the hash chain of an audit log
+type AuditVerification struct {
+	File    string   `json:"file"`
+	Entries int      `json:"entries"`
+	Intact  bool     `json:"intact"`
+	Last    string   `json:"last_hash,omitempty"`
+	Errors  []string `json:"errors"`
+}
+
+func (v *AuditVerification) addError(format string, args ...interface{}) {
+	v.Errors = append(v.Errors, fmt.Sprintf(format, args...))
+	v.Intact = false
+}
+
+// VerifyAudit checks that every entry of an audit log matches its hash and
+// follows the entry before it. Entries removed from the end cannot be
+// detected, compare the last hash with a copy kept elsewhere for that.
+func VerifyAudit(path string) (*AuditVerification, error) {
+	v := &AuditVerification{File: path, Intact: true, Errors: []string{}}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return v, fmt.Errorf("cannot open audit log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	prev := ""
+	for lineNr := 1; scanner.Scan(); lineNr++ {
+		line := bytes.TrimRight(scanner.Bytes(), "\r")
+		if len(line) == 0 {
+			continue
+		}
+		v.Entries++
+
+		body, hash, ok := splitAuditLine(line)
+		if !ok {
+			v.addError("line %d has no hash", lineNr)
+			prev = ""
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(body, &entry); err != nil {
+			v.addError("line %d is not a valid entry: %v", lineNr, err)
+		}
+		if auditHash(body) != hash {
+			v.addError("line %d was changed, its hash does not match", lineNr)
+		}
+		if entry.Prev != prev {
+			if v.Entries == 1 {
+				v.addError("line %d does not start the chain, entries before it were removed", lineNr)
+			} else {
+				v.addError("line %d does not follow the entry before it, entries were removed, inserted or reordered", lineNr)
+			}
+		}
+		prev = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return v, fmt.Errorf("cannot read audit log: %w", err)
+	}
+
+	v.Last = prev
+	return v, nil
+}
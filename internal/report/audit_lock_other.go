@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package report
+
+import "os"
+
+// lockFile does nothing on platforms without file locks
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,528 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yourorg/pdf2letterexpress/internal/processor"
+	"github.com/yourorg/pdf2letterexpress/internal/utils"
+)
+
+// SchemaVersion is the version of the JSON report format. It is bumped
+// whenever a field is renamed or removed; new fields may be added at any time.
+const SchemaVersion = "1"
+
+// Supported report formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Report is the machine-readable result of converting a single file
+type Report struct {
+	SchemaVersion   string                     `json:"schema_version"`
+	Tool            Tool                       `json:"tool"`
+	Success         bool                       `json:"success"`
+	DryRun          bool                       `json:"dry_run"`
+	Input           File                       `json:"input"`
+	Encrypted       bool                       `json:"encrypted"`
+	Permissions     []string                   `json:"permissions,omitempty"`
+	Output          *File                      `json:"output,omitempty"`
+	Archive         *Archive                   `json:"archive,omitempty"`
+	DebugOverlay    *File                      `json:"debug_overlay,omitempty"`
+	Engine          string                     `json:"engine,omitempty"`
+	PageCount       int                        `json:"page_count"`
+	Pages           []Page                     `json:"pages"`
+	Sections        []Section                  `json:"sections,omitempty"`
+	Images          []processor.ImagePage      `json:"images,omitempty"`
+	Duplex          bool                       `json:"duplex"`
+	BlankPages      []int                      `json:"blank_pages,omitempty"`
+	RemovedPages    []int                      `json:"removed_pages,omitempty"`
+	InsertedPages   []int                      `json:"inserted_pages,omitempty"`
+	ColorPages      []int                      `json:"color_pages"`
+	Grayscale       bool                       `json:"grayscale"`
+	Postage         *processor.PostageEstimate `json:"postage,omitempty"`
+	Raster          *Raster                    `json:"raster,omitempty"`
+	MaxSize         int64                      `json:"max_size,omitempty"`
+	SizeAttempts    []SizeAttempt              `json:"size_attempts,omitempty"`
+	FontIssues      []processor.FontIssue      `json:"font_issues"`
+	EmbeddedFonts   []string                   `json:"embedded_fonts,omitempty"`
+	RasterizedPages []int                      `json:"rasterized_pages,omitempty"`
+	Annotations     *Annotations               `json:"annotations,omitempty"`
+	Sanitized       []string                   `json:"sanitized,omitempty"`
+	Invoice         *processor.Invoice         `json:"invoice,omitempty"`
+	Layers          []processor.Layer          `json:"layers,omitempty"`
+	Transparency    *Transparency              `json:"transparency,omitempty"`
+	Warnings        []string                   `json:"warnings"`
+	Error           *Error                     `json:"error,omitempty"`
+	Timing          Timing                     `json:"timing"`
+}
+
+// Tool identifies the program that produced the report
+type Tool struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// File describes an input or output file
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Annotations counts the annotations and form fields drawn into the page
+// content and those dropped because they do not print
+type Annotations struct {
+	Flattened int `json:"flattened"`
+	Dropped   int `json:"dropped"`
+}
+
+// Transparency lists the pages of the vector engine's output that use
+// transparency and whether they were rasterized
+type Transparency struct {
+	Pages     []int `json:"pages"`
+	Flattened bool  `json:"flattened"`
+}
+
+// Archive describes the PDF/A archival copy of the output
+type Archive struct {
+	File
+	Conformance string `json:"conformance"`
+}
+
+// Page describes the original size of a page and how it was placed on the output page
+type Page struct {
+	Number         int     `json:"number"`
+	WidthMM        float64 `json:"width_mm"`
+	HeightMM       float64 `json:"height_mm"`
+	Rotation       int     `json:"rotation"`
+	ScaleX         float64 `json:"scale_x"`
+	ScaleY         float64 `json:"scale_y"`
+	OffsetXMM      float64 `json:"offset_x_mm"`
+	OffsetYMM      float64 `json:"offset_y_mm"`
+	OutputWidthMM  float64 `json:"output_width_mm"`
+	OutputHeightMM float64 `json:"output_height_mm"`
+	ColorMode      string  `json:"color_mode,omitempty"`
+}
+
+// Raster describes the settings of the raster engine
+type Raster struct {
+	ColorMode   string `json:"color_mode,omitempty"`
+	DPI         int    `json:"dpi,omitempty"`
+	JPEGQuality int    `json:"jpeg_quality,omitempty"`
+	NoColor     bool   `json:"no_color,omitempty"`
+}
+
+// SizeAttempt describes one pass of fitting the output into the size limit
+type SizeAttempt struct {
+	Raster
+	Size int64 `json:"size"`
+}
+
+// Section describes one of the documents of a combined letter
+type Section struct {
+	File
+	FirstPage       int `json:"first_page"`
+	PageCount       int `json:"page_count"`
+	BlankPagesAdded int `json:"blank_pages_added,omitempty"`
+}
+
+// Error describes why a conversion failed
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Timing records when the conversion ran and how long it took
+type Timing struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DurationMS int64     `json:"duration_ms"`
+}
+
+// New builds a report from a processing result and the error it returned, if any
+func New(toolName, toolVersion string, result *processor.Result, procErr error) *Report {
+	r := &Report{
+		SchemaVersion: SchemaVersion,
+		Tool:          Tool{Name: toolName, Version: toolVersion},
+		Success:       procErr == nil,
+		DryRun:        result.DryRun,
+		Input:         describeFile(result.InputFile),
+		Encrypted:     result.Encrypted,
+		Permissions:   result.Permissions,
+		Engine:        result.Engine,
+		PageCount:     result.PageCount,
+		Pages:         []Page{},
+		Duplex:        result.Duplex,
+		BlankPages:    result.BlankPages,
+		RemovedPages:  result.RemovedPages,
+		InsertedPages: result.InsertedPages,
+		ColorPages:    result.ColorPages,
+		Grayscale:     result.Grayscale,
+		Postage:       result.Postage,
+		FontIssues:    []processor.FontIssue{},
+		EmbeddedFonts: result.EmbeddedFonts,
+		Warnings:      []string{},
+		Timing: Timing{
+			StartedAt:  result.StartedAt,
+			FinishedAt: result.FinishedAt,
+			DurationMS: result.Duration().Milliseconds(),
+		},
+	}
+
+	r.Warnings = append(r.Warnings, result.Warnings...)
+
+	if result.Raster != (processor.RasterOptions{}) {
+		raster := newRaster(result.Raster)
+		r.Raster = &raster
+	}
+	r.MaxSize = result.MaxSize
+	r.FontIssues = append(r.FontIssues, result.FontIssues...)
+	r.RasterizedPages = result.RasterizedPages
+	if result.FlattenedAnnotations+result.DroppedAnnotations > 0 {
+		r.Annotations = &Annotations{Flattened: result.FlattenedAnnotations, Dropped: result.DroppedAnnotations}
+	}
+	r.Images = result.Images
+	r.Layers = result.Layers
+	r.Sanitized = result.Sanitized
+	r.Invoice = result.Invoice
+	if len(result.TransparentPages) > 0 {
+		r.Transparency = &Transparency{Pages: result.TransparentPages, Flattened: result.TransparencyFlattened}
+	}
+	for _, attempt := range result.SizeAttempts {
+		r.SizeAttempts = append(r.SizeAttempts, SizeAttempt{Raster: newRaster(attempt.Raster), Size: attempt.Size})
+	}
+
+	for _, plan := range result.Pages {
+		r.Pages = append(r.Pages, Page{
+			Number:         plan.Page,
+			WidthMM:        toMM(plan.Width),
+			HeightMM:       toMM(plan.Height),
+			Rotation:       plan.Rotation,
+			ScaleX:         round(plan.ScaleX, 4),
+			ScaleY:         round(plan.ScaleY, 4),
+			OffsetXMM:      toMM(plan.OffsetX),
+			OffsetYMM:      toMM(plan.OffsetY),
+			OutputWidthMM:  toMM(plan.OutputWidth),
+			OutputHeightMM: toMM(plan.OutputHeight),
+			ColorMode:      plan.ColorMode,
+		})
+	}
+
+	for _, section := range result.Sections {
+		r.Sections = append(r.Sections, Section{
+			File:            describeFile(section.File),
+			FirstPage:       section.FirstPage,
+			PageCount:       section.PageCount,
+			BlankPagesAdded: section.BlankPagesAdded,
+		})
+	}
+
+	if procErr != nil {
+		r.Error = &Error{Code: processor.ErrorCode(procErr), Message: procErr.Error()}
+	} else if result.DryRun {
+		// Nothing was written, only report where the output would go
+		r.Output = &File{Path: result.OutputFile}
+	} else if result.OutputFile != "" {
+		output := describeFile(result.OutputFile)
+		r.Output = &output
+	}
+
+	if result.ArchiveFile != "" {
+		r.Archive = &Archive{File: describeFile(result.ArchiveFile), Conformance: result.ArchiveLevel}
+	}
+
+	if result.DebugOverlayFile != "" {
+		overlay := describeFile(result.DebugOverlayFile)
+		r.DebugOverlay = &overlay
+	}
+
+	return r
+}
+
+func newRaster(o processor.RasterOptions) Raster {
+	return Raster{ColorMode: o.ColorMode, DPI: o.DPI, JPEGQuality: o.JPEGQuality, NoColor: o.NoColor}
+}
+
+// describeFile collects size and hash of a file, leaving them empty if it cannot be read
+func describeFile(path string) File {
+	f := File{Path: path}
+	if size, err := utils.GetFileSize(path); err == nil {
+		f.Size = size
+	}
+	if sum, err := utils.FileSHA256(path); err == nil {
+		f.SHA256 = sum
+	}
+	return f
+}
+
+func toMM(points float64) float64 {
+	return round(points/processor.PointsPerMM, 2)
+}
+
+func round(v float64, digits int) float64 {
+	pow := math.Pow10(digits)
+	return math.Round(v*pow) / pow
+}
+
+// Writer writes reports in one of the supported formats. JSON reports are
+// written as one object per line so batch runs produce JSONL.
+type Writer struct {
+	w      io.Writer
+	format string
+}
+
+// NewWriter creates a report writer for the given format
+func NewWriter(w io.Writer, format string) (*Writer, error) {
+	if format != FormatText && format != FormatJSON {
+		return nil, fmt.Errorf("unsupported report format: %s", format)
+	}
+	return &Writer{w: w, format: format}, nil
+}
+
+// Write writes a single report
+func (w *Writer) Write(r *Report) error {
+	if w.format == FormatJSON {
+		return json.NewEncoder(w.w).Encode(r)
+	}
+
+	// Failures are reported through the returned error in text mode
+	if !r.Success {
+		return nil
+	}
+
+	if r.DryRun {
+		return w.writePlan(r)
+	}
+
+	_, err := fmt.Fprintf(w.w, "✅ Successfully converted PDF\n📁 Input:  %s\n📁 Output: %s\n", r.Input.Path, r.Output.Path)
+	if err == nil {
+		err = w.writeSections(r)
+	}
+	if err == nil {
+		err = w.writeImages(r)
+	}
+	if err == nil {
+		err = w.writePageChanges(r)
+	}
+	if err == nil {
+		_, err = fmt.Fprintf(w.w, "🎨 %s\n", FormatColorPages(r.ColorPages))
+	}
+	if err == nil && r.Postage != nil {
+		_, err = fmt.Fprintf(w.w, "✉️  Postage: %s\n", FormatPostage(r.Postage))
+	}
+	if err == nil {
+		err = w.writeFonts(r)
+	}
+	if err == nil {
+		err = w.writePrintPreparation(r)
+	}
+	for _, removed := range r.Sanitized {
+		if err == nil {
+			_, err = fmt.Fprintf(w.w, "🧹 Removed %s\n", removed)
+		}
+	}
+	if err == nil && r.Invoice != nil {
+		_, err = fmt.Fprintf(w.w, "🧾 E-invoice: %s\n", r.Invoice)
+	}
+	if err == nil && r.MaxSize > 0 {
+		_, err = fmt.Fprintf(w.w, "📦 Size: %s\n", formatSize(r))
+	}
+	if err == nil && r.Archive != nil {
+		_, err = fmt.Fprintf(w.w, "🗄️  Archive: %s (%s)\n", r.Archive.Path, r.Archive.Conformance)
+	}
+	if err == nil && r.DebugOverlay != nil {
+		_, err = fmt.Fprintf(w.w, "🔍 Debug:  %s (do not send)\n", r.DebugOverlay.Path)
+	}
+	return err
+}
+
+// writeSections lists the documents of a combined letter with their pages
+func (w *Writer) writeSections(r *Report) error {
+	for _, section := range r.Sections {
+		line := fmt.Sprintf("📎 Pages %d-%d: %s", section.FirstPage, section.FirstPage+section.PageCount-1, section.Path)
+		if section.BlankPagesAdded > 0 {
+			line += fmt.Sprintf(" (+%d blank)", section.BlankPagesAdded)
+		}
+		if _, err := fmt.Fprintln(w.w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeImages lists the pages made from an image input
+func (w *Writer) writeImages(r *Report) error {
+	for _, page := range r.Images {
+		if _, err := fmt.Fprintf(w.w, "🖼️  %s\n", page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePageChanges lists removed blank pages and inserted back sides
+func (w *Writer) writePageChanges(r *Report) error {
+	if len(r.RemovedPages) > 0 {
+		if _, err := fmt.Fprintf(w.w, "🗑️  Removed blank pages: %s\n", joinInts(r.RemovedPages)); err != nil {
+			return err
+		}
+	}
+	if len(r.InsertedPages) > 0 {
+		if _, err := fmt.Fprintf(w.w, "📄 Inserted blank pages: %s\n", joinInts(r.InsertedPages)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFonts lists font problems and how they were fixed
+func (w *Writer) writeFonts(r *Report) error {
+	for _, issue := range r.FontIssues {
+		if _, err := fmt.Fprintf(w.w, "🔤 %s\n", issue); err != nil {
+			return err
+		}
+	}
+	if len(r.EmbeddedFonts) > 0 {
+		if _, err := fmt.Fprintf(w.w, "🔤 Embedded fonts: %s\n", strings.Join(r.EmbeddedFonts, ", ")); err != nil {
+			return err
+		}
+	}
+	if len(r.RasterizedPages) > 0 {
+		if _, err := fmt.Fprintf(w.w, "🔤 Rasterized pages for fonts: %s\n", joinInts(r.RasterizedPages)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePrintPreparation lists the flattened annotations and layers and the
+// pages with transparency
+func (w *Writer) writePrintPreparation(r *Report) error {
+	if a := r.Annotations; a != nil {
+		if _, err := fmt.Fprintf(w.w, "📝 Annotations: %d flattened, %d dropped as not printing\n", a.Flattened, a.Dropped); err != nil {
+			return err
+		}
+	}
+	for _, layer := range r.Layers {
+		if _, err := fmt.Fprintf(w.w, "🗂️  %s\n", layer); err != nil {
+			return err
+		}
+	}
+	if t := r.Transparency; t != nil {
+		state := "may print differently"
+		if t.Flattened {
+			state = "rasterized"
+		}
+		if _, err := fmt.Fprintf(w.w, "🪟 Transparency on pages %s (%s)\n", joinInts(t.Pages), state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FormatColorPages describes which pages print in color
+func FormatColorPages(pages []int) string {
+	if len(pages) == 0 {
+		return "Black-and-white"
+	}
+	return fmt.Sprintf("Color pages: %s", joinInts(pages))
+}
+
+// FormatPostage returns a one line summary of a postage estimate
+func FormatPostage(p *processor.PostageEstimate) string {
+	printing := "simplex"
+	if p.Duplex {
+		printing = "duplex"
+	}
+
+	class := p.Class
+	if class == "" {
+		class = "too heavy"
+	}
+	return fmt.Sprintf("%s, %d sheets %s, %.1f g in %s, %.2f €", class, p.Sheets, printing, p.WeightG, p.Envelope, p.PriceEUR)
+}
+
+// formatSize describes the output size against the size limit and the
+// raster settings it took to get there
+func formatSize(r *Report) string {
+	var size int64
+	if r.Output != nil {
+		size = r.Output.Size
+	}
+	line := fmt.Sprintf("%s of %s", utils.FormatSize(size), utils.FormatSize(r.MaxSize))
+	if r.Raster != nil {
+		line += fmt.Sprintf(", %d dpi, JPEG quality %d", r.Raster.DPI, r.Raster.JPEGQuality)
+		if r.Raster.NoColor {
+			line += ", grayscale"
+		}
+	}
+	if len(r.SizeAttempts) > 1 {
+		line += fmt.Sprintf(" after %d attempts", len(r.SizeAttempts))
+	}
+	return line
+}
+
+func joinInts(values []int) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ", ")
+}
+
+// writePlan writes a dry-run report as a table
+func (w *Writer) writePlan(r *Report) error {
+	_, err := fmt.Fprintf(w.w, "📝 Dry run, nothing written\n📁 Input:  %s\n📁 Output: %s\n⚙️  Engine: %s\n", r.Input.Path, r.Output.Path, r.Engine)
+	for _, warning := range r.Warnings {
+		if err == nil {
+			_, err = fmt.Fprintf(w.w, "⚠️  %s\n", warning)
+		}
+	}
+	// Inputs that are only known after converting them have no pages planned
+	if err != nil || len(r.Pages) == 0 {
+		return err
+	}
+	err = w.writeSections(r)
+	if err == nil {
+		err = w.writeImages(r)
+	}
+	if err == nil {
+		err = w.writePageChanges(r)
+	}
+	if err == nil {
+		_, err = fmt.Fprintf(w.w, "🎨 %s\n", FormatColorPages(r.ColorPages))
+	}
+	if err == nil && r.Postage != nil {
+		_, err = fmt.Fprintf(w.w, "✉️  Postage: %s\n", FormatPostage(r.Postage))
+	}
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w.w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, err = fmt.Fprintln(tw, "Page\tSize (mm)\tRotation\tScale X\tScale Y\tOffset X (mm)\tOffset Y (mm)\tOutput (mm)\tMode\t")
+	for _, page := range r.Pages {
+		mode := page.ColorMode
+		if mode == "" {
+			mode = "vector"
+		}
+		if err == nil {
+			_, err = fmt.Fprintf(tw, "%d\t%.1f × %.1f\t%d°\t%.4f\t%.4f\t%.2f\t%.2f\t%.1f × %.1f\t%s\t\n",
+				page.Number, page.WidthMM, page.HeightMM, page.Rotation,
+				page.ScaleX, page.ScaleY, page.OffsetXMM, page.OffsetYMM,
+				page.OutputWidthMM, page.OutputHeightMM, mode)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tw.Flush()
+}
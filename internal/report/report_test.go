@@ -0,0 +1,275 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourorg/pdf2letterexpress/internal/processor"
+)
+
+func TestNew(t *testing.T) {
+	tempDir := t.TempDir()
+	inputFile := filepath.Join(tempDir, "input.pdf")
+	outputFile := filepath.Join(tempDir, "output.pdf")
+	archiveFile := filepath.Join(tempDir, "output - archive.pdf")
+	os.WriteFile(inputFile, []byte("%PDF-1.4 input"), 0644)
+	os.WriteFile(outputFile, []byte("%PDF-1.4 output"), 0644)
+	os.WriteFile(archiveFile, []byte("%PDF-1.7 archive"), 0644)
+
+	started := time.Now()
+	result := &processor.Result{
+		InputFile:  inputFile,
+		OutputFile: outputFile,
+		Engine:     processor.EnginePDFCPU,
+		PageCount:  1,
+		Pages: []processor.PagePlan{{
+			Page:         1,
+			Width:        processor.A4WidthPoints,
+			Height:       processor.A4HeightPoints,
+			ScaleX:       0.95,
+			ScaleY:       0.95,
+			OffsetX:      processor.MarginPoints,
+			OffsetY:      processor.MarginPoints,
+			OutputWidth:  processor.A4WidthPoints,
+			OutputHeight: processor.A4HeightPoints,
+		}},
+		Warnings:             []string{"engine imagemagick-a4 failed"},
+		ArchiveFile:          archiveFile,
+		ArchiveLevel:         processor.ArchivePDFA2B,
+		FlattenedAnnotations: 2,
+		DroppedAnnotations:   1,
+		Sanitized:            []string{"open action"},
+		Invoice:              &processor.Invoice{Name: "factur-x.xml", ConformanceLevel: "EN 16931"},
+		Images:               []processor.ImagePage{{Page: 1, Width: 2480, Height: 3508, DPI: 300, Cropped: true}},
+		StartedAt:            started,
+		FinishedAt:           started.Add(1500 * time.Millisecond),
+	}
+
+	r := New("TestApp", "1.0.0", result, nil)
+
+	if r.SchemaVersion != SchemaVersion {
+		t.Errorf("SchemaVersion = %s, want %s", r.SchemaVersion, SchemaVersion)
+	}
+	if !r.Success || r.Error != nil {
+		t.Errorf("expected successful report, got error %v", r.Error)
+	}
+	if r.Output == nil || r.Output.Size != 15 || len(r.Output.SHA256) != 64 {
+		t.Errorf("unexpected output description: %+v", r.Output)
+	}
+	if r.Archive == nil || r.Archive.Conformance != processor.ArchivePDFA2B || len(r.Archive.SHA256) != 64 || r.Archive.SHA256 == r.Output.SHA256 {
+		t.Errorf("unexpected archive description: %+v", r.Archive)
+	}
+	if r.Annotations == nil || r.Annotations.Flattened != 2 || r.Annotations.Dropped != 1 {
+		t.Errorf("unexpected annotations: %+v", r.Annotations)
+	}
+	if r.Invoice == nil || r.Invoice.Name != "factur-x.xml" {
+		t.Errorf("unexpected invoice: %+v", r.Invoice)
+	}
+	if len(r.Images) != 1 || r.Images[0].String() != "Page 1: 2480 × 3508 px image at 300 dpi, scanner border removed" {
+		t.Errorf("unexpected images: %+v", r.Images)
+	}
+	if len(r.Sanitized) != 1 {
+		t.Errorf("unexpected sanitized content: %v", r.Sanitized)
+	}
+	if r.Timing.DurationMS != 1500 {
+		t.Errorf("DurationMS = %d, want 1500", r.Timing.DurationMS)
+	}
+	if len(r.Pages) != 1 || r.Pages[0].WidthMM != 210 || r.Pages[0].OffsetXMM != 5 {
+		t.Errorf("unexpected pages: %+v", r.Pages)
+	}
+	if len(r.Warnings) != 1 {
+		t.Errorf("expected 1 warning, got %d", len(r.Warnings))
+	}
+}
+
+func TestNew_Failure(t *testing.T) {
+	result := &processor.Result{InputFile: "missing.pdf", OutputFile: "missing - converted.pdf"}
+
+	r := New("TestApp", "1.0.0", result, errors.New("boom"))
+
+	if r.Success {
+		t.Error("expected failed report")
+	}
+	if r.Output != nil {
+		t.Error("failed report should not describe an output file")
+	}
+	if r.Error == nil || r.Error.Message != "boom" {
+		t.Errorf("unexpected error: %+v", r.Error)
+	}
+}
+
+func TestWriter_JSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatJSON)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	result := &processor.Result{InputFile: "a.pdf"}
+	for i := 0; i < 2; i++ {
+		if err := w.Write(New("TestApp", "1.0.0", result, errors.New("boom"))); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	for _, line := range lines {
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("line is not valid JSON: %v", err)
+		}
+		if decoded["schema_version"] != SchemaVersion {
+			t.Errorf("schema_version = %v, want %s", decoded["schema_version"], SchemaVersion)
+		}
+	}
+}
+
+// failingWriter fails the write with the given number, counted from 1
+type failingWriter struct{ writes, fail int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes == w.fail {
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
+
+func TestWriter_PlanWriteError(t *testing.T) {
+	r := &Report{
+		Success:  true,
+		DryRun:   true,
+		Input:    File{Path: "a.pdf"},
+		Output:   &File{Path: "a - converted.pdf"},
+		Engine:   processor.EnginePDFCPU,
+		Warnings: []string{"page 1 is US Letter"},
+		Pages:    []Page{{Number: 1, WidthMM: 215.9, HeightMM: 279.4}},
+	}
+
+	counter := &failingWriter{}
+	w, err := NewWriter(counter, FormatText)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	if err := w.Write(r); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Every part of the plan reports a failed write
+	for fail := 1; fail <= counter.writes; fail++ {
+		w, _ := NewWriter(&failingWriter{fail: fail}, FormatText)
+		if err := w.Write(r); err == nil {
+			t.Errorf("Write returned no error when write %d of %d failed", fail, counter.writes)
+		}
+	}
+}
+
+func TestNewWriter_UnsupportedFormat(t *testing.T) {
+	if _, err := NewWriter(&bytes.Buffer{}, "xml"); err == nil {
+		t.Fatal("expected error for unsupported format")
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	auditLog := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	result := &processor.Result{InputFile: "letter.pdf", Engine: processor.EnginePDFCPU, PageCount: 2}
+	for i := 0; i < 3; i++ {
+		r := New("TestTool", "1.0.0", result, nil)
+		if err := AppendAudit(auditLog, NewAuditEntry(r, map[string]string{"duplex": "true"})); err != nil {
+			t.Fatalf("AppendAudit failed: %v", err)
+		}
+	}
+	failed := New("TestTool", "1.0.0", result, processor.ErrInvalidPDF)
+	if err := AppendAudit(auditLog, NewAuditEntry(failed, nil)); err != nil {
+		t.Fatalf("AppendAudit failed: %v", err)
+	}
+
+	v, err := VerifyAudit(auditLog)
+	if err != nil {
+		t.Fatalf("VerifyAudit failed: %v", err)
+	}
+	if !v.Intact || v.Entries != 4 || len(v.Last) != 64 {
+		t.Errorf("unexpected verification %+v", v)
+	}
+
+	data, _ := os.ReadFile(auditLog)
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+	var entry AuditEntry
+	if err := json.Unmarshal([]byte(lines[3]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Outcome != processor.CodeInvalidPDF || entry.Pages != 2 || entry.Inputs[0].Path != "letter.pdf" || entry.Prev == "" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	tests := []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{"changed", []string{lines[0], strings.Replace(lines[1], `"page_count":2`, `"page_count":3`, 1), lines[2]}, "line 2 was changed"},
+		{"removed", []string{lines[0], lines[2], lines[3]}, "line 2 does not follow"},
+		{"reordered", []string{lines[0], lines[2], lines[1]}, "line 2 does not follow"},
+		{"truncated at the start", lines[1:], "line 1 does not start the chain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.WriteFile(auditLog, []byte(strings.Join(tt.lines, "")), 0640)
+			v, err := VerifyAudit(auditLog)
+			if err != nil {
+				t.Fatalf("VerifyAudit failed: %v", err)
+			}
+			if v.Intact || len(v.Errors) == 0 || !strings.HasPrefix(v.Errors[0], tt.want) {
+				t.Errorf("errors = %q, want %q", v.Errors, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendAudit_WaitsForLock(t *testing.T) {
+	auditLog := filepath.Join(t.TempDir(), "audit.jsonl")
+	r := New("TestTool", "1.0.0", &processor.Result{InputFile: "letter.pdf"}, nil)
+	if err := AppendAudit(auditLog, NewAuditEntry(r, nil)); err != nil {
+		t.Fatalf("AppendAudit failed: %v", err)
+	}
+
+	// Another run holds the lock
+	f, err := os.OpenFile(auditLog, os.O_RDWR|os.O_APPEND, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lockFile(f); err != nil {
+		t.Fatalf("lockFile failed: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- AppendAudit(auditLog, NewAuditEntry(r, nil)) }()
+	select {
+	case err := <-done:
+		f.Close()
+		t.Fatalf("AppendAudit returned %v while the log was locked", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	f.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("AppendAudit failed: %v", err)
+	}
+	v, err := VerifyAudit(auditLog)
+	if err != nil {
+		t.Fatalf("VerifyAudit failed: %v", err)
+	}
+	if !v.Intact || v.Entries != 2 {
+		t.Errorf("unexpected verification %+v", v)
+	}
+}
@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -16,6 +19,34 @@ func GenerateOutputFilename(inputFile string) string {
 	return filepath.Join(dir, outputName)
 }
 
+// GenerateDebugOverlayFilename returns the name of the annotated debug copy
+// for a converted file, e.g. "letter - converted - debug.pdf"
+func GenerateDebugOverlayFilename(outputFile string) string {
+	ext := filepath.Ext(outputFile)
+	return strings.TrimSuffix(outputFile, ext) + " - debug" + ext
+}
+
+// GenerateArchiveFilename returns the name of the PDF/A archival copy of a
+// converted file, e.g. "letter - converted - archive.pdf"
+func GenerateArchiveFilename(outputFile string) string {
+	ext := filepath.Ext(outputFile)
+	return strings.TrimSuffix(outputFile, ext) + " - archive" + ext
+}
+
+// GenerateSanitizedFilename returns the name of the sanitized copy of a
+// file, e.g. "letter - sanitized.pdf"
+func GenerateSanitizedFilename(inputFile string) string {
+	ext := filepath.Ext(inputFile)
+	return strings.TrimSuffix(inputFile, ext) + " - sanitized.pdf"
+}
+
+// GenerateComposedFilename returns the name of the letter composed from a
+// Markdown or text file, e.g. "letter.pdf"
+func GenerateComposedFilename(bodyFile string) string {
+	ext := filepath.Ext(bodyFile)
+	return strings.TrimSuffix(bodyFile, ext) + ".pdf"
+}
+
 func FileExists(filename string) bool {
 	_, err := os.Stat(filename)
 	return !os.IsNotExist(err)
@@ -31,4 +62,19 @@ func GetFileSize(filename string) (int64, error) {
 
 func EnsureDirectoryExists(dir string) error {
 	return os.MkdirAll(dir, 0755)
-}
\ No newline at end of file
+}
+
+// FileSHA256 returns the hex encoded SHA-256 hash of a file
+func FileSHA256(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
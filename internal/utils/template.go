@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// ExpandPlaceholders replaces {{name}} placeholders with the matching value
+// from fields. Unknown placeholders are replaced with an empty string.
+func ExpandPlaceholders(tmpl string, fields map[string]string) string {
+	return placeholderPattern.ReplaceAllStringFunc(tmpl, func(m string) string {
+		name := placeholderPattern.FindStringSubmatch(m)[1]
+		return fields[name]
+	})
+}
+
+// SanitizeFilename replaces characters that are not allowed in file names
+// on common platforms
+func SanitizeFilename(name string) string {
+	replacer := strings.NewReplacer(
+		"/", "_", "\\", "_", ":", "_", "*", "_", "?", "_",
+		"\"", "_", "<", "_", ">", "_", "|", "_",
+	)
+	return strings.TrimSpace(replacer.Replace(name))
+}
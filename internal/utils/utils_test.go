@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -27,11 +28,11 @@ func TestValidateInputFile(t *testing.T) {
 		},
 		{
 			name:     "non-PDF file",
-			filename: "test.txt",
+			filename: "test.csv",
 			wantErr:  true,
 			setup: func() (string, func()) {
 				tempDir := os.TempDir()
-				filename := filepath.Join(tempDir, "test.txt")
+				filename := filepath.Join(tempDir, "test.csv")
 				os.WriteFile(filename, []byte("test"), 0644)
 				return filename, func() { os.Remove(filename) }
 			},
@@ -48,6 +49,46 @@ func TestValidateInputFile(t *testing.T) {
 				return filename, func() { os.Remove(filename) }
 			},
 		},
+		{
+			name:     "valid TIFF image",
+			filename: "scan.tif",
+			wantErr:  false,
+			setup: func() (string, func()) {
+				filename := filepath.Join(os.TempDir(), "scan.tif")
+				os.WriteFile(filename, []byte("II*\x00\x08\x00\x00\x00"), 0644)
+				return filename, func() { os.Remove(filename) }
+			},
+		},
+		{
+			name:     "Word document that is not a ZIP file",
+			filename: "letter.docx",
+			wantErr:  true,
+			setup: func() (string, func()) {
+				filename := filepath.Join(os.TempDir(), "letter.docx")
+				os.WriteFile(filename, []byte("{\\rtf1 Hallo}"), 0644)
+				return filename, func() { os.Remove(filename) }
+			},
+		},
+		{
+			name:     "plain text document",
+			filename: "letter.txt",
+			wantErr:  false,
+			setup: func() (string, func()) {
+				filename := filepath.Join(os.TempDir(), "letter.txt")
+				os.WriteFile(filename, []byte("Sehr geehrte Damen und Herren"), 0644)
+				return filename, func() { os.Remove(filename) }
+			},
+		},
+		{
+			name:     "image with wrong header",
+			filename: "scan.png",
+			wantErr:  true,
+			setup: func() (string, func()) {
+				filename := filepath.Join(os.TempDir(), "scan.png")
+				os.WriteFile(filename, []byte("%PDF-1.4\ntest content"), 0644)
+				return filename, func() { os.Remove(filename) }
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -67,6 +108,41 @@ func TestValidateInputFile(t *testing.T) {
 	}
 }
 
+func TestValidateInputFile_WrongHeader(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "scan.png")
+	if err := os.WriteFile(filename, []byte("%PDF-1.4\ntest content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// An image that is not what its extension says is not an invalid PDF
+	err := ValidateInputFile(filename)
+	if !errors.Is(err, ErrUnreadableInput) || errors.Is(err, ErrInvalidPDF) {
+		t.Errorf("ValidateInputFile() error = %v, want ErrUnreadableInput", err)
+	}
+}
+
+func TestDetectInputExtension(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{"%PDF-1.7\n", ".pdf"},
+		{"\xff\xd8\xff\xe0", ".jpg"},
+		{"\x89PNG\r\n\x1a\n", ".png"},
+		{"MM\x00*", ".tif"},
+		{"{\\rtf1", ".rtf"},
+		{"PK\x03\x04\x14\x00\x00\x00\x00\x00mimetypeapplication/vnd.oasis.opendocument.text", ".odt"},
+		{"PK\x03\x04\x14\x00\x06\x00[Content_Types].xml", ".docx"},
+		{"", ".pdf"},
+	}
+
+	for _, tt := range tests {
+		if got := DetectInputExtension([]byte(tt.data)); got != tt.expected {
+			t.Errorf("DetectInputExtension(%q) = %v, want %v", tt.data, got, tt.expected)
+		}
+	}
+}
+
 func TestGenerateOutputFilename(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -98,4 +174,118 @@ func TestGenerateOutputFilename(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestFileSHA256(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "hash.txt")
+	os.WriteFile(filename, []byte("abc"), 0644)
+
+	sum, err := FileSHA256(filename)
+	if err != nil {
+		t.Fatalf("FileSHA256() error = %v", err)
+	}
+
+	expected := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if sum != expected {
+		t.Errorf("FileSHA256() = %v, want %v", sum, expected)
+	}
+}
+
+func TestGenerateDebugOverlayFilename(t *testing.T) {
+	result := GenerateDebugOverlayFilename(filepath.Join("/path/to", "letter - converted.pdf"))
+	expected := filepath.Join("/path/to", "letter - converted - debug.pdf")
+
+	if result != expected {
+		t.Errorf("GenerateDebugOverlayFilename() = %v, want %v", result, expected)
+	}
+}
+
+func TestGenerateArchiveFilename(t *testing.T) {
+	result := GenerateArchiveFilename(filepath.Join("/path/to", "letter - converted.pdf"))
+	expected := filepath.Join("/path/to", "letter - converted - archive.pdf")
+
+	if result != expected {
+		t.Errorf("GenerateArchiveFilename() = %v, want %v", result, expected)
+	}
+}
+
+func TestGenerateSanitizedFilename(t *testing.T) {
+	result := GenerateSanitizedFilename(filepath.Join("/path/to", "draft.pdf"))
+	expected := filepath.Join("/path/to", "draft - sanitized.pdf")
+
+	if result != expected {
+		t.Errorf("GenerateSanitizedFilename() = %v, want %v", result, expected)
+	}
+}
+
+func TestGenerateComposedFilename(t *testing.T) {
+	result := GenerateComposedFilename(filepath.Join("/path/to", "reminder.md"))
+	expected := filepath.Join("/path/to", "reminder.pdf")
+
+	if result != expected {
+		t.Errorf("GenerateComposedFilename() = %v, want %v", result, expected)
+	}
+}
+
+func TestExpandPlaceholders(t *testing.T) {
+	fields := map[string]string{"name": "Müller", "row": "007"}
+
+	tests := []struct {
+		tmpl     string
+		expected string
+	}{
+		{"{{name}} - {{row}}", "Müller - 007"},
+		{"{{ name }}", "Müller"},
+		{"letter {{missing}}", "letter "},
+		{"no placeholders", "no placeholders"},
+	}
+
+	for _, tt := range tests {
+		if got := ExpandPlaceholders(tt.tmpl, fields); got != tt.expected {
+			t.Errorf("ExpandPlaceholders(%q) = %q, want %q", tt.tmpl, got, tt.expected)
+		}
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	if got := SanitizeFilename(" a/b:c "); got != "a_b_c" {
+		t.Errorf("SanitizeFilename() = %q, want %q", got, "a_b_c")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"10MB", 10000000, false},
+		{"10 mb", 10000000, false},
+		{"2.5MiB", 2621440, false},
+		{"500KB", 500000, false},
+		{"1234", 1234, false},
+		{"1G", 1000000000, false},
+		{"ten MB", 0, true},
+		{"-1MB", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSize(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	if got := FormatSize(9800000); got != "9.8 MB" {
+		t.Errorf("FormatSize() = %q, want %q", got, "9.8 MB")
+	}
+	if got := FormatSize(512); got != "512 B" {
+		t.Errorf("FormatSize() = %q, want %q", got, "512 B")
+	}
+}
@@ -1,23 +1,46 @@
 package utils
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
+var (
+	// ErrInputNotFound is returned when the input file does not exist
+	ErrInputNotFound = errors.New("input file not found")
+	// ErrInvalidPDF is returned when the input is not a readable PDF
+	ErrInvalidPDF = errors.New("invalid PDF")
+	// ErrUnreadableInput is returned when an image or office input cannot be read
+	ErrUnreadableInput = errors.New("unreadable input")
+	// ErrOutputNotWritable is returned when the output location cannot be written
+	ErrOutputNotWritable = errors.New("output not writable")
+)
+
+// ValidateInputFile checks that filename exists and is a PDF, a JPEG, PNG
+// or TIFF image or an office document
 func ValidateInputFile(filename string) error {
-	if filename == "" {
-		return fmt.Errorf("filename cannot be empty")
+	if !IsImageFile(filename) && !IsOfficeFile(filename) {
+		return ValidatePDFFile(filename)
 	}
+	if err := validateExists(filename); err != nil {
+		return err
+	}
+	return validateHeader(filename)
+}
 
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		return fmt.Errorf("file does not exist: %s", filename)
+// ValidatePDFFile checks that filename exists and is a PDF. Commands that
+// only work on PDFs use it instead of ValidateInputFile.
+func ValidatePDFFile(filename string) error {
+	if err := validateExists(filename); err != nil {
+		return err
 	}
 
 	if !isPDFFile(filename) {
-		return fmt.Errorf("file must be a PDF: %s", filename)
+		return fmt.Errorf("%w: file must be a PDF: %s", ErrInvalidPDF, filename)
 	}
 
 	file, err := os.Open(filename)
@@ -29,13 +52,24 @@ func ValidateInputFile(filename string) error {
 	buffer := make([]byte, 8)
 	n, err := file.Read(buffer)
 	if err != nil {
-		return fmt.Errorf("cannot read file header: %w", err)
+		return fmt.Errorf("%w: cannot read file header: %w", ErrInvalidPDF, err)
 	}
 
 	if n < 4 || !strings.HasPrefix(string(buffer), "%PDF") {
-		return fmt.Errorf("file is not a valid PDF: missing PDF header")
+		return fmt.Errorf("%w: missing PDF header", ErrInvalidPDF)
+	}
+
+	return nil
+}
+
+func validateExists(filename string) error {
+	if filename == "" {
+		return fmt.Errorf("%w: filename cannot be empty", ErrInputNotFound)
 	}
 
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return fmt.Errorf("%w: file does not exist: %s", ErrInputNotFound, filename)
+	}
 	return nil
 }
 
@@ -44,17 +78,99 @@ func isPDFFile(filename string) bool {
 	return ext == ".pdf"
 }
 
+// inputSignatures are the file headers of the accepted inputs other than
+// PDF, none for plain text
+var inputSignatures = map[string][]string{
+	".jpg":  {"\xff\xd8\xff"},
+	".jpeg": {"\xff\xd8\xff"},
+	".png":  {"\x89PNG\r\n\x1a\n"},
+	".tif":  {"II*\x00", "MM\x00*"},
+	".tiff": {"II*\x00", "MM\x00*"},
+	".docx": {"PK\x03\x04"},
+	".odt":  {"PK\x03\x04"},
+	".rtf":  {"{\\rtf"},
+	".txt":  nil,
+}
+
+// DetectInputExtension returns the extension of an accepted input by its
+// content, for data without a file name such as stdin. Text and unknown
+// data are taken as a PDF.
+func DetectInputExtension(data []byte) string {
+	for _, ext := range []string{".jpg", ".png", ".tif", ".rtf"} {
+		for _, signature := range inputSignatures[ext] {
+			if bytes.HasPrefix(data, []byte(signature)) {
+				return ext
+			}
+		}
+	}
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		// OpenDocument files start with an uncompressed mimetype entry
+		if bytes.Contains(data[:min(len(data), 128)], []byte("application/vnd.oasis.opendocument.text")) {
+			return ".odt"
+		}
+		return ".docx"
+	}
+	return ".pdf"
+}
+
+// IsImageFile reports whether filename is a JPEG, PNG or TIFF image by its extension
+func IsImageFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jpg", ".jpeg", ".png", ".tif", ".tiff":
+		return true
+	}
+	return false
+}
+
+// IsOfficeFile reports whether filename is a Word, OpenDocument, RTF or
+// text document by its extension
+func IsOfficeFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".docx", ".odt", ".rtf", ".txt":
+		return true
+	}
+	return false
+}
+
+// validateHeader checks that a file other than a PDF starts like its extension says
+func validateHeader(filename string) error {
+	ext := strings.ToLower(filepath.Ext(filename))
+	signatures := inputSignatures[ext]
+	if len(signatures) == 0 {
+		return nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("cannot read file: %w", err)
+	}
+	defer file.Close()
+
+	buffer := make([]byte, 8)
+	n, err := file.Read(buffer)
+	if err != nil {
+		return fmt.Errorf("%w: cannot read file header: %w", ErrUnreadableInput, err)
+	}
+
+	for _, signature := range signatures {
+		if strings.HasPrefix(string(buffer[:n]), signature) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: file is not a valid %s file", ErrUnreadableInput, strings.ToUpper(strings.TrimPrefix(ext, ".")))
+}
+
 func ValidateOutputPath(filename string) error {
 	dir := filepath.Dir(filename)
 
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		return fmt.Errorf("output directory does not exist: %s", dir)
+		return fmt.Errorf("%w: output directory does not exist: %s", ErrOutputNotWritable, dir)
 	}
 
 	tempFile := filepath.Join(dir, ".pdf2letterexpress_write_test")
 	file, err := os.Create(tempFile)
 	if err != nil {
-		return fmt.Errorf("output directory is not writable: %w", err)
+		return fmt.Errorf("%w: output directory is not writable: %w", ErrOutputNotWritable, err)
 	}
 
 	file.Close()